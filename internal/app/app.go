@@ -1,6 +1,8 @@
 package app
 
 import (
+	"github.com/traweezy/tacticboard/internal/auth"
+	"github.com/traweezy/tacticboard/internal/bus"
 	"github.com/traweezy/tacticboard/internal/config"
 	"github.com/traweezy/tacticboard/internal/http"
 	"github.com/traweezy/tacticboard/internal/logger"
@@ -16,11 +18,13 @@ var Module = fx.Module(
 	"tacticboard",
 	fx.Provide(
 		config.Load,
-		ws.NewHub,
 	),
 	logger.Module,
 	observability.Module,
 	util.Module,
 	store.Module,
+	auth.Module,
+	bus.Module,
 	http.Module,
+	ws.Module,
 )
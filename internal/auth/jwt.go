@@ -0,0 +1,403 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+// Supported JWS algorithms for external tokens.
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algEdDSA = "EdDSA"
+)
+
+// jwtLeeway tolerates clock skew between the identity provider and us.
+const jwtLeeway = 30 * time.Second
+
+var errJWTKeyNotFound = errors.New("no key matches token")
+
+// jwtKey is a verification key loaded from a JWKS file or a PEM.
+type jwtKey struct {
+	id  string
+	alg string
+	key any
+}
+
+// JWTVerifier verifies JWTs issued by an external identity provider and maps
+// them onto capability claims.
+type JWTVerifier struct {
+	issuer    string
+	audience  string
+	jwksPath  string
+	pemPaths  []string
+	roomClaim string
+	roleClaim string
+	now       func() time.Time
+
+	mu   sync.RWMutex
+	keys []jwtKey
+}
+
+// NewJWTVerifier returns nil when no external issuer is configured.
+func NewJWTVerifier(cfg config.Config) (*JWTVerifier, error) {
+	if cfg.JWTExternalIssuer == "" {
+		return nil, nil
+	}
+
+	v := &JWTVerifier{
+		issuer:    cfg.JWTExternalIssuer,
+		audience:  cfg.JWTExternalAudience,
+		jwksPath:  cfg.JWTExternalJWKSFile,
+		pemPaths:  cfg.JWTExternalPEMFiles,
+		roomClaim: cfg.JWTExternalRoomClaim,
+		roleClaim: cfg.JWTExternalRoleClaim,
+		now:       time.Now,
+	}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload re-reads the JWKS file and PEMs. On error the current keys stay in use.
+func (v *JWTVerifier) Reload() error {
+	var keys []jwtKey
+	if v.jwksPath != "" {
+		loaded, err := loadJWKS(v.jwksPath)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, loaded...)
+	}
+	for _, path := range v.pemPaths {
+		key, err := loadPEM(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return errors.New("external jwt verifier has no keys")
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+// Verify checks the token's signature, issuer, audience and lifetime and
+// maps its claims onto a room capability.
+func (v *JWTVerifier) Verify(token string) (util.CapabilityClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return util.CapabilityClaims{}, errors.New("malformed jwt")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return util.CapabilityClaims{}, fmt.Errorf("decode jwt header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return util.CapabilityClaims{}, errors.New("malformed jwt signature")
+	}
+	if err := v.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return util.CapabilityClaims{}, err
+	}
+
+	var body map[string]json.RawMessage
+	if err := decodeJSONSegment(parts[1], &body); err != nil {
+		return util.CapabilityClaims{}, fmt.Errorf("decode jwt claims: %w", err)
+	}
+	return v.mapClaims(body, header.Kid)
+}
+
+func (v *JWTVerifier) verifySignature(alg, kid string, signed, signature []byte) error {
+	switch alg {
+	case algHS256, algRS256, algEdDSA:
+	default:
+		return fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+
+	v.mu.RLock()
+	keys := v.keys
+	v.mu.RUnlock()
+
+	for _, key := range keys {
+		if key.alg != alg || (kid != "" && key.id != kid) {
+			continue
+		}
+		if verifyWithKey(key, signed, signature) {
+			return nil
+		}
+		if kid != "" {
+			return errors.New("invalid jwt signature")
+		}
+	}
+	return errJWTKeyNotFound
+}
+
+func verifyWithKey(key jwtKey, signed, signature []byte) bool {
+	switch k := key.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed) //nolint:errcheck // sha256 hash write never fails
+		return hmac.Equal(signature, mac.Sum(nil))
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, signed, signature)
+	default:
+		return false
+	}
+}
+
+func (v *JWTVerifier) mapClaims(body map[string]json.RawMessage, kid string) (util.CapabilityClaims, error) {
+	var issuer string
+	if err := claimValue(body, "iss", &issuer); err != nil || issuer != v.issuer {
+		return util.CapabilityClaims{}, errors.New("unexpected jwt issuer")
+	}
+
+	if v.audience != "" && !hasAudience(body["aud"], v.audience) {
+		return util.CapabilityClaims{}, errors.New("unexpected jwt audience")
+	}
+
+	now := v.now()
+	var exp, nbf, iat float64
+	if err := claimValue(body, "exp", &exp); err != nil || exp == 0 {
+		return util.CapabilityClaims{}, errors.New("jwt exp required")
+	}
+	expiresAt := time.Unix(int64(exp), 0).UTC()
+	if now.After(expiresAt.Add(jwtLeeway)) {
+		return util.CapabilityClaims{}, errors.New("jwt expired")
+	}
+	if claimValue(body, "nbf", &nbf) == nil && nbf != 0 && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return util.CapabilityClaims{}, errors.New("jwt not yet valid")
+	}
+	issuedAt := now.UTC()
+	if claimValue(body, "iat", &iat) == nil && iat != 0 {
+		issuedAt = time.Unix(int64(iat), 0).UTC()
+	}
+	if issuedAt.After(expiresAt) {
+		issuedAt = expiresAt
+	}
+
+	claims := util.CapabilityClaims{
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt,
+		KeyID:     kid,
+		Issuer:    issuer,
+	}
+	var role string
+	if err := claimValue(body, v.roomClaim, &claims.RoomID); err != nil || claims.RoomID == "" {
+		return util.CapabilityClaims{}, fmt.Errorf("jwt %s claim required", v.roomClaim)
+	}
+	if err := claimValue(body, v.roleClaim, &role); err != nil {
+		return util.CapabilityClaims{}, fmt.Errorf("jwt %s claim required", v.roleClaim)
+	}
+	claims.Role = util.CapabilityRole(role)
+	if !claims.Role.Valid() {
+		return util.CapabilityClaims{}, fmt.Errorf("jwt role %q not recognised", role)
+	}
+	_ = claimValue(body, "jti", &claims.TokenID)
+	_ = claimValue(body, "sub", &claims.Subject)
+	_ = claimValue(body, "name", &claims.DisplayName)
+	if claims.TokenID == "" {
+		claims.TokenID = derivedTokenID(body)
+	}
+	return claims, nil
+}
+
+// derivedTokenID names a JWT without a jti after its issuer, subject and
+// issue and expiry times, so the token can still be revoked and credited as
+// an author. Every re-verification of the same token yields the same id.
+func derivedTokenID(body map[string]json.RawMessage) string {
+	digest := sha256.New()
+	for _, name := range []string{"iss", "sub", "iat", "exp"} {
+		digest.Write(body[name])
+		digest.Write([]byte{0})
+	}
+	return "jwt-" + hex.EncodeToString(digest.Sum(nil)[:16])
+}
+
+// claimValue decodes a claim into dst. A missing claim leaves dst untouched
+// and returns an error.
+func claimValue(body map[string]json.RawMessage, name string, dst any) error {
+	raw, ok := body[name]
+	if !ok {
+		return fmt.Errorf("claim %q missing", name)
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func hasAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, aud := range many {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJSONSegment(segment string, dst any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// looksLikeJWT reports whether token has a JOSE header rather than being one
+// of our own capability tokens.
+func looksLikeJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "v2" {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	return decodeJSONSegment(parts[0], &header) == nil && header.Alg != ""
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+func loadJWKS(path string) ([]jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (jwtKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "oct":
+		secret, err := decode(k.K)
+		if err != nil || len(secret) < minSecretLength {
+			return jwtKey{}, errors.New("invalid oct key")
+		}
+		return jwtKey{id: k.Kid, alg: algHS256, key: secret}, nil
+	case "RSA":
+		n, errN := decode(k.N)
+		e, errE := decode(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return jwtKey{}, errors.New("invalid rsa key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return jwtKey{id: k.Kid, alg: algRS256, key: pub}, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return jwtKey{}, errors.New("invalid Ed25519 key")
+		}
+		return jwtKey{id: k.Kid, alg: algEdDSA, key: ed25519.PublicKey(x)}, nil
+	default:
+		return jwtKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// loadPEM reads a public key or certificate. Its key id is the file name
+// without extension.
+func loadPEM(path string) (jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("read pem: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return jwtKey{}, fmt.Errorf("pem %s: no pem block", path)
+	}
+
+	var pub any
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		err = fmt.Errorf("unsupported pem block %q", block.Type)
+	}
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("pem %s: %w", path, err)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwtKey{id: id, alg: algRS256, key: k}, nil
+	case ed25519.PublicKey:
+		return jwtKey{id: id, alg: algEdDSA, key: k}, nil
+	default:
+		return jwtKey{}, fmt.Errorf("pem %s: unsupported key type %T", path, pub)
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const (
+	testIssuer   = "https://id.example.club"
+	testAudience = "tacticboard"
+)
+
+var hmacKey = []byte("external-hmac-secret-0123456789")
+
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	body, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwtClaims(overrides map[string]any) map[string]any {
+	now := time.Now()
+	claims := map[string]any{
+		"iss":  testIssuer,
+		"aud":  []string{"other", testAudience},
+		"sub":  "user-42",
+		"name": "Coach Kim",
+		"jti":  "ext-1",
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+		"room": "room-1",
+		"role": "edit",
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+type jwtFixture struct {
+	verifier *JWTVerifier
+	rsaKey   *rsa.PrivateKey
+	edKey    ed25519.PrivateKey
+}
+
+func newJWTFixture(t *testing.T) jwtFixture {
+	t.Helper()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pemPath := filepath.Join(dir, "idp-rsa.pem")
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(hmacKey)},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edPub)},
+	}})
+	require.NoError(t, err)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+
+	verifier, err := NewJWTVerifier(config.Config{
+		JWTExternalIssuer:    testIssuer,
+		JWTExternalAudience:  testAudience,
+		JWTExternalJWKSFile:  jwksPath,
+		JWTExternalPEMFiles:  []string{pemPath},
+		JWTExternalRoomClaim: "room",
+		JWTExternalRoleClaim: "role",
+	})
+	require.NoError(t, err)
+	return jwtFixture{verifier: verifier, rsaKey: rsaKey, edKey: edKey}
+}
+
+func TestJWTVerifierAlgorithms(t *testing.T) {
+	f := newJWTFixture(t)
+
+	tokens := map[string]string{
+		algHS256: signJWT(t, algHS256, "hs", hmacKey, jwtClaims(nil)),
+		algRS256: signJWT(t, algRS256, "idp-rsa", f.rsaKey, jwtClaims(nil)),
+		algEdDSA: signJWT(t, algEdDSA, "", f.edKey, jwtClaims(nil)),
+	}
+	for alg, token := range tokens {
+		t.Run(alg, func(t *testing.T) {
+			claims, err := f.verifier.Verify(token)
+			require.NoError(t, err)
+			require.Equal(t, "room-1", claims.RoomID)
+			require.Equal(t, util.RoleEdit, claims.Role)
+			require.Equal(t, "user-42", claims.Subject)
+			require.Equal(t, "Coach Kim", claims.DisplayName)
+			require.Equal(t, "ext-1", claims.TokenID)
+			require.Equal(t, testIssuer, claims.Issuer)
+		})
+	}
+}
+
+func TestJWTVerifierDerivesTokenIDWithoutJTI(t *testing.T) {
+	f := newJWTFixture(t)
+
+	token := signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"jti": nil}))
+	first, err := f.verifier.Verify(token)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.TokenID)
+	second, err := f.verifier.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, first.TokenID, second.TokenID, "the id is stable across verifications")
+
+	other := signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"jti": nil, "sub": "user-7"}))
+	claims, err := f.verifier.Verify(other)
+	require.NoError(t, err)
+	require.NotEqual(t, first.TokenID, claims.TokenID)
+}
+
+func TestJWTVerifierRejects(t *testing.T) {
+	f := newJWTFixture(t)
+	past := time.Now().Add(-time.Hour).Unix()
+
+	cases := map[string]string{
+		"issuer":     signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"iss": "https://evil.example"})),
+		"audience":   signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"aud": "other"})),
+		"expired":    signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"exp": past})),
+		"no room":    signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"room": nil})),
+		"bad role":   signJWT(t, algHS256, "hs", hmacKey, jwtClaims(map[string]any{"role": "admin"})),
+		"alg none":   signJWT(t, "none", "", nil, jwtClaims(nil)),
+		"wrong key":  signJWT(t, algHS256, "hs", []byte("some-other-secret-0123456789"), jwtClaims(nil)),
+		"confusion":  signJWT(t, algHS256, "idp-rsa", hmacKey, jwtClaims(nil)),
+		"unknown id": signJWT(t, algEdDSA, "missing", f.edKey, jwtClaims(nil)),
+	}
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := f.verifier.Verify(token)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestVerifierAcceptsBothTokenTypes(t *testing.T) {
+	f := newJWTFixture(t)
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	_, err := st.CreateRoom(ctx, model.Room{ID: "room-1"})
+	require.NoError(t, err)
+
+	keys := NewStaticKeyring(fallbackSecret)
+	verifier := NewVerifier(keys, f.verifier, st)
+
+	own, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+	_, err = verifier.Verify(ctx, own)
+	require.NoError(t, err)
+
+	external := signJWT(t, algRS256, "idp-rsa", f.rsaKey, jwtClaims(nil))
+	claims, err := verifier.Verify(ctx, external)
+	require.NoError(t, err)
+	require.Equal(t, "user-42", claims.Subject)
+
+	require.NoError(t, st.RevokeToken(ctx, model.RevokedToken{RoomID: "room-1", TokenID: "ext-1"}))
+	_, err = verifier.Verify(ctx, external)
+	require.ErrorIs(t, err, ErrRevoked)
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+// DefaultKeyID names the key that verifies tokens which carry no key id,
+// i.e. every token minted before key rotation existed.
+const DefaultKeyID = "default"
+
+const minSecretLength = 16
+
+var (
+	errUnknownKey = errors.New("unknown signing key")
+	errRetiredKey = errors.New("signing key retired")
+)
+
+// SigningKey is one secret of the keyring. A zero RetiresAt never retires.
+type SigningKey struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	RetiresAt time.Time `json:"retiresAt"`
+}
+
+// keyringFile is the JSON layout of JWT_KEYRING_FILE.
+type keyringFile struct {
+	Active string       `json:"active"`
+	Keys   []SigningKey `json:"keys"`
+}
+
+type keySet struct {
+	active SigningKey
+	keys   map[string]SigningKey
+}
+
+// Keyring holds the secrets capability tokens are signed and verified with.
+// One key is active for signing; the others only verify until they retire.
+// It is safe for concurrent use and can be reloaded while serving.
+type Keyring struct {
+	path     string
+	fallback string
+	now      func() time.Time
+
+	mu  sync.RWMutex
+	set keySet
+}
+
+// NewKeyring loads JWT_KEYRING_FILE, or uses JWT_SECRET as the only key when
+// no file is configured. With a file, JWT_SECRET keeps verifying tokens
+// without a key id unless the file defines a "default" key itself.
+func NewKeyring(cfg config.Config) (*Keyring, error) {
+	k := &Keyring{
+		path:     cfg.JWTKeyringFile,
+		fallback: cfg.JWTSecret,
+		now:      time.Now,
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewStaticKeyring returns a keyring with secret as its only, active key.
+func NewStaticKeyring(secret string) *Keyring {
+	key := SigningKey{ID: DefaultKeyID, Secret: secret}
+	return &Keyring{
+		fallback: secret,
+		now:      time.Now,
+		set:      keySet{active: key, keys: map[string]SigningKey{DefaultKeyID: key}},
+	}
+}
+
+// Reload re-reads the keyring file. On error the current keys stay in use.
+func (k *Keyring) Reload() error {
+	set, err := k.load()
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.set = set
+	k.mu.Unlock()
+	return nil
+}
+
+// ActiveKeyID returns the id of the key new tokens are signed with.
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.set.active.ID
+}
+
+// Sign mints a token for claims with the active key and records its id in
+// the token. It fails once the active key has retired, since nothing could
+// verify the token; rotate to a new key before then.
+func (k *Keyring) Sign(claims util.CapabilityClaims) (string, error) {
+	k.mu.RLock()
+	active := k.set.active
+	k.mu.RUnlock()
+
+	if !active.RetiresAt.IsZero() && !k.now().Before(active.RetiresAt) {
+		return "", fmt.Errorf("%w %q", errRetiredKey, active.ID)
+	}
+	claims.KeyID = active.ID
+	return util.GenerateCapabilityToken([]byte(active.Secret), claims)
+}
+
+// Parse verifies token against the key it names.
+func (k *Keyring) Parse(token string) (util.CapabilityClaims, error) {
+	return util.ParseCapabilityTokenWithKeys(k.lookup, token)
+}
+
+func (k *Keyring) lookup(keyID string) ([]byte, error) {
+	if keyID == "" {
+		keyID = DefaultKeyID
+	}
+
+	k.mu.RLock()
+	key, ok := k.set.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownKey, keyID)
+	}
+	if !key.RetiresAt.IsZero() && !k.now().Before(key.RetiresAt) {
+		return nil, fmt.Errorf("%w %q", errRetiredKey, keyID)
+	}
+	return []byte(key.Secret), nil
+}
+
+func (k *Keyring) load() (keySet, error) {
+	if k.path == "" {
+		key := SigningKey{ID: DefaultKeyID, Secret: k.fallback}
+		return keySet{active: key, keys: map[string]SigningKey{DefaultKeyID: key}}, nil
+	}
+
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return keySet{}, fmt.Errorf("read keyring: %w", err)
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return keySet{}, fmt.Errorf("decode keyring: %w", err)
+	}
+
+	set := keySet{keys: make(map[string]SigningKey, len(file.Keys)+1)}
+	for _, key := range file.Keys {
+		if key.ID == "" {
+			return keySet{}, errors.New("keyring key without id")
+		}
+		if len(key.Secret) < minSecretLength {
+			return keySet{}, fmt.Errorf("keyring key %q: secret must be at least %d characters", key.ID, minSecretLength)
+		}
+		if _, dup := set.keys[key.ID]; dup {
+			return keySet{}, fmt.Errorf("keyring key %q defined twice", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+
+	active, ok := set.keys[file.Active]
+	if !ok {
+		return keySet{}, fmt.Errorf("keyring active key %q not defined", file.Active)
+	}
+	if !active.RetiresAt.IsZero() && !k.now().Before(active.RetiresAt) {
+		return keySet{}, fmt.Errorf("keyring active key %q is retired", file.Active)
+	}
+	set.active = active
+
+	if _, ok := set.keys[DefaultKeyID]; !ok && k.fallback != "" {
+		set.keys[DefaultKeyID] = SigningKey{ID: DefaultKeyID, Secret: k.fallback}
+	}
+	return set, nil
+}
+
+// registerReload reloads the keyring and the external JWT keys on SIGHUP
+// when they come from files.
+func registerReload(lc fx.Lifecycle, keys *Keyring, jwt *JWTVerifier, log *zap.Logger) {
+	reloaders := map[string]func() error{}
+	if keys.path != "" {
+		reloaders["keyring"] = keys.Reload
+	}
+	if jwt != nil {
+		reloaders["jwt keys"] = jwt.Reload
+	}
+	if len(reloaders) == 0 {
+		return
+	}
+
+	log = log.Named("keyring")
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			signal.Notify(signals, syscall.SIGHUP)
+			go func() {
+				for {
+					select {
+					case <-signals:
+						for name, reload := range reloaders {
+							if err := reload(); err != nil {
+								log.Error("reload "+name, zap.Error(err))
+								continue
+							}
+							log.Info(name+" reloaded", zap.String("active", keys.ActiveKeyID()))
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			signal.Stop(signals)
+			close(done)
+			return nil
+		},
+	})
+}
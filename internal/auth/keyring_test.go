@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const fallbackSecret = "fallback-secret-0123"
+
+func writeKeyring(t *testing.T, path string, file keyringFile) {
+	t.Helper()
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func testClaims() util.CapabilityClaims {
+	now := time.Now()
+	return util.CapabilityClaims{RoomID: "room-1", Role: util.RoleView, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyring(t, path, keyringFile{
+		Active: "k1",
+		Keys:   []SigningKey{{ID: "k1", Secret: "first-secret-0123456"}},
+	})
+
+	keys, err := NewKeyring(config.Config{JWTSecret: fallbackSecret, JWTKeyringFile: path})
+	require.NoError(t, err)
+
+	legacy, err := util.GenerateCapabilityToken([]byte(fallbackSecret), testClaims())
+	require.NoError(t, err)
+	oldToken, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+
+	claims, err := keys.Parse(oldToken)
+	require.NoError(t, err)
+	require.Equal(t, "k1", claims.KeyID)
+	_, err = keys.Parse(legacy)
+	require.NoError(t, err, "tokens without a key id verify with JWT_SECRET")
+
+	retiresAt := time.Now().Add(time.Hour)
+	writeKeyring(t, path, keyringFile{
+		Active: "k2",
+		Keys: []SigningKey{
+			{ID: "k1", Secret: "first-secret-0123456", RetiresAt: retiresAt},
+			{ID: "k2", Secret: "second-secret-012345"},
+		},
+	})
+	require.NoError(t, keys.Reload())
+	require.Equal(t, "k2", keys.ActiveKeyID())
+
+	newToken, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+	claims, err = keys.Parse(newToken)
+	require.NoError(t, err)
+	require.Equal(t, "k2", claims.KeyID)
+
+	_, err = keys.Parse(oldToken)
+	require.NoError(t, err, "retiring keys verify until their retirement")
+
+	keys.now = func() time.Time { return retiresAt }
+	_, err = keys.Parse(oldToken)
+	require.ErrorIs(t, err, errRetiredKey)
+	_, err = keys.Parse(newToken)
+	require.NoError(t, err)
+}
+
+func TestKeyringRefusesToSignWithRetiredKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	retiresAt := time.Now().Add(time.Hour)
+	writeKeyring(t, path, keyringFile{
+		Active: "k1",
+		Keys:   []SigningKey{{ID: "k1", Secret: "first-secret-0123456", RetiresAt: retiresAt}},
+	})
+
+	keys, err := NewKeyring(config.Config{JWTKeyringFile: path})
+	require.NoError(t, err)
+	_, err = keys.Sign(testClaims())
+	require.NoError(t, err)
+
+	keys.now = func() time.Time { return retiresAt }
+	_, err = keys.Sign(testClaims())
+	require.ErrorIs(t, err, errRetiredKey)
+}
+
+func TestKeyringReloadKeepsKeysOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyring(t, path, keyringFile{
+		Active: "k1",
+		Keys:   []SigningKey{{ID: "k1", Secret: "first-secret-0123456"}},
+	})
+	keys, err := NewKeyring(config.Config{JWTSecret: fallbackSecret, JWTKeyringFile: path})
+	require.NoError(t, err)
+
+	writeKeyring(t, path, keyringFile{Active: "missing"})
+	require.Error(t, keys.Reload())
+	require.Equal(t, "k1", keys.ActiveKeyID())
+
+	writeKeyring(t, path, keyringFile{Active: "k1", Keys: []SigningKey{{ID: "k1", Secret: "short"}}})
+	require.Error(t, keys.Reload())
+}
+
+func TestKeyringRejectsUnknownKey(t *testing.T) {
+	keys := NewStaticKeyring(fallbackSecret)
+	claims := testClaims()
+	claims.KeyID = "elsewhere"
+	token, err := util.GenerateCapabilityToken([]byte(fallbackSecret), claims)
+	require.NoError(t, err)
+
+	_, err = keys.Parse(token)
+	require.ErrorIs(t, err, errUnknownKey)
+}
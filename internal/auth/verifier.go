@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/fx"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+var (
+	// ErrInvalidToken wraps any failure to parse or verify a capability token.
+	ErrInvalidToken = errors.New("invalid capability token")
+	// ErrRevoked reports a well-formed token that was revoked for its room.
+	ErrRevoked = errors.New("capability token revoked")
+)
+
+// Module provides the signing keyring and the capability verifier.
+var Module = fx.Module(
+	"auth",
+	fx.Provide(NewKeyring, NewJWTVerifier, NewVerifier),
+	fx.Invoke(registerReload),
+)
+
+// Verifier checks capability tokens presented to REST handlers and the hub.
+// It accepts our own capability tokens and, when an external issuer is
+// configured, JWTs from that identity provider.
+type Verifier struct {
+	keys  *Keyring
+	jwt   *JWTVerifier
+	store store.Store
+}
+
+// NewVerifier constructs a verifier backed by the keyring and the store's
+// revocation list. jwt may be nil to accept only our own tokens.
+func NewVerifier(keys *Keyring, jwt *JWTVerifier, store store.Store) *Verifier {
+	return &Verifier{
+		keys:  keys,
+		jwt:   jwt,
+		store: store,
+	}
+}
+
+// Verify parses token and rejects it when it was revoked. It does not check
+// that the room exists.
+func (v *Verifier) Verify(ctx context.Context, token string) (util.CapabilityClaims, error) {
+	var (
+		claims util.CapabilityClaims
+		err    error
+	)
+	if v.jwt != nil && looksLikeJWT(token) {
+		claims, err = v.jwt.Verify(token)
+	} else {
+		claims, err = v.keys.Parse(token)
+	}
+	if err != nil {
+		return util.CapabilityClaims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.TokenID != "" {
+		revoked, err := v.store.IsTokenRevoked(ctx, claims.RoomID, claims.TokenID)
+		if err != nil && !errors.Is(err, model.ErrRoomNotFound) {
+			return util.CapabilityClaims{}, fmt.Errorf("check revocation: %w", err)
+		}
+		if revoked {
+			return util.CapabilityClaims{}, ErrRevoked
+		}
+	}
+
+	return claims, nil
+}
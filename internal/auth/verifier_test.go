@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+)
+
+// version1Token signs a room|role|iat|exp payload without a jti the way
+// tokens were minted before version 2.
+func version1Token(secret string, exp time.Time) string {
+	payload := fmt.Sprintf("room-1|edit|%d|%d", time.Now().Unix(), exp.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifierRevokesVersion1TokenWithoutID(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	_, err := st.CreateRoom(ctx, model.Room{ID: "room-1"})
+	require.NoError(t, err)
+	verifier := NewVerifier(NewStaticKeyring(fallbackSecret), nil, st)
+
+	leaked := version1Token(fallbackSecret, time.Now().Add(time.Hour))
+	kept := version1Token(fallbackSecret, time.Now().Add(2*time.Hour))
+
+	claims, err := verifier.Verify(ctx, leaked)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.TokenID)
+	require.NoError(t, st.RevokeToken(ctx, model.RevokedToken{RoomID: "room-1", TokenID: claims.TokenID}))
+
+	_, err = verifier.Verify(ctx, leaked)
+	require.ErrorIs(t, err, ErrRevoked)
+	_, err = verifier.Verify(ctx, kept)
+	require.NoError(t, err, "other version 1 tokens of the room stay valid")
+}
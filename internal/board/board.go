@@ -0,0 +1,224 @@
+package board
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NodeKind identifies the shape rendered for a board node.
+type NodeKind string
+
+// OpKind identifies the mutation carried by an Op.
+type OpKind string
+
+const (
+	// OpAdd inserts (or replaces) a node.
+	OpAdd OpKind = "add"
+	// OpMove repositions an existing node.
+	OpMove OpKind = "move"
+	// OpPatch merges a partial node into an existing node.
+	OpPatch OpKind = "patch"
+	// OpRemove deletes a node.
+	OpRemove OpKind = "remove"
+)
+
+// Node is a single element drawn on the board.
+type Node struct {
+	ID       string    `json:"id"`
+	Kind     NodeKind  `json:"kind"`
+	X        float64   `json:"x"`
+	Y        float64   `json:"y"`
+	Rotation *float64  `json:"rotation,omitempty"`
+	Color    *string   `json:"color,omitempty"`
+	Label    *string   `json:"label,omitempty"`
+	Layer    *string   `json:"layer,omitempty"`
+	Points   []float64 `json:"points,omitempty"`
+}
+
+// NodePatch carries the subset of node fields changed by a patch op.
+type NodePatch struct {
+	Kind     *NodeKind  `json:"kind,omitempty"`
+	X        *float64   `json:"x,omitempty"`
+	Y        *float64   `json:"y,omitempty"`
+	Rotation *float64   `json:"rotation,omitempty"`
+	Color    *string    `json:"color,omitempty"`
+	Label    *string    `json:"label,omitempty"`
+	Layer    *string    `json:"layer,omitempty"`
+	Points   *[]float64 `json:"points,omitempty"`
+}
+
+// Op is a single board mutation as sent by editors.
+type Op struct {
+	Kind    OpKind     `json:"k"`
+	ID      string     `json:"id,omitempty"`
+	X       *float64   `json:"x,omitempty"`
+	Y       *float64   `json:"y,omitempty"`
+	Node    *Node      `json:"node,omitempty"`
+	Changes *NodePatch `json:"changes,omitempty"`
+}
+
+// State is the materialized board stored in snapshots.
+type State struct {
+	nodes []Node
+	index map[string]int
+	extra map[string]json.RawMessage
+}
+
+// NewState returns an empty board.
+func NewState() *State {
+	return &State{
+		index: make(map[string]int),
+		extra: make(map[string]json.RawMessage),
+	}
+}
+
+// DecodeState parses a snapshot body. Top-level fields other than nodes are
+// preserved verbatim so layers and meta survive compaction.
+func DecodeState(data []byte) (*State, error) {
+	state := NewState()
+	if len(data) == 0 {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(data, &state.extra); err != nil {
+		return nil, fmt.Errorf("decode board state: %w", err)
+	}
+	if state.extra == nil {
+		state.extra = make(map[string]json.RawMessage)
+	}
+
+	if raw, ok := state.extra["nodes"]; ok {
+		var nodes []Node
+		if err := json.Unmarshal(raw, &nodes); err != nil {
+			return nil, fmt.Errorf("decode board nodes: %w", err)
+		}
+		for _, node := range nodes {
+			state.put(node)
+		}
+		delete(state.extra, "nodes")
+	}
+
+	return state, nil
+}
+
+// Encode serializes the board back into a snapshot body.
+func (s *State) Encode() (json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(s.extra)+1)
+	for key, value := range s.extra {
+		out[key] = value
+	}
+
+	nodes := s.nodes
+	if nodes == nil {
+		nodes = []Node{}
+	}
+	raw, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, err
+	}
+	out["nodes"] = raw
+
+	return json.Marshal(out)
+}
+
+// Nodes returns the nodes in insertion order.
+func (s *State) Nodes() []Node {
+	nodes := make([]Node, len(s.nodes))
+	copy(nodes, s.nodes)
+	return nodes
+}
+
+// Node looks up a node by id.
+func (s *State) Node(id string) (Node, bool) {
+	idx, ok := s.index[id]
+	if !ok {
+		return Node{}, false
+	}
+	return s.nodes[idx], true
+}
+
+// Apply mutates the board with the same semantics as the UI store: move and
+// patch on unknown nodes are ignored, add replaces an existing node.
+func (s *State) Apply(op Op) {
+	switch op.Kind {
+	case OpAdd:
+		if op.Node != nil && op.Node.ID != "" {
+			s.put(*op.Node)
+		}
+	case OpMove:
+		if idx, ok := s.index[op.ID]; ok && op.X != nil && op.Y != nil {
+			s.nodes[idx].X = *op.X
+			s.nodes[idx].Y = *op.Y
+		}
+	case OpPatch:
+		if idx, ok := s.index[op.ID]; ok && op.Changes != nil {
+			s.nodes[idx] = op.Changes.applyTo(s.nodes[idx])
+		}
+	case OpRemove:
+		s.remove(op.ID)
+	}
+}
+
+// ApplyRaw decodes and applies a committed batch. Entries that cannot be
+// decoded are skipped, mirroring the UI, and reported in the returned count.
+func (s *State) ApplyRaw(ops []json.RawMessage) int {
+	skipped := 0
+	for _, raw := range ops {
+		var op Op
+		if err := json.Unmarshal(raw, &op); err != nil {
+			skipped++
+			continue
+		}
+		s.Apply(op)
+	}
+	return skipped
+}
+
+func (s *State) put(node Node) {
+	if idx, ok := s.index[node.ID]; ok {
+		s.nodes[idx] = node
+		return
+	}
+	s.index[node.ID] = len(s.nodes)
+	s.nodes = append(s.nodes, node)
+}
+
+func (s *State) remove(id string) {
+	idx, ok := s.index[id]
+	if !ok {
+		return
+	}
+	s.nodes = append(s.nodes[:idx], s.nodes[idx+1:]...)
+	delete(s.index, id)
+	for i := idx; i < len(s.nodes); i++ {
+		s.index[s.nodes[i].ID] = i
+	}
+}
+
+func (p NodePatch) applyTo(node Node) Node {
+	if p.Kind != nil {
+		node.Kind = *p.Kind
+	}
+	if p.X != nil {
+		node.X = *p.X
+	}
+	if p.Y != nil {
+		node.Y = *p.Y
+	}
+	if p.Rotation != nil {
+		node.Rotation = p.Rotation
+	}
+	if p.Color != nil {
+		node.Color = p.Color
+	}
+	if p.Label != nil {
+		node.Label = p.Label
+	}
+	if p.Layer != nil {
+		node.Layer = p.Layer
+	}
+	if p.Points != nil {
+		node.Points = *p.Points
+	}
+	return node
+}
@@ -0,0 +1,63 @@
+package board
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateApplyRaw(t *testing.T) {
+	state, err := DecodeState([]byte(`{"nodes":[],"layers":[{"id":"attack"}],"meta":{}}`))
+	require.NoError(t, err)
+
+	skipped := state.ApplyRaw([]json.RawMessage{
+		json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":1,"y":2}}`),
+		json.RawMessage(`{"k":"add","node":{"id":"c1","kind":"cone","x":5,"y":5}}`),
+		json.RawMessage(`{"k":"move","id":"p1","x":10,"y":20}`),
+		json.RawMessage(`{"k":"patch","id":"p1","changes":{"label":"9","color":"#f00"}}`),
+		json.RawMessage(`{"k":"move","id":"ghost","x":1,"y":1}`),
+		json.RawMessage(`{"k":"remove","id":"c1"}`),
+		json.RawMessage(`not-json`),
+	})
+	require.Equal(t, 1, skipped)
+
+	nodes := state.Nodes()
+	require.Len(t, nodes, 1)
+	require.Equal(t, "p1", nodes[0].ID)
+	require.EqualValues(t, 10, nodes[0].X)
+	require.EqualValues(t, 20, nodes[0].Y)
+	require.Equal(t, "9", *nodes[0].Label)
+	require.Equal(t, "#f00", *nodes[0].Color)
+}
+
+func TestStateEncodePreservesExtraFields(t *testing.T) {
+	state, err := DecodeState([]byte(`{"nodes":[{"id":"a","kind":"zone","x":0,"y":0}],"layers":[1],"meta":{"title":"drill"}}`))
+	require.NoError(t, err)
+
+	state.Apply(Op{Kind: OpRemove, ID: "a"})
+
+	body, err := state.Encode()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"nodes":[],"layers":[1],"meta":{"title":"drill"}}`, string(body))
+}
+
+func TestStateRemoveKeepsOrder(t *testing.T) {
+	state := NewState()
+	for _, id := range []string{"a", "b", "c"} {
+		state.Apply(Op{Kind: OpAdd, Node: &Node{ID: id, Kind: "cone"}})
+	}
+	state.Apply(Op{Kind: OpRemove, ID: "a"})
+
+	x, y := 3.0, 4.0
+	state.Apply(Op{Kind: OpMove, ID: "c", X: &x, Y: &y})
+
+	node, ok := state.Node("c")
+	require.True(t, ok)
+	require.EqualValues(t, 3, node.X)
+
+	nodes := state.Nodes()
+	require.Len(t, nodes, 2)
+	require.Equal(t, "b", nodes[0].ID)
+	require.Equal(t, "c", nodes[1].ID)
+}
@@ -0,0 +1,41 @@
+package board
+
+// Rebase transforms ops authored against an older board so they can be
+// applied after the concurrent ops committed in the meantime.
+//
+// Moves and patches from the rebased batch are applied last, so they win field
+// by field over concurrent edits. A node removed concurrently stays removed:
+// moves, patches and removes that target it are dropped. Re-adding the node,
+// either concurrently or earlier in the batch, lifts the tombstone.
+func Rebase(ops []Op, concurrent []Op) []Op {
+	removed := make(map[string]struct{})
+	for _, op := range concurrent {
+		switch op.Kind {
+		case OpRemove:
+			removed[op.ID] = struct{}{}
+		case OpAdd:
+			if op.Node != nil {
+				delete(removed, op.Node.ID)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return ops
+	}
+
+	rebased := make([]Op, 0, len(ops))
+	for _, op := range ops {
+		switch op.Kind {
+		case OpAdd:
+			if op.Node != nil {
+				delete(removed, op.Node.ID)
+			}
+		case OpMove, OpPatch, OpRemove:
+			if _, gone := removed[op.ID]; gone {
+				continue
+			}
+		}
+		rebased = append(rebased, op)
+	}
+	return rebased
+}
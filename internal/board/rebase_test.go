@@ -0,0 +1,62 @@
+package board
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebaseDropsOpsOnConcurrentlyRemovedNodes(t *testing.T) {
+	x, y := 1.0, 2.0
+	label := "GK"
+	ops := []Op{
+		{Kind: OpMove, ID: "p1", X: &x, Y: &y},
+		{Kind: OpPatch, ID: "p2", Changes: &NodePatch{Label: &label}},
+		{Kind: OpRemove, ID: "p1"},
+		{Kind: OpAdd, Node: &Node{ID: "p3", Kind: KindPlayer}},
+		{Kind: OpMove, ID: "p3", X: &x, Y: &y},
+	}
+	concurrent := []Op{
+		{Kind: OpRemove, ID: "p1"},
+		{Kind: OpRemove, ID: "p3"},
+		{Kind: OpMove, ID: "p2", X: &y, Y: &x},
+	}
+
+	rebased := Rebase(ops, concurrent)
+	require.Len(t, rebased, 3)
+	require.Equal(t, OpPatch, rebased[0].Kind)
+	require.Equal(t, OpAdd, rebased[1].Kind)
+	require.Equal(t, OpMove, rebased[2].Kind)
+	require.Equal(t, "p3", rebased[2].ID)
+}
+
+func TestRebaseLastWriterWinsPerField(t *testing.T) {
+	state := NewState()
+	state.Apply(Op{Kind: OpAdd, Node: &Node{ID: "p1", Kind: KindPlayer}})
+
+	red, label := "#f00", "9"
+	concurrent := []Op{{Kind: OpPatch, ID: "p1", Changes: &NodePatch{Color: &red, Label: &label}}}
+	blue := "#00f"
+	ops := []Op{{Kind: OpPatch, ID: "p1", Changes: &NodePatch{Color: &blue}}}
+
+	for _, op := range concurrent {
+		state.Apply(op)
+	}
+	for _, op := range Rebase(ops, concurrent) {
+		state.Apply(op)
+	}
+
+	node, ok := state.Node("p1")
+	require.True(t, ok)
+	require.Equal(t, "#00f", *node.Color)
+	require.Equal(t, "9", *node.Label)
+}
+
+func TestRebaseReAddLiftsTombstone(t *testing.T) {
+	ops := []Op{{Kind: OpRemove, ID: "p1"}}
+	concurrent := []Op{
+		{Kind: OpRemove, ID: "p1"},
+		{Kind: OpAdd, Node: &Node{ID: "p1", Kind: KindCone}},
+	}
+	require.Len(t, Rebase(ops, concurrent), 1)
+}
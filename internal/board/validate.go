@@ -0,0 +1,272 @@
+package board
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Node kinds understood by the editor.
+const (
+	KindPlayer   NodeKind = "player"
+	KindArrow    NodeKind = "arrow"
+	KindZone     NodeKind = "zone"
+	KindCone     NodeKind = "cone"
+	KindFreehand NodeKind = "freehand"
+)
+
+// Limits applied to client supplied payloads.
+const (
+	MaxBatchOps   = 500
+	MaxIDLength   = 128
+	MaxLabelLen   = 256
+	MaxColorLen   = 32
+	MaxPointCount = 4096
+)
+
+// ErrEmptyBatch is returned when a batch carries no operations.
+var ErrEmptyBatch = errors.New("batch contains no ops")
+
+// OpError reports which op in a batch failed validation.
+type OpError struct {
+	Index int
+	Err   error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("op %d: %v", e.Index, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Valid reports whether the kind is one of the known node kinds.
+func (k NodeKind) Valid() bool {
+	switch k {
+	case KindPlayer, KindArrow, KindZone, KindCone, KindFreehand:
+		return true
+	default:
+		return false
+	}
+}
+
+// Valid reports whether the kind is one of the known op kinds.
+func (k OpKind) Valid() bool {
+	switch k {
+	case OpAdd, OpMove, OpPatch, OpRemove:
+		return true
+	default:
+		return false
+	}
+}
+
+// DecodeBatch strictly decodes and validates a batch of raw ops. The first
+// failing entry is reported as an *OpError carrying its index.
+func DecodeBatch(raw []json.RawMessage) ([]Op, error) {
+	if len(raw) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(raw) > MaxBatchOps {
+		return nil, fmt.Errorf("batch exceeds %d ops", MaxBatchOps)
+	}
+
+	ops := make([]Op, 0, len(raw))
+	for i, entry := range raw {
+		op, err := DecodeOp(entry)
+		if err != nil {
+			return nil, &OpError{Index: i, Err: err}
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// EncodeBatch serializes ops into their canonical wire form.
+func EncodeBatch(ops []Op) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, 0, len(ops))
+	for i, op := range ops {
+		raw, err := json.Marshal(op)
+		if err != nil {
+			return nil, &OpError{Index: i, Err: err}
+		}
+		out = append(out, raw)
+	}
+	return out, nil
+}
+
+// DecodeOp decodes a single op and validates it. Fields outside the op
+// schema are rejected rather than dropped.
+func DecodeOp(raw json.RawMessage) (Op, error) {
+	var op Op
+	if err := decodeStrict(raw, &op); err != nil {
+		return Op{}, err
+	}
+
+	if op.Kind == OpAdd && op.Node != nil {
+		var coords struct {
+			Node struct {
+				X *float64 `json:"x"`
+				Y *float64 `json:"y"`
+			} `json:"node"`
+		}
+		if err := json.Unmarshal(raw, &coords); err != nil {
+			return Op{}, errors.New("malformed op")
+		}
+		if coords.Node.X == nil || coords.Node.Y == nil {
+			return Op{}, errors.New("add node requires x and y")
+		}
+	}
+
+	if err := op.Validate(); err != nil {
+		return Op{}, err
+	}
+	return op, nil
+}
+
+// decodeStrict unmarshals raw into v, refusing unknown fields and trailing
+// data.
+func decodeStrict(raw []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		// encoding/json reports unknown fields only through the message.
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("unknown field %s", field)
+		}
+		return errors.New("malformed op")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("malformed op")
+	}
+	return nil
+}
+
+// Validate checks that the op carries the fields required by its kind.
+func (op Op) Validate() error {
+	switch op.Kind {
+	case OpAdd:
+		if op.Node == nil {
+			return errors.New("add requires node")
+		}
+		return op.Node.Validate()
+	case OpMove:
+		if err := validateID(op.ID); err != nil {
+			return err
+		}
+		if op.X == nil || op.Y == nil {
+			return errors.New("move requires x and y")
+		}
+		return validateCoords(*op.X, *op.Y)
+	case OpPatch:
+		if err := validateID(op.ID); err != nil {
+			return err
+		}
+		if op.Changes == nil {
+			return errors.New("patch requires changes")
+		}
+		return op.Changes.Validate()
+	case OpRemove:
+		return validateID(op.ID)
+	case "":
+		return errors.New("missing op kind")
+	default:
+		return fmt.Errorf("unknown op kind %q", op.Kind)
+	}
+}
+
+// Validate checks a full node as carried by an add op.
+func (n Node) Validate() error {
+	if err := validateID(n.ID); err != nil {
+		return fmt.Errorf("node %w", err)
+	}
+	if !n.Kind.Valid() {
+		return fmt.Errorf("unknown node kind %q", n.Kind)
+	}
+	if err := validateCoords(n.X, n.Y); err != nil {
+		return err
+	}
+	if err := validateLayer(n.Layer); err != nil {
+		return err
+	}
+	return validateOptional(n.Rotation, n.Color, n.Label, n.Points)
+}
+
+// Validate checks the fields present in a patch.
+func (p NodePatch) Validate() error {
+	if p.Kind != nil && !p.Kind.Valid() {
+		return fmt.Errorf("unknown node kind %q", *p.Kind)
+	}
+	if p.X != nil && !finite(*p.X) {
+		return errors.New("x must be finite")
+	}
+	if p.Y != nil && !finite(*p.Y) {
+		return errors.New("y must be finite")
+	}
+
+	if err := validateLayer(p.Layer); err != nil {
+		return err
+	}
+
+	var points []float64
+	if p.Points != nil {
+		points = *p.Points
+	}
+	return validateOptional(p.Rotation, p.Color, p.Label, points)
+}
+
+func validateID(id string) error {
+	if id == "" {
+		return errors.New("id required")
+	}
+	if len(id) > MaxIDLength {
+		return fmt.Errorf("id exceeds %d characters", MaxIDLength)
+	}
+	return nil
+}
+
+func validateLayer(layer *string) error {
+	if layer != nil && len(*layer) > MaxIDLength {
+		return fmt.Errorf("layer exceeds %d characters", MaxIDLength)
+	}
+	return nil
+}
+
+func validateCoords(x, y float64) error {
+	if !finite(x) || !finite(y) {
+		return errors.New("coordinates must be finite")
+	}
+	return nil
+}
+
+func validateOptional(rotation *float64, color, label *string, points []float64) error {
+	if rotation != nil && !finite(*rotation) {
+		return errors.New("rotation must be finite")
+	}
+	if color != nil && len(*color) > MaxColorLen {
+		return fmt.Errorf("color exceeds %d characters", MaxColorLen)
+	}
+	if label != nil && len(*label) > MaxLabelLen {
+		return fmt.Errorf("label exceeds %d characters", MaxLabelLen)
+	}
+	if len(points) > MaxPointCount {
+		return fmt.Errorf("points exceed %d values", MaxPointCount)
+	}
+	if len(points)%2 != 0 {
+		return errors.New("points must be x/y pairs")
+	}
+	for _, v := range points {
+		if !finite(v) {
+			return errors.New("points must be finite")
+		}
+	}
+	return nil
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
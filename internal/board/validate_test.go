@@ -0,0 +1,75 @@
+package board
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBatchValid(t *testing.T) {
+	ops, err := DecodeBatch([]json.RawMessage{
+		json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":0,"y":0,"label":"10"}}`),
+		json.RawMessage(`{"k":"move","id":"p1","x":12.5,"y":-3}`),
+		json.RawMessage(`{"k":"patch","id":"p1","changes":{"points":[1,2,3,4]}}`),
+		json.RawMessage(`{"k":"remove","id":"p1"}`),
+	})
+	require.NoError(t, err)
+	require.Len(t, ops, 4)
+	require.Equal(t, OpMove, ops[1].Kind)
+	require.EqualValues(t, 12.5, *ops[1].X)
+}
+
+func TestDecodeBatchReportsFailingIndex(t *testing.T) {
+	cases := map[string]struct {
+		raw   string
+		index int
+		msg   string
+	}{
+		"unknown kind":        {`{"k":"explode","id":"a"}`, 1, `op 1: unknown op kind "explode"`},
+		"move without xy":     {`{"k":"move","id":"a","x":1}`, 1, "op 1: move requires x and y"},
+		"add without y":       {`{"k":"add","node":{"id":"a","kind":"cone","x":1}}`, 1, "op 1: add node requires x and y"},
+		"bad node kind":       {`{"k":"add","node":{"id":"a","kind":"ball","x":1,"y":1}}`, 1, `op 1: unknown node kind "ball"`},
+		"odd points":          {`{"k":"patch","id":"a","changes":{"points":[1,2,3]}}`, 1, "op 1: points must be x/y pairs"},
+		"missing id":          {`{"k":"remove"}`, 1, "op 1: id required"},
+		"long layer":          {`{"k":"patch","id":"a","changes":{"layer":"` + strings.Repeat("l", MaxIDLength+1) + `"}}`, 1, "op 1: layer exceeds 128 characters"},
+		"not json":            {`[]`, 1, "op 1: malformed op"},
+		"unknown field":       {`{"k":"move","id":"a","x":1,"y":1,"z":3}`, 1, `op 1: unknown field "z"`},
+		"unknown node field":  {`{"k":"add","node":{"id":"a","kind":"cone","x":1,"y":1,"size":2}}`, 1, `op 1: unknown field "size"`},
+		"unknown patch field": {`{"k":"patch","id":"a","changes":{"colour":"red"}}`, 1, `op 1: unknown field "colour"`},
+		"trailing data":       {`{"k":"remove","id":"a"} {}`, 1, "op 1: malformed op"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeBatch([]json.RawMessage{
+				json.RawMessage(`{"k":"remove","id":"ok"}`),
+				json.RawMessage(tc.raw),
+			})
+			require.Error(t, err)
+
+			var opErr *OpError
+			require.True(t, errors.As(err, &opErr))
+			require.Equal(t, tc.index, opErr.Index)
+			require.EqualError(t, err, tc.msg)
+		})
+	}
+}
+
+func TestDecodeBatchEmpty(t *testing.T) {
+	_, err := DecodeBatch(nil)
+	require.ErrorIs(t, err, ErrEmptyBatch)
+}
+
+func TestEncodeBatchCanonicalizes(t *testing.T) {
+	ops, err := DecodeBatch([]json.RawMessage{
+		json.RawMessage(`{ "y": 2.0, "x": 1, "id": "a", "k": "move" }`),
+	})
+	require.NoError(t, err)
+
+	encoded, err := EncodeBatch(ops)
+	require.NoError(t, err)
+	require.Equal(t, `{"k":"move","id":"a","x":1,"y":2}`, string(encoded[0]))
+}
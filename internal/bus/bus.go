@@ -0,0 +1,70 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/config"
+)
+
+// Message is a room-scoped payload fanned out to every hub instance.
+type Message struct {
+	Origin string `json:"origin"`
+	RoomID string `json:"room"`
+	// Kind marks hub control messages. It is empty for payloads that are
+	// delivered to the room's clients as they are.
+	Kind    string          `json:"kind,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// KindResync marks a message a Bus dispatches to its own subscribers, never
+// to other instances, after it may have lost messages, e.g. while
+// reconnecting to its transport. Subscribers must rebuild any state they
+// derive from bus traffic.
+const KindResync = "bus.resync"
+
+// Handler receives messages published by any instance, including the caller.
+type Handler func(Message)
+
+// Bus carries room traffic between hub replicas.
+type Bus interface {
+	Publish(ctx context.Context, msg Message) error
+	Subscribe(handler Handler) (unsubscribe func())
+}
+
+// Module registers the configured Bus implementation.
+var Module = fx.Module(
+	"bus",
+	fx.Provide(New),
+)
+
+// New returns the Bus selected by BUS_DRIVER.
+func New(lc fx.Lifecycle, cfg config.Config, log *zap.Logger) (Bus, error) {
+	switch cfg.BusDriver {
+	case config.BusDriverPostgres:
+		pg, err := newPostgresBus(context.Background(), cfg.DBDSN, log)
+		if err != nil {
+			return nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				pg.start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				return pg.stop(ctx)
+			},
+		})
+		log.Info("bus initialized", zap.String("driver", "postgres"))
+		return pg, nil
+	case config.BusDriverMemory, "":
+		log.Info("bus initialized", zap.String("driver", "memory"))
+		return NewMemoryBus(), nil
+	default:
+		return nil, fmt.Errorf("unknown bus driver %q", cfg.BusDriver)
+	}
+}
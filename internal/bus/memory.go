@@ -0,0 +1,59 @@
+package bus
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBus delivers messages synchronously to subscribers in the same process.
+type memoryBus struct {
+	handlers handlerSet
+}
+
+// NewMemoryBus constructs the single-node bus.
+func NewMemoryBus() Bus {
+	return &memoryBus{}
+}
+
+func (b *memoryBus) Publish(_ context.Context, msg Message) error {
+	b.handlers.dispatch(msg)
+	return nil
+}
+
+func (b *memoryBus) Subscribe(handler Handler) func() {
+	return b.handlers.add(handler)
+}
+
+// handlerSet is a concurrency-safe registry of subscribers.
+type handlerSet struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]Handler
+}
+
+func (s *handlerSet) add(handler Handler) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[int]Handler)
+	}
+	id := s.next
+	s.next++
+	s.handlers[id] = handler
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+func (s *handlerSet) dispatch(msg Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, handler := range s.handlers {
+		handler(msg)
+	}
+}
@@ -0,0 +1,27 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBusPublishSubscribe(t *testing.T) {
+	b := NewMemoryBus()
+
+	var received []Message
+	unsubscribe := b.Subscribe(func(msg Message) {
+		received = append(received, msg)
+	})
+
+	msg := Message{Origin: "a", RoomID: "room-1", Payload: json.RawMessage(`{"type":"delta"}`)}
+	require.NoError(t, b.Publish(context.Background(), msg))
+	require.Len(t, received, 1)
+	require.Equal(t, "room-1", received[0].RoomID)
+
+	unsubscribe()
+	require.NoError(t, b.Publish(context.Background(), msg))
+	require.Len(t, received, 1)
+}
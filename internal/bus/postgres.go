@@ -0,0 +1,191 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyChannel = "tacticboard_room_bus"
+	// Postgres caps NOTIFY payloads at 8000 bytes; larger messages are spilled
+	// into bus_payloads and referenced by id.
+	maxNotifyPayload = 7900
+	spillRetention   = 5 * time.Minute
+	maxListenBackoff = 30 * time.Second
+)
+
+// notification is the wire format of a NOTIFY payload.
+type notification struct {
+	Ref     int64    `json:"ref,omitempty"`
+	Message *Message `json:"msg,omitempty"`
+}
+
+// postgresBus fans messages out through Postgres LISTEN/NOTIFY.
+type postgresBus struct {
+	dsn      string
+	pool     *pgxpool.Pool
+	log      *zap.Logger
+	handlers handlerSet
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// listened is set once the first LISTEN succeeds; it is only touched by
+	// the listener goroutine.
+	listened bool
+}
+
+func newPostgresBus(ctx context.Context, dsn string, log *zap.Logger) (*postgresBus, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresBus{
+		dsn:  dsn,
+		pool: pool,
+		log:  log.Named("pg_bus"),
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (b *postgresBus) Publish(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(notification{Message: &msg})
+	if err != nil {
+		return err
+	}
+
+	if len(body) > maxNotifyPayload {
+		body, err = b.spill(ctx, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.pool.Exec(ctx, "select pg_notify($1, $2)", notifyChannel, string(body))
+	return err
+}
+
+func (b *postgresBus) Subscribe(handler Handler) func() {
+	return b.handlers.add(handler)
+}
+
+// spill stores an oversized notification and returns a reference to it.
+func (b *postgresBus) spill(ctx context.Context, body []byte) ([]byte, error) {
+	var id int64
+	if err := b.pool.QueryRow(ctx,
+		"insert into bus_payloads (body) values ($1) returning id", body,
+	).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	if _, err := b.pool.Exec(ctx,
+		"delete from bus_payloads where created_at < $1", time.Now().Add(-spillRetention),
+	); err != nil {
+		b.log.Debug("prune spilled payloads", zap.Error(err))
+	}
+
+	return json.Marshal(notification{Ref: id})
+}
+
+func (b *postgresBus) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.listen(ctx)
+}
+
+func (b *postgresBus) stop(ctx context.Context) error {
+	if b.cancel != nil {
+		b.cancel()
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+		}
+	}
+	b.pool.Close()
+	return nil
+}
+
+// listen keeps a dedicated LISTEN connection open, reconnecting with backoff.
+func (b *postgresBus) listen(ctx context.Context) {
+	defer close(b.done)
+
+	backoff := time.Second
+	for {
+		err := b.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		b.log.Warn("bus listener disconnected", zap.Error(err), zap.Duration("retry_in", backoff))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxListenBackoff {
+			backoff = maxListenBackoff
+		}
+	}
+}
+
+func (b *postgresBus) listenOnce(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "listen "+notifyChannel); err != nil {
+		return err
+	}
+	b.log.Info("bus listener connected")
+
+	// Notifications sent while the previous connection was down are gone.
+	if b.listened {
+		b.handlers.dispatch(Message{Kind: KindResync})
+	}
+	b.listened = true
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		b.receive(ctx, []byte(n.Payload))
+	}
+}
+
+func (b *postgresBus) receive(ctx context.Context, body []byte) {
+	var note notification
+	if err := json.Unmarshal(body, &note); err != nil {
+		b.log.Warn("decode bus notification", zap.Error(err))
+		return
+	}
+
+	if note.Ref != 0 {
+		if err := b.pool.QueryRow(ctx,
+			"select body from bus_payloads where id = $1", note.Ref,
+		).Scan(&body); err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				b.log.Warn("load spilled payload", zap.Int64("ref", note.Ref), zap.Error(err))
+			}
+			return
+		}
+		note = notification{}
+		if err := json.Unmarshal(body, &note); err != nil {
+			b.log.Warn("decode spilled payload", zap.Error(err))
+			return
+		}
+	}
+
+	if note.Message == nil {
+		return
+	}
+	b.handlers.dispatch(*note.Message)
+}
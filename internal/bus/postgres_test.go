@@ -0,0 +1,108 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestPostgresBus starts a bus against TEST_DB_DSN and skips the test when
+// it is unset.
+func newTestPostgresBus(t *testing.T) *postgresBus {
+	t.Helper()
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+
+	ctx := context.Background()
+	b, err := newPostgresBus(ctx, dsn, zap.NewNop())
+	require.NoError(t, err)
+	ddl, err := os.ReadFile("../../migrations/0002_bus_payloads.sql")
+	require.NoError(t, err)
+	_, err = b.pool.Exec(ctx, string(ddl))
+	require.NoError(t, err)
+
+	b.start()
+	t.Cleanup(func() { _ = b.stop(context.Background()) })
+	return b
+}
+
+// recorder collects messages delivered to a subscriber.
+type recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *recorder) handle(msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+func (r *recorder) find(match func(Message) bool) (Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range r.messages {
+		if match(msg) {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// awaitListening publishes probes from b until rec sees one, which proves the
+// listener behind rec is connected.
+func awaitListening(t *testing.T, b *postgresBus, rec *recorder) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		_ = b.Publish(context.Background(), Message{Origin: "probe", RoomID: "probe"})
+		_, ok := rec.find(func(msg Message) bool { return msg.Origin == "probe" })
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPostgresBusSpillsLargeMessages(t *testing.T) {
+	sender := newTestPostgresBus(t)
+	receiver := newTestPostgresBus(t)
+
+	var rec recorder
+	receiver.Subscribe(rec.handle)
+	awaitListening(t, sender, &rec)
+
+	payload, err := json.Marshal(map[string]string{"blob": strings.Repeat("x", 2*maxNotifyPayload)})
+	require.NoError(t, err)
+	require.NoError(t, sender.Publish(context.Background(), Message{Origin: "a", RoomID: "room-1", Payload: payload}))
+
+	require.Eventually(t, func() bool {
+		msg, ok := rec.find(func(msg Message) bool { return msg.RoomID == "room-1" })
+		return ok && msg.Origin == "a" && string(msg.Payload) == string(payload)
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestPostgresBusDispatchesResyncAfterReconnect(t *testing.T) {
+	b := newTestPostgresBus(t)
+
+	var rec recorder
+	b.Subscribe(rec.handle)
+	awaitListening(t, b, &rec)
+	_, ok := rec.find(func(msg Message) bool { return msg.Kind == KindResync })
+	require.False(t, ok, "the first connection must not report lost messages")
+
+	_, err := b.pool.Exec(context.Background(),
+		"select pg_terminate_backend(pid) from pg_stat_activity where query = $1", "listen "+notifyChannel,
+	)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, ok := rec.find(func(msg Message) bool { return msg.Kind == KindResync })
+		return ok
+	}, 10*time.Second, 50*time.Millisecond)
+}
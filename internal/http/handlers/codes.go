@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const (
+	defaultJoinCodeTTL = time.Hour
+	maxJoinCodeTTL     = 24 * time.Hour
+	maxJoinCodeUses    = 1000
+	// joinCodeAttempts bounds retries when a generated code is already taken.
+	joinCodeAttempts = 5
+)
+
+type joinCodeRequest struct {
+	Role        util.CapabilityRole `json:"role" binding:"required"`
+	TTLMinutes  int                 `json:"ttlMinutes"`
+	MaxUses     int                 `json:"maxUses"`
+	Permissions *sharePermissions   `json:"permissions"`
+}
+
+// CreateJoinCode creates a short code that can be exchanged for a capability
+// through Join. The delegation rules of ShareRoom apply: view access can be
+// handed out by any member, edit access only by the owner, and tokens minted
+// from the code never outlive the caller's own capability.
+func (h *RoomHandler) CreateJoinCode(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	caller, ok := h.requireRole(c, roomID, util.RoleView)
+	if !ok {
+		return
+	}
+
+	var req joinCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	if req.MaxUses < 0 || req.MaxUses > maxJoinCodeUses {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("maxUses must be between 1 and %d", maxJoinCodeUses)})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+
+	restrictions, ok := delegate(c, caller, req.Role, req.Permissions)
+	if !ok {
+		return
+	}
+
+	encoded, err := encodeRestrictions(restrictions)
+	if err != nil {
+		h.log.Error("encode join code restrictions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create code"})
+		return
+	}
+
+	now := time.Now().UTC()
+	ttl := defaultJoinCodeTTL
+	if req.TTLMinutes > 0 {
+		ttl = min(time.Duration(req.TTLMinutes)*time.Minute, maxJoinCodeTTL)
+	}
+	expiresAt := now.Add(ttl)
+	if expiresAt.After(caller.ExpiresAt) {
+		expiresAt = caller.ExpiresAt
+	}
+
+	joinCode := model.JoinCode{
+		RoomID:        roomID,
+		Role:          string(req.Role),
+		Restrictions:  encoded,
+		CreatedBy:     caller.TokenID,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+		TokenNotAfter: caller.ExpiresAt,
+		MaxUses:       req.MaxUses,
+	}
+	for attempt := 0; ; attempt++ {
+		joinCode.Code, err = util.NewJoinCode()
+		if err == nil {
+			err = h.store.CreateJoinCode(ctx, joinCode)
+		}
+		if !errors.Is(err, model.ErrJoinCodeExists) || attempt == joinCodeAttempts-1 {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("create join code", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"code":        util.FormatJoinCode(joinCode.Code),
+		"role":        req.Role,
+		"expiry":      joinCode.ExpiresAt,
+		"maxUses":     joinCode.MaxUses,
+		"permissions": restrictions,
+	})
+}
+
+type joinRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Join exchanges a join code for a capability token. Every failed attempt
+// costs the client address a token from the join failure bucket, and an
+// empty bucket answers 429 before the code is looked at, so codes cannot be
+// guessed faster than JOIN_FAILURES_PER_MIN.
+func (h *RoomHandler) Join(c *gin.Context) {
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+
+	if h.joinFailures.Exhausted(ip) {
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed join attempts"})
+		return
+	}
+
+	var req joinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.joinFailures.Charge(ip)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	code, valid := util.NormalizeJoinCode(req.Code)
+	if !valid {
+		h.joinFailures.Charge(ip)
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired code"})
+		return
+	}
+
+	now := time.Now().UTC()
+	joinCode, err := h.store.RedeemJoinCode(ctx, code, now)
+	if err != nil {
+		if errors.Is(err, model.ErrJoinCodeNotFound) {
+			h.joinFailures.Charge(ip)
+			c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired code"})
+			return
+		}
+		h.log.Error("redeem join code", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeem code"})
+		return
+	}
+
+	// A code dies with the capability that created it.
+	revoked, err := h.store.IsTokenRevoked(ctx, joinCode.RoomID, joinCode.CreatedBy)
+	if err != nil && !errors.Is(err, model.ErrRoomNotFound) {
+		h.log.Error("check join code creator", zap.String("room", joinCode.RoomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeem code"})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired code"})
+		return
+	}
+
+	token, claims, err := h.issueStored(ctx, joinCode.RoomID, joinCode.Role, joinCode.Restrictions, joinCode.TokenNotAfter, joinCode.CreatedBy, "")
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("generate join token", zap.String("room", joinCode.RoomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roomId":      joinCode.RoomID,
+		"token":       token,
+		"tokenId":     claims.TokenID,
+		"role":        claims.Role,
+		"expiry":      claims.ExpiresAt,
+		"link":        shareURL(joinCode.RoomID, token),
+		"permissions": claims.Restrictions,
+	})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const maxInviteRedemptions = 1000
+
+type inviteRequest struct {
+	Role util.CapabilityRole `json:"role" binding:"required"`
+	// TTLMinutes is optional; without it the invite lasts as long as the
+	// capability that created it.
+	TTLMinutes     int               `json:"ttlMinutes"`
+	MaxRedemptions int               `json:"maxRedemptions"`
+	Permissions    *sharePermissions `json:"permissions"`
+}
+
+// CreateInvite creates an invitation link that can be redeemed up to
+// maxRedemptions times, each redemption minting a personal token. The
+// delegation rules of ShareRoom apply.
+func (h *RoomHandler) CreateInvite(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	caller, ok := h.requireRole(c, roomID, util.RoleView)
+	if !ok {
+		return
+	}
+
+	var req inviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	if req.MaxRedemptions < 0 || req.MaxRedemptions > maxInviteRedemptions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("maxRedemptions must be between 1 and %d", maxInviteRedemptions)})
+		return
+	}
+	if req.MaxRedemptions == 0 {
+		req.MaxRedemptions = 1
+	}
+
+	restrictions, ok := delegate(c, caller, req.Role, req.Permissions)
+	if !ok {
+		return
+	}
+
+	encoded, err := encodeRestrictions(restrictions)
+	if err != nil {
+		h.log.Error("encode invite restrictions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+
+	inviteID, err := util.NewTokenID()
+	if err != nil {
+		h.log.Error("generate invite id", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := caller.ExpiresAt
+	if req.TTLMinutes > 0 {
+		if limit := now.Add(time.Duration(req.TTLMinutes) * time.Minute); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+
+	invite := model.Invite{
+		ID:             inviteID,
+		RoomID:         roomID,
+		Role:           string(req.Role),
+		Restrictions:   encoded,
+		CreatedBy:      caller.TokenID,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		TokenNotAfter:  caller.ExpiresAt,
+		MaxRedemptions: req.MaxRedemptions,
+	}
+	if err := h.store.CreateInvite(ctx, invite); err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("create invite", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invite": invite,
+		"link":   inviteURL(roomID, inviteID),
+	})
+}
+
+// ListInvites returns the room's invites with their redemption counts. The
+// caller must present an owner capability for the room.
+func (h *RoomHandler) ListInvites(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	invites, err := h.store.ListInvites(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("list invites", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// RevokeInvite stops further redemptions of an invite. Tokens already minted
+// from it stay valid until revoked on their own. The caller must present an
+// owner capability for the room.
+func (h *RoomHandler) RevokeInvite(c *gin.Context) {
+	roomID := c.Param("id")
+	inviteID := c.Param("inviteId")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	if err := h.store.RevokeInvite(ctx, roomID, inviteID, time.Now().UTC()); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRoomNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		case errors.Is(err, model.ErrInviteNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		default:
+			h.log.Error("revoke invite", zap.String("room", roomID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke invite"})
+		}
+		return
+	}
+
+	h.log.Info("invite revoked", zap.String("room", roomID), zap.String("invite_id", inviteID))
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+type redeemInviteRequest struct {
+	// Name becomes the display name of the minted token.
+	Name string `json:"name"`
+}
+
+// RedeemInvite mints a personal capability token from an invite. The invite
+// id is the credential, so no bearer token is required.
+func (h *RoomHandler) RedeemInvite(c *gin.Context) {
+	roomID := c.Param("id")
+	inviteID := c.Param("inviteId")
+	ctx := c.Request.Context()
+
+	var req redeemInviteRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+
+	invite, err := h.store.RedeemInvite(ctx, roomID, inviteID, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, model.ErrInviteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired invite"})
+			return
+		}
+		h.log.Error("redeem invite", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeem invite"})
+		return
+	}
+
+	// An invite dies with the capability that created it.
+	revoked, err := h.store.IsTokenRevoked(ctx, roomID, invite.CreatedBy)
+	if err != nil && !errors.Is(err, model.ErrRoomNotFound) {
+		h.log.Error("check invite creator", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeem invite"})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid or expired invite"})
+		return
+	}
+
+	token, claims, err := h.issueStored(ctx, roomID, invite.Role, invite.Restrictions, invite.TokenNotAfter, invite.CreatedBy, truncateRunes(req.Name, maxDisplayName))
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("generate invite token", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roomId":      roomID,
+		"token":       token,
+		"tokenId":     claims.TokenID,
+		"role":        claims.Role,
+		"expiry":      claims.ExpiresAt,
+		"link":        shareURL(roomID, token),
+		"permissions": claims.Restrictions,
+	})
+}
+
+func inviteURL(roomID, inviteID string) string {
+	return fmt.Sprintf("/room/%s?invite=%s", url.PathEscape(roomID), url.QueryEscape(inviteID))
+}
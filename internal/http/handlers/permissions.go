@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const maxPermissionEntries = 64
+
+// validatePermissions rejects unknown op kinds, node kinds and bad layer names.
+func validatePermissions(p *sharePermissions) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.Ops) > maxPermissionEntries || len(p.NodeKinds) > maxPermissionEntries || len(p.Layers) > maxPermissionEntries {
+		return fmt.Errorf("at most %d entries per permission list", maxPermissionEntries)
+	}
+	for _, op := range p.Ops {
+		if !board.OpKind(op).Valid() {
+			return fmt.Errorf("unknown op kind %q", op)
+		}
+	}
+	for _, kind := range p.NodeKinds {
+		if !board.NodeKind(kind).Valid() {
+			return fmt.Errorf("unknown node kind %q", kind)
+		}
+	}
+	for _, layer := range p.Layers {
+		if layer == "" || len(layer) > board.MaxIDLength {
+			return errors.New("invalid layer name")
+		}
+	}
+	return nil
+}
+
+// narrowRestrictions derives the restrictions of a delegated token. A token
+// can only be as scoped as the caller's or narrower: each dimension the
+// caller is restricted on is inherited when the request leaves it out and
+// must be a subset otherwise.
+func narrowRestrictions(caller *util.Restrictions, requested *sharePermissions) (*util.Restrictions, error) {
+	if requested == nil {
+		requested = &sharePermissions{}
+	}
+	if caller == nil {
+		caller = &util.Restrictions{}
+	}
+
+	ops, err := narrow("op kinds", caller.OpKinds, requested.Ops)
+	if err != nil {
+		return nil, err
+	}
+	nodeKinds, err := narrow("node kinds", caller.NodeKinds, requested.NodeKinds)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := narrow("layers", caller.Layers, requested.Layers)
+	if err != nil {
+		return nil, err
+	}
+
+	restrictions := &util.Restrictions{
+		OpKinds:   ops,
+		NodeKinds: nodeKinds,
+		Layers:    layers,
+		NodeIDs:   caller.NodeIDs,
+	}
+	if restrictions.Empty() {
+		return nil, nil
+	}
+	return restrictions, nil
+}
+
+// encodeRestrictions serializes restrictions for a stored join code or
+// invite; unrestricted grants are stored as nil.
+func encodeRestrictions(r *util.Restrictions) (json.RawMessage, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// decodeRestrictions reverses encodeRestrictions.
+func decodeRestrictions(raw json.RawMessage) (*util.Restrictions, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var r util.Restrictions
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("decode restrictions: %w", err)
+	}
+	return &r, nil
+}
+
+func narrow(name string, held, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return held, nil
+	}
+	if len(held) == 0 {
+		return requested, nil
+	}
+	for _, entry := range requested {
+		if !slices.Contains(held, entry) {
+			return nil, fmt.Errorf("cannot share %s beyond your own: %s", name, entry)
+		}
+	}
+	return requested, nil
+}
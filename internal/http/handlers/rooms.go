@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,30 +12,45 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/traweezy/tacticboard/internal/auth"
+	"github.com/traweezy/tacticboard/internal/board"
 	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/http/middleware"
 	"github.com/traweezy/tacticboard/internal/model"
 	"github.com/traweezy/tacticboard/internal/store"
 	"github.com/traweezy/tacticboard/internal/util"
+	"github.com/traweezy/tacticboard/internal/ws"
 )
 
 const (
 	defaultShareTTL = 24 * time.Hour
 	maxShareTTL     = 7 * 24 * time.Hour
+	// maxDisplayName matches the display name limit of capability tokens.
+	maxDisplayName = 64
 )
 
 type RoomHandler struct {
-	cfg   config.Config
-	store store.Store
-	ids   *util.IDGenerator
-	log   *zap.Logger
+	cfg      config.Config
+	store    store.Store
+	ids      *util.IDGenerator
+	keys     *auth.Keyring
+	verifier *auth.Verifier
+	hub      *ws.Hub
+	// joinFailures is charged for every failed join code attempt.
+	joinFailures *middleware.IPRateLimiter
+	log          *zap.Logger
 }
 
-func NewRoomHandler(cfg config.Config, store store.Store, ids *util.IDGenerator, log *zap.Logger) *RoomHandler {
+func NewRoomHandler(cfg config.Config, store store.Store, ids *util.IDGenerator, keys *auth.Keyring, verifier *auth.Verifier, hub *ws.Hub, log *zap.Logger) *RoomHandler {
 	return &RoomHandler{
-		cfg:   cfg,
-		store: store,
-		ids:   ids,
-		log:   log.Named("rooms_handler"),
+		cfg:          cfg,
+		store:        store,
+		ids:          ids,
+		keys:         keys,
+		verifier:     verifier,
+		hub:          hub,
+		joinFailures: middleware.NewIPRateLimiter(cfg.JoinFailuresPerMin/60, cfg.JoinFailureBurst),
+		log:          log.Named("rooms_handler"),
 	}
 }
 
@@ -63,32 +79,62 @@ func (h *RoomHandler) CreateRoom(c *gin.Context) {
 		return
 	}
 
-	viewToken, viewExpiry, err := h.newCapability(roomID, util.RoleView, now, defaultShareTTL)
+	viewToken, viewClaims, err := h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      util.RoleView,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(defaultShareTTL),
+	}, "")
 	if err != nil {
 		h.log.Error("create view token", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tokens"})
 		return
 	}
 
-	editToken, editExpiry, err := h.newCapability(roomID, util.RoleEdit, now, defaultShareTTL)
+	editToken, editClaims, err := h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      util.RoleEdit,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(defaultShareTTL),
+	}, "")
 	if err != nil {
 		h.log.Error("create edit token", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tokens"})
 		return
 	}
 
+	ownerToken, ownerClaims, err := h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      util.RoleOwner,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(maxShareTTL),
+	}, "")
+	if err != nil {
+		h.log.Error("create owner token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tokens"})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
-		"id":        roomID,
-		"createdAt": now,
-		"viewToken": viewToken,
-		"editToken": editToken,
+		"id":         roomID,
+		"createdAt":  now,
+		"viewToken":  viewToken,
+		"editToken":  editToken,
+		"ownerToken": ownerToken,
 		"links": gin.H{
-			"view": shareURL(roomID, viewToken),
-			"edit": shareURL(roomID, editToken),
+			"view":  shareURL(roomID, viewToken),
+			"edit":  shareURL(roomID, editToken),
+			"owner": shareURL(roomID, ownerToken),
 		},
 		"expires": gin.H{
-			"view": viewExpiry,
-			"edit": editExpiry,
+			"view":  viewClaims.ExpiresAt,
+			"edit":  editClaims.ExpiresAt,
+			"owner": ownerClaims.ExpiresAt,
+		},
+		"tokenIds": gin.H{
+			"view":  viewClaims.TokenID,
+			"edit":  editClaims.TokenID,
+			"owner": ownerClaims.TokenID,
 		},
 	})
 }
@@ -113,6 +159,7 @@ func (h *RoomHandler) GetRoom(c *gin.Context) {
 		"createdAt":  room.CreatedAt,
 		"updatedAt":  room.UpdatedAt,
 		"currentSeq": room.CurrentSeq,
+		"settings":   room.Settings,
 	}
 
 	if room.Snapshot != nil {
@@ -128,12 +175,30 @@ func (h *RoomHandler) GetRoom(c *gin.Context) {
 type shareRequest struct {
 	Role       util.CapabilityRole `json:"role" binding:"required"`
 	TTLMinutes int                 `json:"ttlMinutes"`
+	// Permissions optionally scopes the token to op kinds, node kinds and
+	// layers.
+	Permissions *sharePermissions `json:"permissions"`
+}
+
+type sharePermissions struct {
+	Ops       []string `json:"ops"`
+	NodeKinds []string `json:"nodeKinds"`
+	Layers    []string `json:"layers"`
 }
 
+// ShareRoom mints a new capability on behalf of the bearer. Any member may
+// share view access; edit access can only be delegated by the owner. The new
+// token never outlives the caller's own capability, and every mint is recorded
+// in the room's share ledger.
 func (h *RoomHandler) ShareRoom(c *gin.Context) {
 	roomID := c.Param("id")
 	ctx := c.Request.Context()
 
+	caller, ok := h.requireRole(c, roomID, util.RoleView)
+	if !ok {
+		return
+	}
+
 	if _, err := h.store.GetRoom(ctx, roomID); err != nil {
 		if errors.Is(err, model.ErrRoomNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
@@ -150,14 +215,24 @@ func (h *RoomHandler) ShareRoom(c *gin.Context) {
 		return
 	}
 
-	if req.Role != util.RoleView && req.Role != util.RoleEdit {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+	restrictions, ok := delegate(c, caller, req.Role, req.Permissions)
+	if !ok {
 		return
 	}
 
-	ttl := durationFromMinutes(req.TTLMinutes)
 	now := time.Now().UTC()
-	token, expiry, err := h.newCapability(roomID, req.Role, now, ttl)
+	expiresAt := now.Add(durationFromMinutes(req.TTLMinutes))
+	if expiresAt.After(caller.ExpiresAt) {
+		expiresAt = caller.ExpiresAt
+	}
+
+	token, claims, err := h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:       roomID,
+		Role:         req.Role,
+		IssuedAt:     now,
+		ExpiresAt:    expiresAt,
+		Restrictions: restrictions,
+	}, caller.TokenID)
 	if err != nil {
 		h.log.Error("generate share token", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
@@ -165,29 +240,439 @@ func (h *RoomHandler) ShareRoom(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":  token,
-		"role":   req.Role,
-		"expiry": expiry,
-		"link":   shareURL(roomID, token),
+		"token":       token,
+		"tokenId":     claims.TokenID,
+		"role":        req.Role,
+		"expiry":      claims.ExpiresAt,
+		"link":        shareURL(roomID, token),
+		"permissions": claims.Restrictions,
 	})
 }
 
-func (h *RoomHandler) newCapability(roomID string, role util.CapabilityRole, issuedAt time.Time, ttl time.Duration) (string, time.Time, error) {
-	if ttl <= 0 {
-		ttl = defaultShareTTL
+// RefreshOwnerToken rotates an unexpired owner token: it mints a fresh owner
+// capability and revokes the caller's token. Owner tokens are capped at
+// maxShareTTL like every other capability, so this is how a room keeps its
+// owner without accumulating live owner tokens. Tokens the caller shared stay
+// valid; the new token is recorded as minted by the old one, so revoking the
+// old token later revokes its successors too.
+func (h *RoomHandler) RefreshOwnerToken(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	caller, ok := h.requireRole(c, roomID, util.RoleOwner)
+	if !ok {
+		return
 	}
-	if ttl > maxShareTTL {
-		ttl = maxShareTTL
+
+	if _, err := h.store.GetRoom(ctx, roomID); err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("lookup room before owner refresh", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return
+	}
+
+	now := time.Now().UTC()
+	token, claims, err := h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      util.RoleOwner,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(maxShareTTL),
+	}, caller.TokenID)
+	if err != nil {
+		h.log.Error("refresh owner token", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
 	}
 
-	claims := util.CapabilityClaims{
+	err = h.store.RevokeToken(ctx, model.RevokedToken{
 		RoomID:    roomID,
-		Role:      role,
-		IssuedAt:  issuedAt,
-		ExpiresAt: issuedAt.Add(ttl),
+		TokenID:   caller.TokenID,
+		RevokedAt: now,
+	})
+	if err != nil {
+		h.log.Error("revoke rotated owner token", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+	h.hub.RevokeToken(ctx, roomID, caller.TokenID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":   token,
+		"tokenId": claims.TokenID,
+		"role":    claims.Role,
+		"expiry":  claims.ExpiresAt,
+		"link":    shareURL(roomID, token),
+	})
+}
+
+// ListShares returns the room's share ledger. The caller must present an
+// owner capability for the room.
+func (h *RoomHandler) ListShares(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	shares, err := h.store.ListShares(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("list shares", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+// RevokeToken adds a token, and every token minted from it according to the
+// share ledger, to the room's revocation list and disconnects the sessions
+// using them. The caller must present an owner capability for the room.
+func (h *RoomHandler) RevokeToken(c *gin.Context) {
+	roomID := c.Param("id")
+	tokenID := c.Param("tokenId")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	revoked, err := h.revokeWithDescendants(ctx, roomID, tokenID, time.Now().UTC())
+	for _, id := range revoked {
+		h.hub.RevokeToken(ctx, roomID, id)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("revoke token", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+
+	h.log.Info("token revoked", zap.String("room", roomID), zap.String("token_id", tokenID), zap.Int("descendants", len(revoked)-1))
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// revokeWithDescendants revokes tokenID and every token minted from it,
+// directly or through other tokens. It returns the ids revoked so far, also
+// when it fails part way.
+func (h *RoomHandler) revokeWithDescendants(ctx context.Context, roomID, tokenID string, at time.Time) ([]string, error) {
+	shares, err := h.store.ListShares(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string][]string)
+	for _, grant := range shares {
+		if grant.IssuedBy != "" {
+			children[grant.IssuedBy] = append(children[grant.IssuedBy], grant.TokenID)
+		}
+	}
+
+	revoked := make([]string, 0, 1)
+	seen := map[string]bool{tokenID: true}
+	for queue := []string{tokenID}; len(queue) > 0; queue = queue[1:] {
+		id := queue[0]
+		if err := h.store.RevokeToken(ctx, model.RevokedToken{RoomID: roomID, TokenID: id, RevokedAt: at}); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, id)
+		for _, child := range children[id] {
+			if !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return revoked, nil
+}
+
+// DeleteRoom removes the room with its history and closes its live sessions.
+// The caller must present an owner capability for the room.
+func (h *RoomHandler) DeleteRoom(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	if err := h.store.DeleteRoom(ctx, roomID); err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("delete room", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete room"})
+		return
+	}
+
+	h.hub.CloseRoom(ctx, roomID)
+	h.log.Info("room deleted", zap.String("room", roomID))
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+type settingsRequest struct {
+	ReadOnly *bool `json:"readOnly"`
+}
+
+// UpdateSettings changes the room settings and pushes them to live sessions.
+// Fields left out of the payload keep their current value. The caller must
+// present an owner capability for the room.
+func (h *RoomHandler) UpdateSettings(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, ok := h.requireRole(c, roomID, util.RoleOwner); !ok {
+		return
+	}
+
+	var req settingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	room, err := h.store.GetRoom(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("lookup room before settings", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return
+	}
+
+	settings := room.Settings
+	if req.ReadOnly != nil {
+		settings.ReadOnly = *req.ReadOnly
+	}
+
+	if err := h.store.UpdateRoomSettings(ctx, roomID, settings); err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("update room settings", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update settings"})
+		return
+	}
+
+	h.hub.ApplySettings(ctx, roomID, settings)
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+type playerTokensRequest struct {
+	TTLMinutes int `json:"ttlMinutes"`
+}
+
+// PlayerTokens mints one node-bound token per player node on the board. Each
+// token can only move and patch its own node. The caller must present an
+// owner capability for the room.
+func (h *RoomHandler) PlayerTokens(c *gin.Context) {
+	roomID := c.Param("id")
+	ctx := c.Request.Context()
+
+	caller, ok := h.requireRole(c, roomID, util.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var req playerTokensRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+
+	_, err := h.store.GetRoom(ctx, roomID)
+	var snapshot model.Snapshot
+	if err == nil {
+		snapshot, err = h.hub.Materialize(ctx, roomID)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("load board for player tokens", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return
+	}
+
+	state, err := board.DecodeState(snapshot.State)
+	if err != nil {
+		h.log.Error("decode board for player tokens", zap.String("room", roomID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(durationFromMinutes(req.TTLMinutes))
+	if expiresAt.After(caller.ExpiresAt) {
+		expiresAt = caller.ExpiresAt
+	}
+
+	tokens := make([]gin.H, 0)
+	for _, node := range state.Nodes() {
+		if node.Kind != board.KindPlayer {
+			continue
+		}
+
+		label := ""
+		if node.Label != nil {
+			label = *node.Label
+		}
+		token, claims, err := h.issueCapability(ctx, util.CapabilityClaims{
+			RoomID:       roomID,
+			Role:         util.RoleEdit,
+			IssuedAt:     now,
+			ExpiresAt:    expiresAt,
+			DisplayName:  truncateRunes(label, maxDisplayName),
+			Restrictions: &util.Restrictions{NodeIDs: []string{node.ID}},
+		}, caller.TokenID)
+		if err != nil {
+			h.log.Error("generate player token", zap.String("room", roomID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tokens"})
+			return
+		}
+
+		tokens = append(tokens, gin.H{
+			"nodeId":  node.ID,
+			"label":   label,
+			"token":   token,
+			"tokenId": claims.TokenID,
+			"expiry":  claims.ExpiresAt,
+			"link":    shareURL(roomID, token),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// requireRole verifies the request's bearer capability for roomID and checks
+// that it grants at least role. It writes the error response when it fails.
+func (h *RoomHandler) requireRole(c *gin.Context, roomID string, role util.CapabilityRole) (util.CapabilityClaims, bool) {
+	claims, ok := verifyCapability(c, h.verifier, h.log, roomID, bearerToken(c.Request))
+	if !ok {
+		return util.CapabilityClaims{}, false
+	}
+	if !claims.Role.AtLeast(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s capability required", role)})
+		return util.CapabilityClaims{}, false
+	}
+	return claims, true
+}
+
+// issueCapability signs claims, capped at maxShareTTL, and records the token
+// in the share ledger under issuedBy.
+func (h *RoomHandler) issueCapability(ctx context.Context, claims util.CapabilityClaims, issuedBy string) (string, util.CapabilityClaims, error) {
+	if limit := claims.IssuedAt.Add(maxShareTTL); claims.ExpiresAt.After(limit) {
+		claims.ExpiresAt = limit
+	}
+
+	tokenID, err := util.NewTokenID()
+	if err != nil {
+		return "", util.CapabilityClaims{}, err
+	}
+	claims.TokenID = tokenID
+
+	token, err := h.keys.Sign(claims)
+	if err != nil {
+		return "", util.CapabilityClaims{}, err
+	}
+
+	err = h.store.RecordShare(ctx, model.ShareGrant{
+		RoomID:    claims.RoomID,
+		TokenID:   tokenID,
+		Role:      string(claims.Role),
+		IssuedBy:  issuedBy,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	})
+	if err != nil {
+		return "", util.CapabilityClaims{}, fmt.Errorf("record share: %w", err)
+	}
+	return token, claims, nil
+}
+
+// delegate checks that caller may hand out role with the requested
+// permissions and returns the restrictions of the delegated capability. It
+// writes the error response when it fails.
+func delegate(c *gin.Context, caller util.CapabilityClaims, role util.CapabilityRole, requested *sharePermissions) (*util.Restrictions, bool) {
+	if role != util.RoleView && role != util.RoleEdit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return nil, false
+	}
+
+	if !canDelegate(caller.Role, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s capability cannot share %s access", caller.Role, role)})
+		return nil, false
+	}
+
+	if err := validatePermissions(requested); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	restrictions, err := narrowRestrictions(caller.Restrictions, requested)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return restrictions, true
+}
+
+// issueStored mints the capability held by a stored join code or invite. The
+// token lasts defaultShareTTL but never past notAfter.
+func (h *RoomHandler) issueStored(ctx context.Context, roomID, role string, restrictions json.RawMessage, notAfter time.Time, issuedBy, displayName string) (string, util.CapabilityClaims, error) {
+	decoded, err := decodeRestrictions(restrictions)
+	if err != nil {
+		return "", util.CapabilityClaims{}, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(defaultShareTTL)
+	if expiresAt.After(notAfter) {
+		expiresAt = notAfter
+	}
+
+	return h.issueCapability(ctx, util.CapabilityClaims{
+		RoomID:       roomID,
+		Role:         util.CapabilityRole(role),
+		IssuedAt:     now,
+		ExpiresAt:    expiresAt,
+		DisplayName:  displayName,
+		Restrictions: decoded,
+	}, issuedBy)
+}
+
+// canDelegate reports whether a holder of caller may mint role. Edit access
+// is only delegated by roles above it.
+func canDelegate(caller, role util.CapabilityRole) bool {
+	if role == util.RoleView {
+		return caller.Valid()
+	}
+	return caller.AtLeast(role) && caller != role
+}
+
+// truncateRunes shortens s to at most n runes.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
 	}
-	token, err := util.GenerateCapabilityToken([]byte(h.cfg.JWTSecret), claims)
-	return token, claims.ExpiresAt, err
+	return string(runes[:n])
 }
 
 func shareURL(roomID, token string) string {
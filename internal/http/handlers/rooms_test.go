@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
+	"github.com/traweezy/tacticboard/internal/auth"
 	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
 	"github.com/traweezy/tacticboard/internal/store"
 	"github.com/traweezy/tacticboard/internal/util"
 	"go.uber.org/zap"
@@ -12,6 +15,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testDeps struct {
@@ -21,11 +25,12 @@ type testDeps struct {
 
 func newTestDeps(t *testing.T) testDeps {
 	t.Helper()
-	cfg := config.Config{JWTSecret: strings.Repeat("s", 16)}
+	cfg := config.Config{JWTSecret: strings.Repeat("s", 16), JoinFailuresPerMin: 1, JoinFailureBurst: 3}
 	ids, err := util.NewIDGenerator()
 	require.NoError(t, err)
 	st := store.NewMemoryStore()
-	handler := NewRoomHandler(cfg, st, ids, zap.NewNop())
+	keys := auth.NewStaticKeyring(cfg.JWTSecret)
+	handler := NewRoomHandler(cfg, st, ids, keys, auth.NewVerifier(keys, nil, st), newTestHub(cfg, st), zap.NewNop())
 	return testDeps{handler: handler, store: st}
 }
 
@@ -61,6 +66,7 @@ func TestRoomHandler_ShareRoom_InvalidRole(t *testing.T) {
 	c, _ = gin.CreateTestContext(w)
 	req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+roomID+"/share", body)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+created["ownerToken"].(string))
 	c.Params = gin.Params{gin.Param{Key: "id", Value: roomID}}
 	c.Request = req
 	deps.handler.ShareRoom(c)
@@ -77,3 +83,484 @@ func TestRoomHandler_GetRoom_NotFound(t *testing.T) {
 	deps.handler.GetRoom(c)
 	require.Equal(t, http.StatusNotFound, w.Code)
 }
+
+func createTestRoom(t *testing.T, deps testDeps) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/rooms", nil)
+	deps.handler.CreateRoom(c)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+func revokeToken(deps testDeps, roomID, tokenID, bearer string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+roomID+"/tokens/"+tokenID, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	c.Params = gin.Params{{Key: "id", Value: roomID}, {Key: "tokenId", Value: tokenID}}
+	c.Request = req
+	deps.handler.RevokeToken(c)
+	return w
+}
+
+func TestRoomHandler_RevokeToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	viewToken := created["viewToken"].(string)
+	editToken := created["editToken"].(string)
+	ownerToken := created["ownerToken"].(string)
+	viewID := created["tokenIds"].(map[string]any)["view"].(string)
+	require.NotEmpty(t, viewID)
+
+	require.Equal(t, http.StatusUnauthorized, revokeToken(deps, roomID, viewID, "").Code)
+	require.Equal(t, http.StatusForbidden, revokeToken(deps, roomID, viewID, viewToken).Code)
+	require.Equal(t, http.StatusForbidden, revokeToken(deps, roomID, viewID, editToken).Code)
+	require.Equal(t, http.StatusNoContent, revokeToken(deps, roomID, viewID, ownerToken).Code)
+
+	_, err := deps.handler.verifier.Verify(context.Background(), viewToken)
+	require.ErrorIs(t, err, auth.ErrRevoked)
+	_, err = deps.handler.verifier.Verify(context.Background(), editToken)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusUnauthorized, revokeToken(deps, roomID, "other", viewToken).Code, "revoked tokens cannot act")
+
+	// Revocation cascades to every token minted from the revoked one.
+	w := ownerRequest(deps, deps.handler.ShareRoom, http.MethodPost, roomID, `{"role":"view"}`, editToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var child struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &child))
+	w = ownerRequest(deps, deps.handler.ShareRoom, http.MethodPost, roomID, `{"role":"view"}`, child.Token)
+	require.Equal(t, http.StatusOK, w.Code)
+	var grandchild struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grandchild))
+
+	editID := created["tokenIds"].(map[string]any)["edit"].(string)
+	require.Equal(t, http.StatusNoContent, revokeToken(deps, roomID, editID, ownerToken).Code)
+	for _, token := range []string{editToken, child.Token, grandchild.Token} {
+		_, err = deps.handler.verifier.Verify(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrRevoked)
+	}
+	_, err = deps.handler.verifier.Verify(context.Background(), ownerToken)
+	require.NoError(t, err)
+}
+
+func ownerRequest(deps testDeps, handler gin.HandlerFunc, method, roomID, body, bearer string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req := httptest.NewRequest(method, "/api/rooms/"+roomID, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	c.Params = gin.Params{{Key: "id", Value: roomID}}
+	c.Request = req
+	handler(c)
+	return w
+}
+
+func TestRoomHandler_ShareRoom_Delegation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	viewToken := created["viewToken"].(string)
+	editToken := created["editToken"].(string)
+	ownerToken := created["ownerToken"].(string)
+
+	share := func(body, bearer string) *httptest.ResponseRecorder {
+		return ownerRequest(deps, deps.handler.ShareRoom, http.MethodPost, roomID, body, bearer)
+	}
+	require.Equal(t, http.StatusUnauthorized, share(`{"role":"view"}`, "").Code)
+	require.Equal(t, http.StatusOK, share(`{"role":"view"}`, viewToken).Code)
+	require.Equal(t, http.StatusForbidden, share(`{"role":"edit"}`, viewToken).Code)
+	require.Equal(t, http.StatusForbidden, share(`{"role":"edit"}`, editToken).Code)
+	require.Equal(t, http.StatusOK, share(`{"role":"edit"}`, ownerToken).Code)
+	require.Equal(t, http.StatusBadRequest, share(`{"role":"owner"}`, ownerToken).Code)
+
+	w := share(`{"role":"view","ttlMinutes":100000}`, viewToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var shared struct {
+		TokenID string    `json:"tokenId"`
+		Expiry  time.Time `json:"expiry"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	callerExpiry, err := time.Parse(time.RFC3339Nano, created["expires"].(map[string]any)["view"].(string))
+	require.NoError(t, err)
+	require.False(t, shared.Expiry.After(callerExpiry), "share outlives the caller")
+
+	require.Equal(t, http.StatusForbidden, ownerRequest(deps, deps.handler.ListShares, http.MethodGet, roomID, "", editToken).Code)
+	w = ownerRequest(deps, deps.handler.ListShares, http.MethodGet, roomID, "", ownerToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var ledger struct {
+		Shares []model.ShareGrant `json:"shares"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ledger))
+	require.Len(t, ledger.Shares, 6, "three creation tokens and three shares")
+	last := ledger.Shares[len(ledger.Shares)-1]
+	require.Equal(t, shared.TokenID, last.TokenID)
+	require.Equal(t, created["tokenIds"].(map[string]any)["view"], last.IssuedBy)
+}
+
+func TestRoomHandler_RefreshOwnerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	editToken := created["editToken"].(string)
+	ownerToken := created["ownerToken"].(string)
+
+	refresh := func(bearer string) *httptest.ResponseRecorder {
+		return ownerRequest(deps, deps.handler.RefreshOwnerToken, http.MethodPost, roomID, "", bearer)
+	}
+	require.Equal(t, http.StatusUnauthorized, refresh("").Code)
+	require.Equal(t, http.StatusForbidden, refresh(editToken).Code)
+
+	w := refresh(ownerToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var refreshed struct {
+		Token   string    `json:"token"`
+		TokenID string    `json:"tokenId"`
+		Expiry  time.Time `json:"expiry"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+
+	claims, err := deps.handler.verifier.Verify(context.Background(), refreshed.Token)
+	require.NoError(t, err)
+	require.Equal(t, util.RoleOwner, claims.Role)
+	require.Equal(t, refreshed.TokenID, claims.TokenID)
+	ownerExpiry, err := time.Parse(time.RFC3339Nano, created["expires"].(map[string]any)["owner"].(string))
+	require.NoError(t, err)
+	require.False(t, refreshed.Expiry.Before(ownerExpiry))
+
+	// Refreshing rotates: the old token is revoked and cannot renew itself.
+	_, err = deps.handler.verifier.Verify(context.Background(), ownerToken)
+	require.ErrorIs(t, err, auth.ErrRevoked)
+	require.Equal(t, http.StatusUnauthorized, refresh(ownerToken).Code)
+	require.Equal(t, http.StatusUnauthorized, ownerRequest(deps, deps.handler.ListShares, http.MethodGet, roomID, "", ownerToken).Code)
+
+	require.Equal(t, http.StatusOK, refresh(refreshed.Token).Code)
+	require.Equal(t, http.StatusUnauthorized, refresh(refreshed.Token).Code)
+}
+
+func TestRoomHandler_UpdateSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+
+	w := ownerRequest(deps, deps.handler.UpdateSettings, http.MethodPatch, roomID, `{"readOnly":true}`, created["editToken"].(string))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = ownerRequest(deps, deps.handler.UpdateSettings, http.MethodPatch, roomID, `{"readOnly":true}`, created["ownerToken"].(string))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	room, err := deps.store.GetRoom(context.Background(), roomID)
+	require.NoError(t, err)
+	require.True(t, room.Settings.ReadOnly)
+}
+
+func TestRoomHandler_DeleteRoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+
+	w := ownerRequest(deps, deps.handler.DeleteRoom, http.MethodDelete, roomID, "", created["editToken"].(string))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = ownerRequest(deps, deps.handler.DeleteRoom, http.MethodDelete, roomID, "", created["ownerToken"].(string))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err := deps.store.GetRoom(context.Background(), roomID)
+	require.ErrorIs(t, err, model.ErrRoomNotFound)
+}
+
+func TestRoomHandler_ShareRoom_Permissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	ownerToken := created["ownerToken"].(string)
+
+	share := func(body, bearer string) *httptest.ResponseRecorder {
+		return ownerRequest(deps, deps.handler.ShareRoom, http.MethodPost, roomID, body, bearer)
+	}
+
+	require.Equal(t, http.StatusBadRequest, share(`{"role":"edit","permissions":{"ops":["teleport"]}}`, ownerToken).Code)
+	require.Equal(t, http.StatusBadRequest, share(`{"role":"edit","permissions":{"nodeKinds":["dragon"]}}`, ownerToken).Code)
+
+	w := share(`{"role":"edit","permissions":{"nodeKinds":["arrow","freehand"],"layers":["attack"]}}`, ownerToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var scoped struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scoped))
+
+	claims, err := deps.handler.verifier.Verify(context.Background(), scoped.Token)
+	require.NoError(t, err)
+	require.Equal(t, []string{"arrow", "freehand"}, claims.Restrictions.NodeKinds)
+	require.Equal(t, []string{"attack"}, claims.Restrictions.Layers)
+
+	viewToken := created["viewToken"].(string)
+	w = share(`{"role":"view","permissions":{"layers":["attack"]}}`, viewToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var narrowed struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &narrowed))
+	claims, err = deps.handler.verifier.Verify(context.Background(), narrowed.Token)
+	require.NoError(t, err)
+	require.Equal(t, []string{"attack"}, claims.Restrictions.Layers)
+}
+
+func TestNarrowRestrictions(t *testing.T) {
+	caller := &util.Restrictions{NodeKinds: []string{"arrow", "freehand"}}
+
+	inherited, err := narrowRestrictions(caller, nil)
+	require.NoError(t, err)
+	require.Equal(t, caller.NodeKinds, inherited.NodeKinds)
+
+	subset, err := narrowRestrictions(caller, &sharePermissions{NodeKinds: []string{"arrow"}, Layers: []string{"attack"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"arrow"}, subset.NodeKinds)
+	require.Equal(t, []string{"attack"}, subset.Layers)
+
+	_, err = narrowRestrictions(caller, &sharePermissions{NodeKinds: []string{"player"}})
+	require.Error(t, err)
+
+	none, err := narrowRestrictions(nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, none)
+}
+
+func TestRoomHandler_PlayerTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	ctx := context.Background()
+
+	_, err := deps.store.AppendOperation(ctx, model.Operation{
+		RoomID: roomID,
+		Seq:    1,
+		Ops: []json.RawMessage{
+			json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":0,"y":0,"label":"Kim"}}`),
+			json.RawMessage(`{"k":"add","node":{"id":"c1","kind":"cone","x":5,"y":5}}`),
+			json.RawMessage(`{"k":"add","node":{"id":"p2","kind":"player","x":9,"y":9}}`),
+		},
+	})
+	require.NoError(t, err)
+
+	w := ownerRequest(deps, deps.handler.PlayerTokens, http.MethodPost, roomID, "", created["editToken"].(string))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = ownerRequest(deps, deps.handler.PlayerTokens, http.MethodPost, roomID, `{"ttlMinutes":90}`, created["ownerToken"].(string))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Tokens []struct {
+			NodeID string `json:"nodeId"`
+			Label  string `json:"label"`
+			Token  string `json:"token"`
+		} `json:"tokens"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Tokens, 2)
+	require.Equal(t, "p1", resp.Tokens[0].NodeID)
+	require.Equal(t, "Kim", resp.Tokens[0].Label)
+	require.Equal(t, "p2", resp.Tokens[1].NodeID)
+
+	claims, err := deps.handler.verifier.Verify(ctx, resp.Tokens[0].Token)
+	require.NoError(t, err)
+	require.Equal(t, util.RoleEdit, claims.Role)
+	require.Equal(t, "Kim", claims.DisplayName)
+	require.Equal(t, []string{"p1"}, claims.Restrictions.NodeIDs)
+	require.WithinDuration(t, time.Now().Add(90*time.Minute), claims.ExpiresAt, time.Minute)
+}
+
+func postJoin(deps testDeps, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req := httptest.NewRequest(http.MethodPost, "/api/join", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.1:1234"
+	c.Request = req
+	deps.handler.Join(c)
+	return w
+}
+
+func TestRoomHandler_JoinCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	viewToken := created["viewToken"].(string)
+	ownerToken := created["ownerToken"].(string)
+
+	createCode := func(body, bearer string) *httptest.ResponseRecorder {
+		return ownerRequest(deps, deps.handler.CreateJoinCode, http.MethodPost, roomID, body, bearer)
+	}
+	require.Equal(t, http.StatusUnauthorized, createCode(`{"role":"view"}`, "").Code)
+	require.Equal(t, http.StatusForbidden, createCode(`{"role":"edit"}`, viewToken).Code)
+	require.Equal(t, http.StatusBadRequest, createCode(`{"role":"view","maxUses":-1}`, viewToken).Code)
+
+	w := createCode(`{"role":"edit","maxUses":2,"ttlMinutes":30}`, ownerToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var code struct {
+		Code    string `json:"code"`
+		MaxUses int    `json:"maxUses"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &code))
+	require.Regexp(t, `^[A-Z2-9]{3}-[A-Z2-9]{3}$`, code.Code)
+	require.Equal(t, 2, code.MaxUses)
+
+	w = postJoin(deps, `{"code":"`+strings.ToLower(code.Code)+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var joined struct {
+		RoomID string `json:"roomId"`
+		Token  string `json:"token"`
+		Role   string `json:"role"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &joined))
+	require.Equal(t, roomID, joined.RoomID)
+	require.Equal(t, "edit", joined.Role)
+	claims, err := deps.handler.verifier.Verify(context.Background(), joined.Token)
+	require.NoError(t, err)
+	require.Equal(t, util.RoleEdit, claims.Role)
+
+	shares, err := deps.store.ListShares(context.Background(), roomID)
+	require.NoError(t, err)
+	require.Equal(t, created["tokenIds"].(map[string]any)["owner"], shares[len(shares)-1].IssuedBy)
+
+	require.Equal(t, http.StatusOK, postJoin(deps, `{"code":"`+code.Code+`"}`).Code)
+	require.Equal(t, http.StatusNotFound, postJoin(deps, `{"code":"`+code.Code+`"}`).Code, "used up")
+}
+
+func TestRoomHandler_JoinThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+
+	w := ownerRequest(deps, deps.handler.CreateJoinCode, http.MethodPost, roomID, `{"role":"view","maxUses":10}`, created["viewToken"].(string))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var code struct {
+		Code string `json:"code"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &code))
+
+	// Successful joins do not count against the failure budget.
+	for range 5 {
+		require.Equal(t, http.StatusOK, postJoin(deps, `{"code":"`+code.Code+`"}`).Code)
+	}
+	for range 3 {
+		require.Equal(t, http.StatusNotFound, postJoin(deps, `{"code":"ZZZ-ZZZ"}`).Code)
+	}
+	w = postJoin(deps, `{"code":"`+code.Code+`"}`)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestRoomHandler_Invites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	deps := newTestDeps(t)
+	created := createTestRoom(t, deps)
+	roomID := created["id"].(string)
+	viewToken := created["viewToken"].(string)
+	ownerToken := created["ownerToken"].(string)
+
+	createInvite := func(body, bearer string) *httptest.ResponseRecorder {
+		return ownerRequest(deps, deps.handler.CreateInvite, http.MethodPost, roomID, body, bearer)
+	}
+	require.Equal(t, http.StatusUnauthorized, createInvite(`{"role":"view"}`, "").Code)
+	require.Equal(t, http.StatusForbidden, createInvite(`{"role":"edit"}`, viewToken).Code)
+	require.Equal(t, http.StatusBadRequest, createInvite(`{"role":"view","maxRedemptions":5000}`, viewToken).Code)
+
+	w := createInvite(`{"role":"edit","maxRedemptions":2,"permissions":{"ops":["move"]}}`, ownerToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var invite struct {
+		Invite model.Invite `json:"invite"`
+		Link   string       `json:"link"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &invite))
+	require.Equal(t, 2, invite.Invite.MaxRedemptions)
+	require.Contains(t, invite.Link, "invite="+invite.Invite.ID)
+
+	redeem := func(inviteID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+roomID+"/invites/"+inviteID+"/redeem", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: roomID}, {Key: "inviteId", Value: inviteID}}
+		c.Request = req
+		deps.handler.RedeemInvite(c)
+		return w
+	}
+
+	w = redeem(invite.Invite.ID, `{"name":"Alex"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var first struct {
+		Token   string `json:"token"`
+		TokenID string `json:"tokenId"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	claims, err := deps.handler.verifier.Verify(context.Background(), first.Token)
+	require.NoError(t, err)
+	require.Equal(t, util.RoleEdit, claims.Role)
+	require.Equal(t, "Alex", claims.DisplayName)
+	require.Equal(t, []string{"move"}, claims.Restrictions.OpKinds)
+
+	w = redeem(invite.Invite.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var second struct {
+		TokenID string `json:"tokenId"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	require.NotEqual(t, first.TokenID, second.TokenID, "each redemption mints a personal token")
+	require.Equal(t, http.StatusNotFound, redeem(invite.Invite.ID, "").Code, "used up")
+
+	w = createInvite(`{"role":"view","maxRedemptions":5,"ttlMinutes":10}`, viewToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var viewInvite struct {
+		Invite model.Invite `json:"invite"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &viewInvite))
+
+	revoke := func(inviteID, bearer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+roomID+"/invites/"+inviteID, nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		c.Params = gin.Params{{Key: "id", Value: roomID}, {Key: "inviteId", Value: inviteID}}
+		c.Request = req
+		deps.handler.RevokeInvite(c)
+		return w
+	}
+	require.Equal(t, http.StatusForbidden, revoke(viewInvite.Invite.ID, viewToken).Code)
+	require.Equal(t, http.StatusNoContent, revoke(viewInvite.Invite.ID, ownerToken).Code)
+	require.Equal(t, http.StatusNotFound, revoke("missing", ownerToken).Code)
+	require.Equal(t, http.StatusNotFound, redeem(viewInvite.Invite.ID, "").Code, "revoked")
+
+	require.Equal(t, http.StatusForbidden, ownerRequest(deps, deps.handler.ListInvites, http.MethodGet, roomID, "", viewToken).Code)
+	w = ownerRequest(deps, deps.handler.ListInvites, http.MethodGet, roomID, "", ownerToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Invites []model.Invite `json:"invites"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.Invites, 2)
+	require.Equal(t, 2, listed.Invites[0].Redemptions)
+	require.NotNil(t, listed.Invites[1].RevokedAt)
+}
@@ -1,47 +1,145 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 
+	"github.com/traweezy/tacticboard/internal/auth"
 	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/http/middleware"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
 	"github.com/traweezy/tacticboard/internal/ws"
 )
 
+const bearerProtocolPrefix = "bearer."
+
 // WSHandler upgrades HTTP connections into websocket sessions handled by the hub.
 type WSHandler struct {
-	hub *ws.Hub
-	log *zap.Logger
-	upg websocket.Upgrader
+	hub      *ws.Hub
+	store    store.Store
+	verifier *auth.Verifier
+	log      *zap.Logger
+	upg      websocket.Upgrader
 }
 
-func NewWSHandler(cfg config.Config, hub *ws.Hub, log *zap.Logger) *WSHandler {
+func NewWSHandler(cfg config.Config, hub *ws.Hub, store store.Store, verifier *auth.Verifier, log *zap.Logger) *WSHandler {
+	origins := middleware.NewOriginPolicy(cfg.AllowedOrigins)
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:    1024,
 		WriteBufferSize:   cfg.WSWriteBuffer,
 		EnableCompression: true,
-		CheckOrigin: func(r *http.Request) bool {
-			// Rely on capability tokens for authorization. Additional origin checks can be configured via reverse proxy.
-			return true
-		},
+		CheckOrigin:       origins.AllowsRequest,
+		Subprotocols:      []string{ws.Subprotocol},
 	}
 
 	return &WSHandler{
-		hub: hub,
-		log: log.Named("ws_handler"),
-		upg: upgrader,
+		hub:      hub,
+		store:    store,
+		verifier: verifier,
+		log:      log.Named("ws_handler"),
+		upg:      upgrader,
 	}
 }
 
+// Serve authenticates the capability token and checks the room before
+// upgrading, so invalid links get a plain 401 or 404 instead of a socket.
 func (h *WSHandler) Serve(c *gin.Context) {
+	roomID := c.Param("id")
+
+	token := requestToken(c.Request)
+	claims, ok := verifyCapability(c, h.verifier, h.log, roomID, token)
+	if !ok {
+		return
+	}
+
+	if _, err := h.store.GetRoom(c.Request.Context(), roomID); err != nil {
+		if errors.Is(err, model.ErrRoomNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		h.log.Error("load room", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return
+	}
+
+	adm, err := h.hub.Admit(ws.Capability{Token: token, Claims: claims}, c.ClientIP())
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, ws.ErrTooManyConnections) {
+			status = http.StatusTooManyRequests
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := h.upg.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		adm.Release()
 		h.log.Warn("upgrade failed", zap.Error(err))
 		return
 	}
 
-	h.hub.HandleConnection(c.Request.Context(), conn)
+	h.hub.HandleConnection(c.Request.Context(), conn, adm)
+}
+
+// requestToken extracts a capability token from the Authorization header, a
+// "bearer.<token>" websocket subprotocol or the token query parameter, in that
+// order. Browsers cannot set headers on websocket requests, hence the
+// alternatives.
+func requestToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, bearerProtocolPrefix); ok {
+			return token
+		}
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// verifyCapability checks token for roomID and writes a 401 on failure.
+func verifyCapability(c *gin.Context, verifier *auth.Verifier, log *zap.Logger, roomID, token string) (util.CapabilityClaims, bool) {
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "capability token required"})
+		return util.CapabilityClaims{}, false
+	}
+
+	claims, err := verifier.Verify(c.Request.Context(), token)
+	switch {
+	case errors.Is(err, auth.ErrRevoked):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "capability revoked"})
+		return util.CapabilityClaims{}, false
+	case errors.Is(err, auth.ErrInvalidToken):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid capability token"})
+		return util.CapabilityClaims{}, false
+	case err != nil:
+		log.Error("verify capability", zap.String("room", roomID), zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to verify capability"})
+		return util.CapabilityClaims{}, false
+	}
+
+	if claims.RoomID != roomID {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token does not match room"})
+		return util.CapabilityClaims{}, false
+	}
+	return claims, true
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/auth"
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/observability"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+	"github.com/traweezy/tacticboard/internal/ws"
+)
+
+func newWSTestServer(t *testing.T) (string, config.Config) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Config{JWTSecret: strings.Repeat("s", 16), WSReadLimit: 1 << 20}
+	st := store.NewMemoryStore()
+	_, err := st.CreateRoom(context.Background(), model.Room{ID: "room-1"})
+	require.NoError(t, err)
+
+	hub := newTestHub(cfg, st)
+	handler := NewWSHandler(cfg, hub, st, auth.NewVerifier(auth.NewStaticKeyring(cfg.JWTSecret), nil, st), zap.NewNop())
+
+	engine := gin.New()
+	engine.GET("/ws/room/:id", handler.Serve)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), cfg
+}
+
+func newTestHub(cfg config.Config, st store.Store) *ws.Hub {
+	telemetry := &observability.Telemetry{
+		TracerProvider: trace.NewNoopTracerProvider(),
+		MeterProvider:  noop.NewMeterProvider(),
+	}
+	return ws.NewHub(cfg, st, bus.NewMemoryBus(), auth.NewVerifier(auth.NewStaticKeyring(cfg.JWTSecret), nil, st), zap.NewNop(), telemetry, nil)
+}
+
+func wsTestToken(t *testing.T, cfg config.Config, roomID string) string {
+	t.Helper()
+	now := time.Now()
+	token, err := util.GenerateCapabilityToken([]byte(cfg.JWTSecret), util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      util.RoleView,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func TestWSHandler_RejectsBeforeUpgrade(t *testing.T) {
+	url, cfg := newWSTestServer(t)
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "missing token", path: "/ws/room/room-1", status: http.StatusUnauthorized},
+		{name: "bad token", path: "/ws/room/room-1?token=garbage", status: http.StatusUnauthorized},
+		{name: "other room", path: "/ws/room/room-1?token=" + wsTestToken(t, cfg, "room-2"), status: http.StatusUnauthorized},
+		{name: "unknown room", path: "/ws/room/room-2?token=" + wsTestToken(t, cfg, "room-2"), status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, resp, err := websocket.DefaultDialer.Dial(url+tc.path, nil)
+			require.Error(t, err)
+			require.NotNil(t, resp)
+			require.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestWSHandler_AcceptsTokenSources(t *testing.T) {
+	url, cfg := newWSTestServer(t)
+	token := wsTestToken(t, cfg, "room-1")
+
+	dialers := map[string]func() (*websocket.Conn, *http.Response, error){
+		"query": func() (*websocket.Conn, *http.Response, error) {
+			return websocket.DefaultDialer.Dial(url+"/ws/room/room-1?token="+token, nil)
+		},
+		"header": func() (*websocket.Conn, *http.Response, error) {
+			return websocket.DefaultDialer.Dial(url+"/ws/room/room-1", http.Header{"Authorization": {"Bearer " + token}})
+		},
+		"subprotocol": func() (*websocket.Conn, *http.Response, error) {
+			dialer := *websocket.DefaultDialer
+			dialer.Subprotocols = []string{ws.Subprotocol, "bearer." + token}
+			return dialer.Dial(url+"/ws/room/room-1", nil)
+		},
+	}
+	for name, dial := range dialers {
+		t.Run(name, func(t *testing.T) {
+			conn, resp, err := dial()
+			require.NoError(t, err)
+			defer conn.Close()
+			require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+			require.NoError(t, conn.WriteJSON(ws.HelloMessage{Type: ws.TypeHello, RoomID: "room-1", Role: string(util.RoleView)}))
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			var msg map[string]any
+			require.NoError(t, conn.ReadJSON(&msg))
+			require.Equal(t, ws.TypeCaughtUp, msg["type"])
+		})
+	}
+}
@@ -10,16 +10,48 @@ import (
 	"golang.org/x/time/rate"
 )
 
-// CORSMiddleware enforces the configured allowlist of origins.
-func CORSMiddleware(allowed []string) gin.HandlerFunc {
-	allowAll := len(allowed) == 0
-	allowedSet := make(map[string]struct{}, len(allowed))
+// OriginPolicy reports whether a browser origin is on the configured
+// allowlist. An empty allowlist admits every origin.
+type OriginPolicy struct {
+	allowAll bool
+	allowed  map[string]struct{}
+}
+
+// NewOriginPolicy builds a policy from the configured origins.
+func NewOriginPolicy(allowed []string) OriginPolicy {
+	policy := OriginPolicy{
+		allowAll: len(allowed) == 0,
+		allowed:  make(map[string]struct{}, len(allowed)),
+	}
 	for _, origin := range allowed {
 		if origin == "" {
 			continue
 		}
-		allowedSet[origin] = struct{}{}
+		policy.allowed[origin] = struct{}{}
 	}
+	return policy
+}
+
+// Allows checks a single Origin header value.
+func (p OriginPolicy) Allows(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	_, ok := p.allowed[strings.TrimSpace(origin)]
+	return ok
+}
+
+// AllowsRequest applies the policy to r. Requests without an Origin header
+// are not browser cross-origin requests and are allowed.
+func (p OriginPolicy) AllowsRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || p.Allows(origin)
+}
+
+// CORSMiddleware enforces the configured allowlist of origins.
+func CORSMiddleware(allowed []string) gin.HandlerFunc {
+	policy := NewOriginPolicy(allowed)
+	allowAll := policy.allowAll
 
 	return func(c *gin.Context) {
 		origin := c.GetHeader("Origin")
@@ -29,8 +61,7 @@ func CORSMiddleware(allowed []string) gin.HandlerFunc {
 		}
 
 		origin = strings.TrimSpace(origin)
-		_, ok := allowedSet[origin]
-		if !allowAll && !ok {
+		if !policy.Allows(origin) {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
@@ -90,6 +121,17 @@ func (l *IPRateLimiter) getLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
+// Exhausted reports whether ip's bucket is empty without taking a token.
+// Together with Charge it limits actions that only count when they fail.
+func (l *IPRateLimiter) Exhausted(ip string) bool {
+	return l.getLimiter(ip).Tokens() < 1
+}
+
+// Charge takes a token from ip's bucket.
+func (l *IPRateLimiter) Charge(ip string) {
+	l.getLimiter(ip).Allow()
+}
+
 // Middleware returns the Gin middleware that enforces the rate limit.
 func (l *IPRateLimiter) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -55,7 +55,19 @@ func NewEngine(cfg config.Config, rooms *handlers.RoomHandler, health *handlers.
 		api.GET("/health", health.Handle)
 		api.POST("/rooms", rooms.CreateRoom)
 		api.GET("/rooms/:id", rooms.GetRoom)
+		api.DELETE("/rooms/:id", rooms.DeleteRoom)
+		api.PATCH("/rooms/:id/settings", rooms.UpdateSettings)
 		api.POST("/rooms/:id/share", rooms.ShareRoom)
+		api.GET("/rooms/:id/shares", rooms.ListShares)
+		api.POST("/rooms/:id/owner-token", rooms.RefreshOwnerToken)
+		api.POST("/rooms/:id/player-tokens", rooms.PlayerTokens)
+		api.POST("/rooms/:id/codes", rooms.CreateJoinCode)
+		api.POST("/join", rooms.Join)
+		api.POST("/rooms/:id/invites", rooms.CreateInvite)
+		api.GET("/rooms/:id/invites", rooms.ListInvites)
+		api.DELETE("/rooms/:id/invites/:inviteId", rooms.RevokeInvite)
+		api.POST("/rooms/:id/invites/:inviteId/redeem", rooms.RedeemInvite)
+		api.DELETE("/rooms/:id/tokens/:tokenId", rooms.RevokeToken)
 	}
 
 	engine.GET("/ws/room/:id", ws.Serve)
@@ -9,4 +9,15 @@ var (
 	ErrSequenceConflict = errors.New("sequence conflict")
 	// ErrSnapshotNotFound occurs when no snapshot is available for the room.
 	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrDuplicateBatch signals that an operation with the same batch key was
+	// already committed; AppendOperation returns that operation alongside it.
+	ErrDuplicateBatch = errors.New("duplicate batch")
+	// ErrJoinCodeNotFound is returned for join codes that do not exist, have
+	// expired or have no uses left.
+	ErrJoinCodeNotFound = errors.New("join code not found")
+	// ErrJoinCodeExists signals that a generated join code is already taken.
+	ErrJoinCodeExists = errors.New("join code already exists")
+	// ErrInviteNotFound is returned for invites that do not exist in the room
+	// or, when redeeming, have been revoked, expired or used up.
+	ErrInviteNotFound = errors.New("invite not found")
 )
@@ -7,11 +7,18 @@ import (
 
 // Room captures metadata about a collaborative room.
 type Room struct {
-	ID         string    `json:"id"`
-	CreatedAt  time.Time `json:"createdAt"`
-	UpdatedAt  time.Time `json:"updatedAt"`
-	CurrentSeq int64     `json:"currentSeq"`
-	Snapshot   *Snapshot `json:"snapshot,omitempty"`
+	ID         string       `json:"id"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+	CurrentSeq int64        `json:"currentSeq"`
+	Settings   RoomSettings `json:"settings"`
+	Snapshot   *Snapshot    `json:"snapshot,omitempty"`
+}
+
+// RoomSettings holds options the room owner can change after creation.
+type RoomSettings struct {
+	// ReadOnly freezes the board: op batches are refused for every role.
+	ReadOnly bool `json:"readOnly"`
 }
 
 // Snapshot represents the full state of a room at a particular sequence.
@@ -22,12 +29,78 @@ type Snapshot struct {
 	CreatedAt time.Time       `json:"createdAt"`
 }
 
-// Operation is a batch of ordered ops applied to a room.
+// RevokedToken records a capability token that must no longer be honoured.
+type RevokedToken struct {
+	RoomID    string    `json:"roomId"`
+	TokenID   string    `json:"tokenId"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+// ShareGrant is a share ledger entry recording a capability token minted for
+// a room. IssuedBy is the token id of the capability that minted it and is
+// empty for the tokens handed out at room creation.
+type ShareGrant struct {
+	RoomID    string    `json:"roomId"`
+	TokenID   string    `json:"tokenId"`
+	Role      string    `json:"role"`
+	IssuedBy  string    `json:"issuedBy,omitempty"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// JoinCode is a short code that can be exchanged for a capability token.
+// Restrictions holds the encoded permissions carried over to the minted token
+// and is empty when it is unrestricted. Minted tokens never outlive
+// TokenNotAfter, the expiry of the capability that created the code.
+type JoinCode struct {
+	Code          string          `json:"code"`
+	RoomID        string          `json:"roomId"`
+	Role          string          `json:"role"`
+	Restrictions  json.RawMessage `json:"restrictions,omitempty"`
+	CreatedBy     string          `json:"createdBy"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	ExpiresAt     time.Time       `json:"expiresAt"`
+	TokenNotAfter time.Time       `json:"tokenNotAfter"`
+	MaxUses       int             `json:"maxUses"`
+	Uses          int             `json:"uses"`
+}
+
+// Invite is an invitation link that mints a personal capability token each
+// time it is redeemed, up to MaxRedemptions times. Restrictions and
+// TokenNotAfter have the same meaning as on JoinCode.
+type Invite struct {
+	ID             string          `json:"id"`
+	RoomID         string          `json:"roomId"`
+	Role           string          `json:"role"`
+	Restrictions   json.RawMessage `json:"restrictions,omitempty"`
+	CreatedBy      string          `json:"createdBy"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	ExpiresAt      time.Time       `json:"expiresAt"`
+	TokenNotAfter  time.Time       `json:"tokenNotAfter"`
+	MaxRedemptions int             `json:"maxRedemptions"`
+	Redemptions    int             `json:"redemptions"`
+	RevokedAt      *time.Time      `json:"revokedAt,omitempty"`
+}
+
+// Author identifies the capability that committed an operation. It is empty
+// for operations stored before authors were recorded.
+type Author struct {
+	TokenID string `json:"tokenId"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Operation is a batch of ordered ops applied to a room. CreatedAt is the
+// server commit time.
 type Operation struct {
-	RoomID    string            `json:"roomId"`
-	Seq       int64             `json:"seq"`
-	Ops       []json.RawMessage `json:"ops"`
-	CreatedAt time.Time         `json:"createdAt"`
+	RoomID string            `json:"roomId"`
+	Seq    int64             `json:"seq"`
+	Ops    []json.RawMessage `json:"ops"`
+	Author Author            `json:"author"`
+	// BatchKey identifies the client batch the operation commits so a retried
+	// batch is recognised after the hub forgets it. Empty when the client sent
+	// no batch id.
+	BatchKey  string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // Clone returns a deep copy of the operation ensuring payload immutability.
@@ -35,6 +108,8 @@ func (o Operation) Clone() Operation {
 	clone := Operation{
 		RoomID:    o.RoomID,
 		Seq:       o.Seq,
+		Author:    o.Author,
+		BatchKey:  o.BatchKey,
 		CreatedAt: o.CreatedAt,
 	}
 
@@ -75,6 +75,26 @@ func (s instrumentedStore) GetRoom(ctx context.Context, roomID string) (model.Ro
 	return result, err
 }
 
+func (s instrumentedStore) DeleteRoom(ctx context.Context, roomID string) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.DeleteRoom")
+	defer span.End()
+
+	err := s.Store.DeleteRoom(ctx, roomID)
+	s.record(ctx, start, "DeleteRoom", span, err)
+	return err
+}
+
+func (s instrumentedStore) UpdateRoomSettings(ctx context.Context, roomID string, settings model.RoomSettings) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.UpdateRoomSettings")
+	defer span.End()
+
+	err := s.Store.UpdateRoomSettings(ctx, roomID, settings)
+	s.record(ctx, start, "UpdateRoomSettings", span, err)
+	return err
+}
+
 func (s instrumentedStore) SaveSnapshot(ctx context.Context, snapshot model.Snapshot) error {
 	start := time.Now()
 	ctx, span := s.tracer.Start(ctx, "store.SaveSnapshot")
@@ -115,6 +135,106 @@ func (s instrumentedStore) OperationsSince(ctx context.Context, roomID string, s
 	return result, err
 }
 
+func (s instrumentedStore) RevokeToken(ctx context.Context, revoked model.RevokedToken) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.RevokeToken")
+	defer span.End()
+
+	err := s.Store.RevokeToken(ctx, revoked)
+	s.record(ctx, start, "RevokeToken", span, err)
+	return err
+}
+
+func (s instrumentedStore) IsTokenRevoked(ctx context.Context, roomID, tokenID string) (bool, error) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.IsTokenRevoked")
+	defer span.End()
+
+	revoked, err := s.Store.IsTokenRevoked(ctx, roomID, tokenID)
+	s.record(ctx, start, "IsTokenRevoked", span, err)
+	return revoked, err
+}
+
+func (s instrumentedStore) RecordShare(ctx context.Context, grant model.ShareGrant) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.RecordShare")
+	defer span.End()
+
+	err := s.Store.RecordShare(ctx, grant)
+	s.record(ctx, start, "RecordShare", span, err)
+	return err
+}
+
+func (s instrumentedStore) ListShares(ctx context.Context, roomID string) ([]model.ShareGrant, error) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.ListShares")
+	defer span.End()
+
+	shares, err := s.Store.ListShares(ctx, roomID)
+	s.record(ctx, start, "ListShares", span, err)
+	return shares, err
+}
+
+func (s instrumentedStore) CreateJoinCode(ctx context.Context, code model.JoinCode) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.CreateJoinCode")
+	defer span.End()
+
+	err := s.Store.CreateJoinCode(ctx, code)
+	s.record(ctx, start, "CreateJoinCode", span, err)
+	return err
+}
+
+func (s instrumentedStore) RedeemJoinCode(ctx context.Context, code string, now time.Time) (model.JoinCode, error) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.RedeemJoinCode")
+	defer span.End()
+
+	result, err := s.Store.RedeemJoinCode(ctx, code, now)
+	s.record(ctx, start, "RedeemJoinCode", span, err)
+	return result, err
+}
+
+func (s instrumentedStore) CreateInvite(ctx context.Context, invite model.Invite) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.CreateInvite")
+	defer span.End()
+
+	err := s.Store.CreateInvite(ctx, invite)
+	s.record(ctx, start, "CreateInvite", span, err)
+	return err
+}
+
+func (s instrumentedStore) RedeemInvite(ctx context.Context, roomID, inviteID string, now time.Time) (model.Invite, error) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.RedeemInvite")
+	defer span.End()
+
+	result, err := s.Store.RedeemInvite(ctx, roomID, inviteID, now)
+	s.record(ctx, start, "RedeemInvite", span, err)
+	return result, err
+}
+
+func (s instrumentedStore) ListInvites(ctx context.Context, roomID string) ([]model.Invite, error) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.ListInvites")
+	defer span.End()
+
+	invites, err := s.Store.ListInvites(ctx, roomID)
+	s.record(ctx, start, "ListInvites", span, err)
+	return invites, err
+}
+
+func (s instrumentedStore) RevokeInvite(ctx context.Context, roomID, inviteID string, at time.Time) error {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "store.RevokeInvite")
+	defer span.End()
+
+	err := s.Store.RevokeInvite(ctx, roomID, inviteID, at)
+	s.record(ctx, start, "RevokeInvite", span, err)
+	return err
+}
+
 func (s instrumentedStore) record(ctx context.Context, start time.Time, operation string, span trace.Span, err error) {
 	if err != nil {
 		span.RecordError(err)
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sort"
 	"sync"
@@ -14,18 +15,24 @@ import (
 type memoryStore struct {
 	mu    sync.RWMutex
 	rooms map[string]*roomRecord
+	codes map[string]*model.JoinCode
 }
 
 type roomRecord struct {
 	room     model.Room
 	snapshot *model.Snapshot
 	ops      []model.Operation
+	batches  map[string]int64
+	revoked  map[string]time.Time
+	shares   []model.ShareGrant
+	invites  []*model.Invite
 }
 
 // NewMemoryStore constructs the default in-memory store.
 func NewMemoryStore() Store {
 	return &memoryStore{
 		rooms: make(map[string]*roomRecord),
+		codes: make(map[string]*model.JoinCode),
 	}
 }
 
@@ -68,6 +75,34 @@ func (m *memoryStore) GetRoom(_ context.Context, roomID string) (model.Room, err
 	return copyRoom(record), nil
 }
 
+func (m *memoryStore) DeleteRoom(_ context.Context, roomID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return model.ErrRoomNotFound
+	}
+	delete(m.rooms, roomID)
+	for code, joinCode := range m.codes {
+		if joinCode.RoomID == roomID {
+			delete(m.codes, code)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) UpdateRoomSettings(_ context.Context, roomID string, settings model.RoomSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.rooms[roomID]
+	if !ok {
+		return model.ErrRoomNotFound
+	}
+	record.room.Settings = settings
+	return nil
+}
+
 func (m *memoryStore) SaveSnapshot(_ context.Context, snapshot model.Snapshot) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -77,11 +112,19 @@ func (m *memoryStore) SaveSnapshot(_ context.Context, snapshot model.Snapshot) e
 		return model.ErrRoomNotFound
 	}
 
+	if record.snapshot != nil && snapshot.Seq < record.snapshot.Seq {
+		return nil
+	}
+
 	clone := cloneSnapshot(&snapshot)
 	record.snapshot = clone
 	record.room.Snapshot = clone
-	record.room.CurrentSeq = snapshot.Seq
-	record.room.UpdatedAt = snapshot.CreatedAt
+	if snapshot.Seq > record.room.CurrentSeq {
+		record.room.CurrentSeq = snapshot.Seq
+	}
+	if snapshot.CreatedAt.After(record.room.UpdatedAt) {
+		record.room.UpdatedAt = snapshot.CreatedAt
+	}
 	return nil
 }
 
@@ -110,6 +153,17 @@ func (m *memoryStore) AppendOperation(_ context.Context, op model.Operation) (mo
 		return model.Operation{}, model.ErrRoomNotFound
 	}
 
+	if op.BatchKey != "" {
+		if seq, ok := record.batches[op.BatchKey]; ok {
+			idx := sort.Search(len(record.ops), func(i int) bool {
+				return record.ops[i].Seq >= seq
+			})
+			if idx < len(record.ops) && record.ops[idx].Seq == seq {
+				return record.ops[idx].Clone(), model.ErrDuplicateBatch
+			}
+		}
+	}
+
 	expectedSeq := record.room.CurrentSeq + 1
 	if op.Seq != expectedSeq {
 		return model.Operation{}, model.ErrSequenceConflict
@@ -117,6 +171,12 @@ func (m *memoryStore) AppendOperation(_ context.Context, op model.Operation) (mo
 
 	op.CreatedAt = time.Now().UTC()
 	record.ops = append(record.ops, op.Clone())
+	if op.BatchKey != "" {
+		if record.batches == nil {
+			record.batches = make(map[string]int64)
+		}
+		record.batches[op.BatchKey] = op.Seq
+	}
 	record.room.CurrentSeq = op.Seq
 	record.room.UpdatedAt = op.CreatedAt
 
@@ -167,3 +227,188 @@ func copyRoom(record *roomRecord) model.Room {
 	room.Snapshot = cloneSnapshot(record.snapshot)
 	return room
 }
+
+func (m *memoryStore) RevokeToken(_ context.Context, revoked model.RevokedToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.rooms[revoked.RoomID]
+	if !ok {
+		return model.ErrRoomNotFound
+	}
+
+	if record.revoked == nil {
+		record.revoked = make(map[string]time.Time)
+	}
+	if _, exists := record.revoked[revoked.TokenID]; exists {
+		return nil
+	}
+	if revoked.RevokedAt.IsZero() {
+		revoked.RevokedAt = time.Now().UTC()
+	}
+	record.revoked[revoked.TokenID] = revoked.RevokedAt
+	return nil
+}
+
+func (m *memoryStore) IsTokenRevoked(_ context.Context, roomID, tokenID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.rooms[roomID]
+	if !ok {
+		return false, model.ErrRoomNotFound
+	}
+	_, revoked := record.revoked[tokenID]
+	return revoked, nil
+}
+
+func (m *memoryStore) RecordShare(_ context.Context, grant model.ShareGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.rooms[grant.RoomID]
+	if !ok {
+		return model.ErrRoomNotFound
+	}
+	record.shares = append(record.shares, grant)
+	return nil
+}
+
+func (m *memoryStore) ListShares(_ context.Context, roomID string) ([]model.ShareGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.rooms[roomID]
+	if !ok {
+		return nil, model.ErrRoomNotFound
+	}
+	shares := make([]model.ShareGrant, len(record.shares))
+	copy(shares, record.shares)
+	return shares, nil
+}
+
+func (m *memoryStore) CreateJoinCode(_ context.Context, code model.JoinCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[code.RoomID]; !ok {
+		return model.ErrRoomNotFound
+	}
+	if _, exists := m.codes[code.Code]; exists {
+		return model.ErrJoinCodeExists
+	}
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
+	code.Restrictions = cloneRaw(code.Restrictions)
+	m.codes[code.Code] = &code
+	return nil
+}
+
+func (m *memoryStore) RedeemJoinCode(_ context.Context, code string, now time.Time) (model.JoinCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	joinCode, ok := m.codes[code]
+	if !ok || !now.Before(joinCode.ExpiresAt) || joinCode.Uses >= joinCode.MaxUses {
+		return model.JoinCode{}, model.ErrJoinCodeNotFound
+	}
+	joinCode.Uses++
+
+	redeemed := *joinCode
+	redeemed.Restrictions = cloneRaw(joinCode.Restrictions)
+	return redeemed, nil
+}
+
+func (m *memoryStore) CreateInvite(_ context.Context, invite model.Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.rooms[invite.RoomID]
+	if !ok {
+		return model.ErrRoomNotFound
+	}
+	if invite.CreatedAt.IsZero() {
+		invite.CreatedAt = time.Now().UTC()
+	}
+	record.invites = append(record.invites, cloneInvite(&invite))
+	return nil
+}
+
+func (m *memoryStore) RedeemInvite(_ context.Context, roomID, inviteID string, now time.Time) (model.Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite := m.findInvite(roomID, inviteID)
+	if invite == nil || invite.RevokedAt != nil || !now.Before(invite.ExpiresAt) || invite.Redemptions >= invite.MaxRedemptions {
+		return model.Invite{}, model.ErrInviteNotFound
+	}
+	invite.Redemptions++
+	return *cloneInvite(invite), nil
+}
+
+func (m *memoryStore) ListInvites(_ context.Context, roomID string) ([]model.Invite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.rooms[roomID]
+	if !ok {
+		return nil, model.ErrRoomNotFound
+	}
+	invites := make([]model.Invite, 0, len(record.invites))
+	for _, invite := range record.invites {
+		invites = append(invites, *cloneInvite(invite))
+	}
+	return invites, nil
+}
+
+func (m *memoryStore) RevokeInvite(_ context.Context, roomID, inviteID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return model.ErrRoomNotFound
+	}
+	invite := m.findInvite(roomID, inviteID)
+	if invite == nil {
+		return model.ErrInviteNotFound
+	}
+	if invite.RevokedAt == nil {
+		at = at.UTC()
+		invite.RevokedAt = &at
+	}
+	return nil
+}
+
+// findInvite returns the stored invite; callers must hold the lock.
+func (m *memoryStore) findInvite(roomID, inviteID string) *model.Invite {
+	record, ok := m.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	for _, invite := range record.invites {
+		if invite.ID == inviteID {
+			return invite
+		}
+	}
+	return nil
+}
+
+func cloneInvite(src *model.Invite) *model.Invite {
+	dst := *src
+	dst.Restrictions = cloneRaw(src.Restrictions)
+	if src.RevokedAt != nil {
+		revokedAt := *src.RevokedAt
+		dst.RevokedAt = &revokedAt
+	}
+	return &dst
+}
+
+func cloneRaw(src json.RawMessage) json.RawMessage {
+	if src == nil {
+		return nil
+	}
+	dst := make(json.RawMessage, len(src))
+	copy(dst, src)
+	return dst
+}
@@ -49,6 +49,30 @@ func TestMemoryStore_AppendOperationSequence(t *testing.T) {
 	require.ErrorIs(t, err, model.ErrSequenceConflict)
 }
 
+func TestMemoryStore_AppendOperationBatchKey(t *testing.T) {
+	store := NewMemoryStore()
+	_, err := store.CreateRoom(context.Background(), model.Room{ID: "room-2"})
+	require.NoError(t, err)
+
+	op := model.Operation{
+		RoomID:   "room-2",
+		Seq:      1,
+		Ops:      []json.RawMessage{json.RawMessage(`{"k":"add"}`)},
+		BatchKey: "token-1/session-1/b1",
+	}
+	_, err = store.AppendOperation(context.Background(), op)
+	require.NoError(t, err)
+
+	op.Seq = 2
+	existing, err := store.AppendOperation(context.Background(), op)
+	require.ErrorIs(t, err, model.ErrDuplicateBatch)
+	require.EqualValues(t, 1, existing.Seq)
+
+	room, err := store.GetRoom(context.Background(), "room-2")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, room.CurrentSeq)
+}
+
 func TestMemoryStore_OperationsSince(t *testing.T) {
 	store := NewMemoryStore()
 	_, err := store.CreateRoom(context.Background(), model.Room{ID: "room-3"})
@@ -98,3 +122,133 @@ func TestMemoryStore_SaveSnapshot(t *testing.T) {
 	require.EqualValues(t, 10, room.CurrentSeq)
 	require.NotNil(t, room.Snapshot)
 }
+
+func TestMemoryStore_RevokeToken(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	_, err := store.CreateRoom(ctx, model.Room{ID: "room-r"})
+	require.NoError(t, err)
+
+	revoked, err := store.IsTokenRevoked(ctx, "room-r", "tok-1")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	require.NoError(t, store.RevokeToken(ctx, model.RevokedToken{RoomID: "room-r", TokenID: "tok-1"}))
+	require.NoError(t, store.RevokeToken(ctx, model.RevokedToken{RoomID: "room-r", TokenID: "tok-1"}))
+
+	revoked, err = store.IsTokenRevoked(ctx, "room-r", "tok-1")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = store.IsTokenRevoked(ctx, "room-r", "tok-2")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	err = store.RevokeToken(ctx, model.RevokedToken{RoomID: "missing", TokenID: "tok-1"})
+	require.ErrorIs(t, err, model.ErrRoomNotFound)
+}
+
+func TestMemoryStore_RoomSettingsAndDelete(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	_, err := store.CreateRoom(ctx, model.Room{ID: "room-s"})
+	require.NoError(t, err)
+
+	require.NoError(t, store.UpdateRoomSettings(ctx, "room-s", model.RoomSettings{ReadOnly: true}))
+	room, err := store.GetRoom(ctx, "room-s")
+	require.NoError(t, err)
+	require.True(t, room.Settings.ReadOnly)
+
+	require.NoError(t, store.DeleteRoom(ctx, "room-s"))
+	_, err = store.GetRoom(ctx, "room-s")
+	require.ErrorIs(t, err, model.ErrRoomNotFound)
+	require.ErrorIs(t, store.DeleteRoom(ctx, "room-s"), model.ErrRoomNotFound)
+	require.ErrorIs(t, store.UpdateRoomSettings(ctx, "room-s", model.RoomSettings{}), model.ErrRoomNotFound)
+}
+
+func TestMemoryStore_ShareLedger(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	_, err := store.CreateRoom(ctx, model.Room{ID: "room-l"})
+	require.NoError(t, err)
+
+	require.NoError(t, store.RecordShare(ctx, model.ShareGrant{RoomID: "room-l", TokenID: "t1", Role: "view"}))
+	require.NoError(t, store.RecordShare(ctx, model.ShareGrant{RoomID: "room-l", TokenID: "t2", Role: "edit", IssuedBy: "t0"}))
+
+	shares, err := store.ListShares(ctx, "room-l")
+	require.NoError(t, err)
+	require.Len(t, shares, 2)
+	require.Equal(t, "t1", shares[0].TokenID)
+	require.Equal(t, "t0", shares[1].IssuedBy)
+
+	require.ErrorIs(t, store.RecordShare(ctx, model.ShareGrant{RoomID: "missing", TokenID: "t"}), model.ErrRoomNotFound)
+	_, err = store.ListShares(ctx, "missing")
+	require.ErrorIs(t, err, model.ErrRoomNotFound)
+}
+
+func TestMemoryStore_JoinCodes(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	_, err := store.CreateRoom(ctx, model.Room{ID: "room-j"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	code := model.JoinCode{Code: "KQ742M", RoomID: "room-j", Role: "view", ExpiresAt: now.Add(time.Hour), MaxUses: 2}
+	require.NoError(t, store.CreateJoinCode(ctx, code))
+	require.ErrorIs(t, store.CreateJoinCode(ctx, code), model.ErrJoinCodeExists)
+	require.ErrorIs(t, store.CreateJoinCode(ctx, model.JoinCode{Code: "AAAAAA", RoomID: "missing"}), model.ErrRoomNotFound)
+
+	redeemed, err := store.RedeemJoinCode(ctx, "KQ742M", now)
+	require.NoError(t, err)
+	require.Equal(t, "room-j", redeemed.RoomID)
+	require.Equal(t, 1, redeemed.Uses)
+	_, err = store.RedeemJoinCode(ctx, "KQ742M", now)
+	require.NoError(t, err)
+	_, err = store.RedeemJoinCode(ctx, "KQ742M", now)
+	require.ErrorIs(t, err, model.ErrJoinCodeNotFound, "used up")
+
+	require.NoError(t, store.CreateJoinCode(ctx, model.JoinCode{Code: "BBBBBB", RoomID: "room-j", ExpiresAt: now.Add(time.Minute), MaxUses: 5}))
+	_, err = store.RedeemJoinCode(ctx, "BBBBBB", now.Add(time.Minute))
+	require.ErrorIs(t, err, model.ErrJoinCodeNotFound, "expired")
+
+	require.NoError(t, store.CreateJoinCode(ctx, model.JoinCode{Code: "CCCCCC", RoomID: "room-j", ExpiresAt: now.Add(time.Hour), MaxUses: 5}))
+	require.NoError(t, store.DeleteRoom(ctx, "room-j"))
+	_, err = store.RedeemJoinCode(ctx, "CCCCCC", now)
+	require.ErrorIs(t, err, model.ErrJoinCodeNotFound, "room deleted")
+}
+
+func TestMemoryStore_Invites(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	_, err := store.CreateRoom(ctx, model.Room{ID: "room-i"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	require.NoError(t, store.CreateInvite(ctx, model.Invite{ID: "inv-1", RoomID: "room-i", Role: "view", ExpiresAt: now.Add(time.Hour), MaxRedemptions: 1}))
+	require.NoError(t, store.CreateInvite(ctx, model.Invite{ID: "inv-2", RoomID: "room-i", Role: "edit", ExpiresAt: now.Add(time.Hour), MaxRedemptions: 3}))
+	require.ErrorIs(t, store.CreateInvite(ctx, model.Invite{ID: "inv-x", RoomID: "missing"}), model.ErrRoomNotFound)
+
+	redeemed, err := store.RedeemInvite(ctx, "room-i", "inv-1", now)
+	require.NoError(t, err)
+	require.Equal(t, 1, redeemed.Redemptions)
+	_, err = store.RedeemInvite(ctx, "room-i", "inv-1", now)
+	require.ErrorIs(t, err, model.ErrInviteNotFound, "used up")
+	_, err = store.RedeemInvite(ctx, "other", "inv-2", now)
+	require.ErrorIs(t, err, model.ErrInviteNotFound, "wrong room")
+	_, err = store.RedeemInvite(ctx, "room-i", "inv-2", now.Add(time.Hour))
+	require.ErrorIs(t, err, model.ErrInviteNotFound, "expired")
+
+	require.NoError(t, store.RevokeInvite(ctx, "room-i", "inv-2", now))
+	require.NoError(t, store.RevokeInvite(ctx, "room-i", "inv-2", now.Add(time.Minute)))
+	require.ErrorIs(t, store.RevokeInvite(ctx, "room-i", "missing", now), model.ErrInviteNotFound)
+	_, err = store.RedeemInvite(ctx, "room-i", "inv-2", now)
+	require.ErrorIs(t, err, model.ErrInviteNotFound, "revoked")
+
+	invites, err := store.ListInvites(ctx, "room-i")
+	require.NoError(t, err)
+	require.Len(t, invites, 2)
+	require.Equal(t, "inv-1", invites[0].ID)
+	require.Equal(t, 1, invites[0].Redemptions)
+	require.NotNil(t, invites[1].RevokedAt)
+	require.True(t, invites[1].RevokedAt.Equal(now), "second revoke keeps the first timestamp")
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/traweezy/tacticboard/internal/model"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -14,6 +15,9 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// uniqueViolationCode is the SQLSTATE raised for duplicate keys.
+const uniqueViolationCode = "23505"
+
 type postgresStore struct {
 	db *gorm.DB
 }
@@ -50,9 +54,15 @@ func (s *postgresStore) CreateRoom(ctx context.Context, room model.Room) (model.
 			return errors.New("room already exists")
 		}
 
+		settings, err := json.Marshal(room.Settings)
+		if err != nil {
+			return err
+		}
+
 		if err := tx.Create(&roomRow{
 			ID:        room.ID,
 			CreatedAt: room.CreatedAt,
+			Settings:  settings,
 		}).Error; err != nil {
 			return err
 		}
@@ -135,6 +145,11 @@ func (s *postgresStore) GetRoom(ctx context.Context, roomID string) (model.Room,
 		UpdatedAt:  updatedAt,
 		CurrentSeq: currentSeq,
 	}
+	if len(roomRec.Settings) > 0 {
+		if err := json.Unmarshal(roomRec.Settings, &room.Settings); err != nil {
+			return model.Room{}, err
+		}
+	}
 
 	if latestSnapshot.RoomID != "" {
 		room.Snapshot = &model.Snapshot{
@@ -148,6 +163,33 @@ func (s *postgresStore) GetRoom(ctx context.Context, roomID string) (model.Room,
 	return room, nil
 }
 
+func (s *postgresStore) DeleteRoom(ctx context.Context, roomID string) error {
+	result := s.db.WithContext(ctx).Delete(&roomRow{}, "id = ?", roomID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.ErrRoomNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) UpdateRoomSettings(ctx context.Context, roomID string, settings model.RoomSettings) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", roomID).Update("settings", body)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.ErrRoomNotFound
+	}
+	return nil
+}
+
 func (s *postgresStore) SaveSnapshot(ctx context.Context, snapshot model.Snapshot) error {
 	if snapshot.RoomID == "" {
 		return errors.New("room id required")
@@ -207,6 +249,23 @@ func (s *postgresStore) AppendOperation(ctx context.Context, op model.Operation)
 
 	var persisted model.Operation
 	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if op.BatchKey != "" {
+			var existing []operationRow
+			if err := tx.Where("room_id = ? AND batch_key = ?", op.RoomID, op.BatchKey).
+				Limit(1).
+				Find(&existing).Error; err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				decoded, err := existing[0].operation()
+				if err != nil {
+					return err
+				}
+				persisted = decoded
+				return model.ErrDuplicateBatch
+			}
+		}
+
 		var lastSeq sql.NullInt64
 		if err := tx.Model(&operationRow{}).
 			Select("MAX(seq)").
@@ -226,19 +285,31 @@ func (s *postgresStore) AppendOperation(ctx context.Context, op model.Operation)
 		}
 
 		record := operationRow{
-			RoomID:    op.RoomID,
-			Seq:       op.Seq,
-			Body:      body,
-			CreatedAt: time.Now().UTC(),
+			RoomID:        op.RoomID,
+			Seq:           op.Seq,
+			Body:          body,
+			AuthorTokenID: op.Author.TokenID,
+			AuthorName:    op.Author.Name,
+			CreatedAt:     time.Now().UTC(),
+		}
+		if op.BatchKey != "" {
+			record.BatchKey = &op.BatchKey
 		}
 
 		if err := tx.Create(&record).Error; err != nil {
+			// A concurrent writer took the seq or committed the same batch;
+			// either way the caller's retry sorts it out.
+			if isUniqueViolation(err) {
+				return model.ErrSequenceConflict
+			}
 			return err
 		}
 
 		persisted = model.Operation{
 			RoomID:    record.RoomID,
 			Seq:       record.Seq,
+			Author:    op.Author,
+			BatchKey:  op.BatchKey,
 			CreatedAt: record.CreatedAt,
 		}
 
@@ -252,6 +323,9 @@ func (s *postgresStore) AppendOperation(ctx context.Context, op model.Operation)
 		return nil
 	})
 
+	if errors.Is(err, model.ErrDuplicateBatch) {
+		return persisted, err
+	}
 	if err != nil {
 		return model.Operation{}, err
 	}
@@ -274,27 +348,255 @@ func (s *postgresStore) OperationsSince(ctx context.Context, roomID string, sinc
 
 	ops := make([]model.Operation, 0, len(records))
 	for _, rec := range records {
-		var payload []json.RawMessage
-		if len(rec.Body) > 0 {
-			if err := json.Unmarshal(rec.Body, &payload); err != nil {
-				return nil, err
-			}
+		op, err := rec.operation()
+		if err != nil {
+			return nil, err
 		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+func (s *postgresStore) RevokeToken(ctx context.Context, revoked model.RevokedToken) error {
+	if revoked.RevokedAt.IsZero() {
+		revoked.RevokedAt = time.Now().UTC()
+	}
 
-		ops = append(ops, model.Operation{
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", revoked.RoomID).Count(&rooms).Error; err != nil {
+		return err
+	}
+	if rooms == 0 {
+		return model.ErrRoomNotFound
+	}
+
+	return s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&revokedTokenRow{
+			RoomID:    revoked.RoomID,
+			TokenID:   revoked.TokenID,
+			RevokedAt: revoked.RevokedAt,
+		}).Error
+}
+
+func (s *postgresStore) IsTokenRevoked(ctx context.Context, roomID, tokenID string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).
+		Model(&revokedTokenRow{}).
+		Where("room_id = ? AND token_id = ?", roomID, tokenID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *postgresStore) RecordShare(ctx context.Context, grant model.ShareGrant) error {
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", grant.RoomID).Count(&rooms).Error; err != nil {
+		return err
+	}
+	if rooms == 0 {
+		return model.ErrRoomNotFound
+	}
+
+	return s.db.WithContext(ctx).Create(&shareRow{
+		RoomID:    grant.RoomID,
+		TokenID:   grant.TokenID,
+		Role:      grant.Role,
+		IssuedBy:  grant.IssuedBy,
+		IssuedAt:  grant.IssuedAt,
+		ExpiresAt: grant.ExpiresAt,
+	}).Error
+}
+
+func (s *postgresStore) ListShares(ctx context.Context, roomID string) ([]model.ShareGrant, error) {
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", roomID).Count(&rooms).Error; err != nil {
+		return nil, err
+	}
+	if rooms == 0 {
+		return nil, model.ErrRoomNotFound
+	}
+
+	var records []shareRow
+	if err := s.db.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("issued_at ASC").
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	shares := make([]model.ShareGrant, 0, len(records))
+	for _, rec := range records {
+		shares = append(shares, model.ShareGrant{
 			RoomID:    rec.RoomID,
-			Seq:       rec.Seq,
-			Ops:       payload,
-			CreatedAt: rec.CreatedAt,
+			TokenID:   rec.TokenID,
+			Role:      rec.Role,
+			IssuedBy:  rec.IssuedBy,
+			IssuedAt:  rec.IssuedAt,
+			ExpiresAt: rec.ExpiresAt,
 		})
 	}
+	return shares, nil
+}
+
+func (s *postgresStore) CreateJoinCode(ctx context.Context, code model.JoinCode) error {
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now().UTC()
+	}
 
-	return ops, nil
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", code.RoomID).Count(&rooms).Error; err != nil {
+		return err
+	}
+	if rooms == 0 {
+		return model.ErrRoomNotFound
+	}
+
+	err := s.db.WithContext(ctx).Create(&joinCodeRow{
+		Code:          code.Code,
+		RoomID:        code.RoomID,
+		Role:          code.Role,
+		Restrictions:  code.Restrictions,
+		CreatedBy:     code.CreatedBy,
+		CreatedAt:     code.CreatedAt,
+		ExpiresAt:     code.ExpiresAt,
+		TokenNotAfter: code.TokenNotAfter,
+		MaxUses:       code.MaxUses,
+	}).Error
+	if isUniqueViolation(err) {
+		return model.ErrJoinCodeExists
+	}
+	return err
+}
+
+func (s *postgresStore) RedeemJoinCode(ctx context.Context, code string, now time.Time) (model.JoinCode, error) {
+	// The guarded increment makes concurrent redemptions of the last use race
+	// on the row lock: only one of them matches.
+	var rows []joinCodeRow
+	result := s.db.WithContext(ctx).
+		Model(&rows).
+		Clauses(clause.Returning{}).
+		Where("code = ? AND expires_at > ? AND uses < max_uses", code, now).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return model.JoinCode{}, result.Error
+	}
+	if len(rows) == 0 {
+		return model.JoinCode{}, model.ErrJoinCodeNotFound
+	}
+
+	row := rows[0]
+	return model.JoinCode{
+		Code:          row.Code,
+		RoomID:        row.RoomID,
+		Role:          row.Role,
+		Restrictions:  cloneBytes(row.Restrictions),
+		CreatedBy:     row.CreatedBy,
+		CreatedAt:     row.CreatedAt,
+		ExpiresAt:     row.ExpiresAt,
+		TokenNotAfter: row.TokenNotAfter,
+		MaxUses:       row.MaxUses,
+		Uses:          row.Uses,
+	}, nil
+}
+
+func (s *postgresStore) CreateInvite(ctx context.Context, invite model.Invite) error {
+	if invite.CreatedAt.IsZero() {
+		invite.CreatedAt = time.Now().UTC()
+	}
+
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", invite.RoomID).Count(&rooms).Error; err != nil {
+		return err
+	}
+	if rooms == 0 {
+		return model.ErrRoomNotFound
+	}
+
+	return s.db.WithContext(ctx).Create(&inviteRow{
+		RoomID:         invite.RoomID,
+		ID:             invite.ID,
+		Role:           invite.Role,
+		Restrictions:   invite.Restrictions,
+		CreatedBy:      invite.CreatedBy,
+		CreatedAt:      invite.CreatedAt,
+		ExpiresAt:      invite.ExpiresAt,
+		TokenNotAfter:  invite.TokenNotAfter,
+		MaxRedemptions: invite.MaxRedemptions,
+	}).Error
+}
+
+func (s *postgresStore) RedeemInvite(ctx context.Context, roomID, inviteID string, now time.Time) (model.Invite, error) {
+	var rows []inviteRow
+	result := s.db.WithContext(ctx).
+		Model(&rows).
+		Clauses(clause.Returning{}).
+		Where("room_id = ? AND id = ? AND revoked_at IS NULL AND expires_at > ? AND redemptions < max_redemptions", roomID, inviteID, now).
+		Update("redemptions", gorm.Expr("redemptions + 1"))
+	if result.Error != nil {
+		return model.Invite{}, result.Error
+	}
+	if len(rows) == 0 {
+		return model.Invite{}, model.ErrInviteNotFound
+	}
+	return rows[0].toModel(), nil
+}
+
+func (s *postgresStore) ListInvites(ctx context.Context, roomID string) ([]model.Invite, error) {
+	var rooms int64
+	if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", roomID).Count(&rooms).Error; err != nil {
+		return nil, err
+	}
+	if rooms == 0 {
+		return nil, model.ErrRoomNotFound
+	}
+
+	var records []inviteRow
+	if err := s.db.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("created_at ASC").
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	invites := make([]model.Invite, 0, len(records))
+	for _, rec := range records {
+		invites = append(invites, rec.toModel())
+	}
+	return invites, nil
+}
+
+func (s *postgresStore) RevokeInvite(ctx context.Context, roomID, inviteID string, at time.Time) error {
+	var invites int64
+	if err := s.db.WithContext(ctx).
+		Model(&inviteRow{}).
+		Where("room_id = ? AND id = ?", roomID, inviteID).
+		Count(&invites).Error; err != nil {
+		return err
+	}
+	if invites == 0 {
+		var rooms int64
+		if err := s.db.WithContext(ctx).Model(&roomRow{}).Where("id = ?", roomID).Count(&rooms).Error; err != nil {
+			return err
+		}
+		if rooms == 0 {
+			return model.ErrRoomNotFound
+		}
+		return model.ErrInviteNotFound
+	}
+
+	return s.db.WithContext(ctx).
+		Model(&inviteRow{}).
+		Where("room_id = ? AND id = ? AND revoked_at IS NULL", roomID, inviteID).
+		Update("revoked_at", at.UTC()).Error
 }
 
 type roomRow struct {
 	ID        string    `gorm:"column:id;primaryKey"`
 	CreatedAt time.Time `gorm:"column:created_at"`
+	Settings  []byte    `gorm:"column:settings"`
 }
 
 func (roomRow) TableName() string { return "rooms" }
@@ -309,14 +611,113 @@ type snapshotRow struct {
 func (snapshotRow) TableName() string { return "snapshots" }
 
 type operationRow struct {
-	RoomID    string    `gorm:"column:room_id;primaryKey"`
-	Seq       int64     `gorm:"column:seq;primaryKey"`
-	Body      []byte    `gorm:"column:body"`
-	CreatedAt time.Time `gorm:"column:created_at"`
+	RoomID        string    `gorm:"column:room_id;primaryKey"`
+	Seq           int64     `gorm:"column:seq;primaryKey"`
+	Body          []byte    `gorm:"column:body"`
+	AuthorTokenID string    `gorm:"column:author_token_id"`
+	AuthorName    string    `gorm:"column:author_name"`
+	BatchKey      *string   `gorm:"column:batch_key"`
+	CreatedAt     time.Time `gorm:"column:created_at"`
 }
 
 func (operationRow) TableName() string { return "ops" }
 
+func (r operationRow) operation() (model.Operation, error) {
+	var payload []json.RawMessage
+	if len(r.Body) > 0 {
+		if err := json.Unmarshal(r.Body, &payload); err != nil {
+			return model.Operation{}, err
+		}
+	}
+
+	op := model.Operation{
+		RoomID: r.RoomID,
+		Seq:    r.Seq,
+		Ops:    payload,
+		Author: model.Author{
+			TokenID: r.AuthorTokenID,
+			Name:    r.AuthorName,
+		},
+		CreatedAt: r.CreatedAt,
+	}
+	if r.BatchKey != nil {
+		op.BatchKey = *r.BatchKey
+	}
+	return op, nil
+}
+
+type revokedTokenRow struct {
+	RoomID    string    `gorm:"column:room_id;primaryKey"`
+	TokenID   string    `gorm:"column:token_id;primaryKey"`
+	RevokedAt time.Time `gorm:"column:revoked_at"`
+}
+
+func (revokedTokenRow) TableName() string { return "revoked_tokens" }
+
+type shareRow struct {
+	RoomID    string    `gorm:"column:room_id;primaryKey"`
+	TokenID   string    `gorm:"column:token_id;primaryKey"`
+	Role      string    `gorm:"column:role"`
+	IssuedBy  string    `gorm:"column:issued_by"`
+	IssuedAt  time.Time `gorm:"column:issued_at"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+func (shareRow) TableName() string { return "share_ledger" }
+
+type joinCodeRow struct {
+	Code          string    `gorm:"column:code;primaryKey"`
+	RoomID        string    `gorm:"column:room_id"`
+	Role          string    `gorm:"column:role"`
+	Restrictions  []byte    `gorm:"column:restrictions"`
+	CreatedBy     string    `gorm:"column:created_by"`
+	CreatedAt     time.Time `gorm:"column:created_at"`
+	ExpiresAt     time.Time `gorm:"column:expires_at"`
+	TokenNotAfter time.Time `gorm:"column:token_not_after"`
+	MaxUses       int       `gorm:"column:max_uses"`
+	Uses          int       `gorm:"column:uses"`
+}
+
+func (joinCodeRow) TableName() string { return "join_codes" }
+
+type inviteRow struct {
+	RoomID         string     `gorm:"column:room_id;primaryKey"`
+	ID             string     `gorm:"column:id;primaryKey"`
+	Role           string     `gorm:"column:role"`
+	Restrictions   []byte     `gorm:"column:restrictions"`
+	CreatedBy      string     `gorm:"column:created_by"`
+	CreatedAt      time.Time  `gorm:"column:created_at"`
+	ExpiresAt      time.Time  `gorm:"column:expires_at"`
+	TokenNotAfter  time.Time  `gorm:"column:token_not_after"`
+	MaxRedemptions int        `gorm:"column:max_redemptions"`
+	Redemptions    int        `gorm:"column:redemptions"`
+	RevokedAt      *time.Time `gorm:"column:revoked_at"`
+}
+
+func (inviteRow) TableName() string { return "invites" }
+
+func (r inviteRow) toModel() model.Invite {
+	return model.Invite{
+		ID:             r.ID,
+		RoomID:         r.RoomID,
+		Role:           r.Role,
+		Restrictions:   cloneBytes(r.Restrictions),
+		CreatedBy:      r.CreatedBy,
+		CreatedAt:      r.CreatedAt,
+		ExpiresAt:      r.ExpiresAt,
+		TokenNotAfter:  r.TokenNotAfter,
+		MaxRedemptions: r.MaxRedemptions,
+		Redemptions:    r.Redemptions,
+		RevokedAt:      r.RevokedAt,
+	}
+}
+
+// isUniqueViolation detects a concurrent writer claiming the same primary key.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
 func cloneBytes(src []byte) []byte {
 	if src == nil {
 		return nil
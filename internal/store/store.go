@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/traweezy/tacticboard/internal/config"
 	"github.com/traweezy/tacticboard/internal/model"
@@ -14,10 +15,38 @@ import (
 type Store interface {
 	CreateRoom(ctx context.Context, room model.Room) (model.Room, error)
 	GetRoom(ctx context.Context, roomID string) (model.Room, error)
+	// DeleteRoom removes the room with its snapshots, operations and
+	// revocation list.
+	DeleteRoom(ctx context.Context, roomID string) error
+	UpdateRoomSettings(ctx context.Context, roomID string, settings model.RoomSettings) error
 	SaveSnapshot(ctx context.Context, snapshot model.Snapshot) error
 	LatestSnapshot(ctx context.Context, roomID string) (model.Snapshot, error)
 	AppendOperation(ctx context.Context, op model.Operation) (model.Operation, error)
 	OperationsSince(ctx context.Context, roomID string, sinceSeq int64, limit int) ([]model.Operation, error)
+	// RevokeToken adds a token to the room's revocation list. Revoking a
+	// token twice is not an error.
+	RevokeToken(ctx context.Context, revoked model.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, roomID, tokenID string) (bool, error)
+	// RecordShare appends a minted token to the room's share ledger.
+	RecordShare(ctx context.Context, grant model.ShareGrant) error
+	// ListShares returns the room's share ledger, oldest first.
+	ListShares(ctx context.Context, roomID string) ([]model.ShareGrant, error)
+	// CreateJoinCode stores a new join code. It returns ErrJoinCodeExists when
+	// the code is already taken.
+	CreateJoinCode(ctx context.Context, code model.JoinCode) error
+	// RedeemJoinCode consumes one use of the code and returns it. Codes that
+	// are unknown, expired at now or used up yield ErrJoinCodeNotFound.
+	RedeemJoinCode(ctx context.Context, code string, now time.Time) (model.JoinCode, error)
+	CreateInvite(ctx context.Context, invite model.Invite) error
+	// RedeemInvite counts one redemption of the invite and returns it.
+	// Invites that are unknown, revoked, expired at now or used up yield
+	// ErrInviteNotFound.
+	RedeemInvite(ctx context.Context, roomID, inviteID string, now time.Time) (model.Invite, error)
+	// ListInvites returns the room's invites, oldest first.
+	ListInvites(ctx context.Context, roomID string) ([]model.Invite, error)
+	// RevokeInvite stops further redemptions of the invite. Revoking an
+	// invite twice is not an error.
+	RevokeInvite(ctx context.Context, roomID, inviteID string, at time.Time) error
 }
 
 // Module registers the store implementation.
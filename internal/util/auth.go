@@ -2,8 +2,11 @@ package util
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -19,47 +22,215 @@ const (
 	RoleView CapabilityRole = "view"
 	// RoleEdit allows mutating operations within a room.
 	RoleEdit CapabilityRole = "edit"
+	// RoleOwner allows editing plus administering the room: deleting it,
+	// changing its settings, revoking tokens and minting edit shares.
+	RoleOwner CapabilityRole = "owner"
+)
+
+// Valid reports whether r is a known role.
+func (r CapabilityRole) Valid() bool {
+	return r.rank() > 0
+}
+
+// CanEdit reports whether r may submit board operations.
+func (r CapabilityRole) CanEdit() bool {
+	return r.rank() >= RoleEdit.rank()
+}
+
+// AtLeast reports whether r grants everything other grants.
+func (r CapabilityRole) AtLeast(other CapabilityRole) bool {
+	return r.Valid() && r.rank() >= other.rank()
+}
+
+func (r CapabilityRole) rank() int {
+	switch r {
+	case RoleView:
+		return 1
+	case RoleEdit:
+		return 2
+	case RoleOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Token format versions. Version 1 tokens are "payload.signature" with a
+// room|role|iat|exp[|jti] payload; they are still accepted but no longer
+// minted. Version 2 tokens are "v2.payload.signature" with a JSON payload.
+const (
+	TokenVersion1 = 1
+	TokenVersion2 = 2
+)
+
+const (
+	tokenV2Prefix         = "v2"
+	maxDisplayNameLength  = 64
+	maxRestrictionEntries = 256
 )
 
 // CapabilityClaims describes the room-scoped capability granted by a token.
 type CapabilityClaims struct {
+	// Version is the format the token was parsed from. It is ignored when
+	// generating; new tokens are always TokenVersion2. It is zero for claims
+	// mapped from external JWTs.
+	Version   int
 	RoomID    string
 	Role      CapabilityRole
 	IssuedAt  time.Time
 	ExpiresAt time.Time
+	// TokenID uniquely identifies the token so it can be revoked. Version 1
+	// tokens minted before token ids existed parse with an id derived from
+	// their signature.
+	TokenID string
+	// Subject optionally identifies who the token was issued to.
+	Subject string
+	// DisplayName is an optional human readable name for the holder.
+	DisplayName string
+	// KeyID names the signing key. It is empty for version 1 tokens.
+	KeyID string
+	// Restrictions optionally narrows what the role allows. Nil means the
+	// role applies without restriction.
+	Restrictions *Restrictions
+	// Issuer names the external identity provider for claims mapped from a
+	// JWT. It is empty for our own tokens and never serialized by them.
+	Issuer string
+}
+
+// Restrictions narrows a capability to a subset of the board. Empty lists
+// leave that dimension unrestricted.
+type Restrictions struct {
+	OpKinds   []string `json:"ops,omitempty"`
+	NodeKinds []string `json:"nodeKinds,omitempty"`
+	Layers    []string `json:"layers,omitempty"`
+	NodeIDs   []string `json:"nodes,omitempty"`
+}
+
+// Empty reports whether r restricts nothing.
+func (r *Restrictions) Empty() bool {
+	return r == nil || len(r.OpKinds)+len(r.NodeKinds)+len(r.Layers)+len(r.NodeIDs) == 0
+}
+
+// Equal reports whether r and other restrict the same things, ignoring order.
+func (r *Restrictions) Equal(other *Restrictions) bool {
+	if r.Empty() || other.Empty() {
+		return r.Empty() == other.Empty()
+	}
+	return sameSet(r.OpKinds, other.OpKinds) &&
+		sameSet(r.NodeKinds, other.NodeKinds) &&
+		sameSet(r.Layers, other.Layers) &&
+		sameSet(r.NodeIDs, other.NodeIDs)
+}
+
+func sameSet(a, b []string) bool {
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			return false
+		}
+	}
+	other := make(map[string]bool, len(b))
+	for _, v := range b {
+		other[v] = true
+	}
+	return len(seen) == len(other)
+}
+
+func (r *Restrictions) size() int {
+	if r == nil {
+		return 0
+	}
+	return max(len(r.OpKinds), len(r.NodeKinds), len(r.Layers), len(r.NodeIDs))
+}
+
+// tokenPayloadV2 is the JSON body of a version 2 token.
+type tokenPayloadV2 struct {
+	RoomID       string        `json:"rid"`
+	Role         string        `json:"role"`
+	IssuedAt     int64         `json:"iat"`
+	ExpiresAt    int64         `json:"exp"`
+	TokenID      string        `json:"jti"`
+	Subject      string        `json:"sub,omitempty"`
+	DisplayName  string        `json:"name,omitempty"`
+	KeyID        string        `json:"kid,omitempty"`
+	Restrictions *Restrictions `json:"rst,omitempty"`
 }
 
 var (
-	errMalformedToken = errors.New("malformed capability token")
-	errInvalidRole    = errors.New("invalid capability role")
+	errMalformedToken     = errors.New("malformed capability token")
+	errInvalidRole        = errors.New("invalid capability role")
+	errUnsupportedVersion = errors.New("unsupported capability token version")
 )
 
-// GenerateCapabilityToken creates a signed token embedding the provided claims.
+// GenerateCapabilityToken creates a signed version 2 token embedding the
+// provided claims. A random TokenID is assigned when claims do not carry one.
 func GenerateCapabilityToken(secret []byte, claims CapabilityClaims) (string, error) {
+	if claims.TokenID == "" {
+		id, err := NewTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims.TokenID = id
+	}
+
 	if err := validateClaims(claims); err != nil {
 		return "", err
 	}
 
-	payload := serializeClaims(claims)
+	payload, err := json.Marshal(tokenPayloadV2{
+		RoomID:       claims.RoomID,
+		Role:         string(claims.Role),
+		IssuedAt:     claims.IssuedAt.Unix(),
+		ExpiresAt:    claims.ExpiresAt.Unix(),
+		TokenID:      claims.TokenID,
+		Subject:      claims.Subject,
+		DisplayName:  claims.DisplayName,
+		KeyID:        claims.KeyID,
+		Restrictions: normalizeRestrictions(claims.Restrictions),
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode capability claims: %w", err)
+	}
+
 	signature := signPayload(secret, payload)
-	return encodeSegment(payload) + "." + encodeSegment(signature), nil
+	return tokenV2Prefix + "." + encodeSegment(payload) + "." + encodeSegment(signature), nil
 }
 
+// KeyLookup resolves the secret for a signing key id. Version 1 tokens and
+// version 2 tokens without a key id are looked up with an empty id.
+type KeyLookup func(keyID string) ([]byte, error)
+
 // ParseCapabilityToken verifies the signature and decodes the claims payload.
+// Both token versions are accepted.
 func ParseCapabilityToken(secret []byte, token string) (CapabilityClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 2 {
-		return CapabilityClaims{}, errMalformedToken
-	}
+	return ParseCapabilityTokenWithKeys(func(string) ([]byte, error) { return secret, nil }, token)
+}
 
-	payload, err := decodeSegment(parts[0])
+// ParseCapabilityTokenWithKeys is ParseCapabilityToken with the secret chosen
+// by the key id the token names.
+func ParseCapabilityTokenWithKeys(keys KeyLookup, token string) (CapabilityClaims, error) {
+	version, payload, signature, err := splitToken(token)
 	if err != nil {
-		return CapabilityClaims{}, errMalformedToken
+		return CapabilityClaims{}, err
 	}
 
-	signature, err := decodeSegment(parts[1])
+	keyID := ""
+	if version == TokenVersion2 {
+		var header struct {
+			KeyID string `json:"kid"`
+		}
+		if err := json.Unmarshal(payload, &header); err != nil {
+			return CapabilityClaims{}, errMalformedToken
+		}
+		keyID = header.KeyID
+	}
+
+	secret, err := keys(keyID)
 	if err != nil {
-		return CapabilityClaims{}, errMalformedToken
+		return CapabilityClaims{}, err
 	}
 
 	expected := signPayload(secret, payload)
@@ -67,7 +238,16 @@ func ParseCapabilityToken(secret []byte, token string) (CapabilityClaims, error)
 		return CapabilityClaims{}, errors.New("invalid capability signature")
 	}
 
-	claims, err := deserializeClaims(payload)
+	var claims CapabilityClaims
+	switch version {
+	case TokenVersion1:
+		claims, err = deserializeClaims(payload)
+		if err == nil && claims.TokenID == "" {
+			claims.TokenID = legacyTokenID(signature)
+		}
+	default:
+		claims, err = decodeClaimsV2(payload)
+	}
 	if err != nil {
 		return CapabilityClaims{}, err
 	}
@@ -83,14 +263,56 @@ func ParseCapabilityToken(secret []byte, token string) (CapabilityClaims, error)
 	return claims, nil
 }
 
+// NewTokenID returns a random identifier for a capability token.
+func NewTokenID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("generate token id: %w", err)
+	}
+	return encodeSegment(buf[:]), nil
+}
+
+// legacyTokenID names a version 1 token without a jti after its signature,
+// so the token can still be revoked and its sessions told apart. The
+// signature is hashed because, next to the public payload, it is the token.
+func legacyTokenID(signature []byte) string {
+	digest := sha256.Sum256(signature)
+	return "v1-" + hex.EncodeToString(digest[:16])
+}
+
+// splitToken decodes the segments of a token of either version.
+func splitToken(token string) (int, []byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	version := TokenVersion1
+	switch {
+	case len(parts) == 2:
+	case len(parts) == 3 && parts[0] == tokenV2Prefix:
+		version = TokenVersion2
+		parts = parts[1:]
+	case len(parts) == 3:
+		return 0, nil, nil, errUnsupportedVersion
+	default:
+		return 0, nil, nil, errMalformedToken
+	}
+
+	payload, err := decodeSegment(parts[0])
+	if err != nil {
+		return 0, nil, nil, errMalformedToken
+	}
+
+	signature, err := decodeSegment(parts[1])
+	if err != nil {
+		return 0, nil, nil, errMalformedToken
+	}
+	return version, payload, signature, nil
+}
+
 func validateClaims(claims CapabilityClaims) error {
 	if claims.RoomID == "" {
 		return errors.New("room id required")
 	}
 
-	switch claims.Role {
-	case RoleView, RoleEdit:
-	default:
+	if !claims.Role.Valid() {
 		return errInvalidRole
 	}
 
@@ -98,22 +320,50 @@ func validateClaims(claims CapabilityClaims) error {
 		return errors.New("expires before issued")
 	}
 
+	if len([]rune(claims.DisplayName)) > maxDisplayNameLength {
+		return errors.New("display name too long")
+	}
+
+	if claims.Restrictions.size() > maxRestrictionEntries {
+		return errors.New("too many restriction entries")
+	}
+
 	return nil
 }
 
-func serializeClaims(claims CapabilityClaims) []byte {
-	payload := fmt.Sprintf("%s|%s|%d|%d",
-		claims.RoomID,
-		string(claims.Role),
-		claims.IssuedAt.Unix(),
-		claims.ExpiresAt.Unix(),
-	)
-	return []byte(payload)
+func decodeClaimsV2(payload []byte) (CapabilityClaims, error) {
+	var body tokenPayloadV2
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return CapabilityClaims{}, errMalformedToken
+	}
+	return CapabilityClaims{
+		Version:      TokenVersion2,
+		RoomID:       body.RoomID,
+		Role:         CapabilityRole(body.Role),
+		IssuedAt:     time.Unix(body.IssuedAt, 0).UTC(),
+		ExpiresAt:    time.Unix(body.ExpiresAt, 0).UTC(),
+		TokenID:      body.TokenID,
+		Subject:      body.Subject,
+		DisplayName:  body.DisplayName,
+		KeyID:        body.KeyID,
+		Restrictions: normalizeRestrictions(body.Restrictions),
+	}, nil
+}
+
+// normalizeRestrictions drops restrictions that restrict nothing so they
+// round-trip as nil.
+func normalizeRestrictions(r *Restrictions) *Restrictions {
+	if r.Empty() {
+		return nil
+	}
+	return r
 }
 
+// deserializeClaims decodes a version 1 payload: room|role|iat|exp|jti or the
+// original room|role|iat|exp layout without a token id.
 func deserializeClaims(payload []byte) (CapabilityClaims, error) {
 	parts := strings.Split(string(payload), "|")
-	if len(parts) != 4 {
+	if len(parts) != 4 && len(parts) != 5 {
 		return CapabilityClaims{}, errMalformedToken
 	}
 
@@ -127,12 +377,17 @@ func deserializeClaims(payload []byte) (CapabilityClaims, error) {
 		return CapabilityClaims{}, errMalformedToken
 	}
 
-	return CapabilityClaims{
+	claims := CapabilityClaims{
+		Version:   TokenVersion1,
 		RoomID:    parts[0],
 		Role:      CapabilityRole(parts[1]),
 		IssuedAt:  issuedAt,
 		ExpiresAt: expiresAt,
-	}, nil
+	}
+	if len(parts) == 5 {
+		claims.TokenID = parts[4]
+	}
+	return claims, nil
 }
 
 func parseUnix(value string) (time.Time, error) {
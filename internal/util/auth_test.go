@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("0123456789abcdef")
+
+// legacyToken signs a version 1 payload the way tokens were minted before v2.
+func legacyToken(secret []byte, payload string) string {
+	return encodeSegment([]byte(payload)) + "." + encodeSegment(signPayload(secret, []byte(payload)))
+}
+
+func TestCapabilityTokenV2RoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	claims := CapabilityClaims{
+		RoomID:      "room-1",
+		Role:        RoleEdit,
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(time.Hour),
+		TokenID:     "tok-1",
+		Subject:     "user-42",
+		DisplayName: "Coach Kim",
+		KeyID:       "k1",
+		Restrictions: &Restrictions{
+			OpKinds: []string{"move", "patch"},
+			NodeIDs: []string{"n1", "n2"},
+		},
+	}
+
+	token, err := GenerateCapabilityToken(testSecret, claims)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, "v2."))
+
+	parsed, err := ParseCapabilityToken(testSecret, token)
+	require.NoError(t, err)
+	claims.Version = TokenVersion2
+	require.Equal(t, claims, parsed)
+}
+
+func TestCapabilityTokenV2Minimal(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	token, err := GenerateCapabilityToken(testSecret, CapabilityClaims{
+		RoomID:       "room-1",
+		Role:         RoleView,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(time.Hour),
+		Restrictions: &Restrictions{},
+	})
+	require.NoError(t, err)
+
+	parsed, err := ParseCapabilityToken(testSecret, token)
+	require.NoError(t, err)
+	require.NotEmpty(t, parsed.TokenID, "token id is assigned")
+	require.Empty(t, parsed.Subject)
+	require.Empty(t, parsed.DisplayName)
+	require.Nil(t, parsed.Restrictions, "empty restrictions round-trip as none")
+}
+
+func TestCapabilityTokenParsesVersion1(t *testing.T) {
+	now := time.Now().UTC()
+	iat, exp := now.Unix(), now.Add(time.Hour).Unix()
+
+	withoutID, err := ParseCapabilityToken(testSecret, legacyToken(testSecret, fmt.Sprintf("room-1|view|%d|%d", iat, exp)))
+	require.NoError(t, err)
+	require.Equal(t, TokenVersion1, withoutID.Version)
+	require.Equal(t, "room-1", withoutID.RoomID)
+	require.Equal(t, RoleView, withoutID.Role)
+	require.True(t, strings.HasPrefix(withoutID.TokenID, "v1-"), "an id is derived for tokens without one")
+
+	again, err := ParseCapabilityToken(testSecret, legacyToken(testSecret, fmt.Sprintf("room-1|view|%d|%d", iat, exp)))
+	require.NoError(t, err)
+	require.Equal(t, withoutID.TokenID, again.TokenID, "the derived id is stable")
+
+	other, err := ParseCapabilityToken(testSecret, legacyToken(testSecret, fmt.Sprintf("room-1|view|%d|%d", iat, exp+1)))
+	require.NoError(t, err)
+	require.NotEqual(t, withoutID.TokenID, other.TokenID)
+
+	withID, err := ParseCapabilityToken(testSecret, legacyToken(testSecret, fmt.Sprintf("room-1|edit|%d|%d|tok-9", iat, exp)))
+	require.NoError(t, err)
+	require.Equal(t, RoleEdit, withID.Role)
+	require.Equal(t, "tok-9", withID.TokenID)
+	require.Empty(t, withID.KeyID)
+}
+
+func TestCapabilityTokenRejects(t *testing.T) {
+	now := time.Now().UTC()
+	valid, err := GenerateCapabilityToken(testSecret, CapabilityClaims{
+		RoomID: "room-1", Role: RoleView, IssuedAt: now, ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	expired, err := GenerateCapabilityToken(testSecret, CapabilityClaims{
+		RoomID: "room-1", Role: RoleView, IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+
+	parts := strings.Split(valid, ".")
+	cases := map[string]string{
+		"wrong secret":  "",
+		"tampered":      parts[0] + "." + encodeSegment([]byte(`{"rid":"room-2","role":"owner"}`)) + "." + parts[2],
+		"unknown ver":   "v3." + parts[1] + "." + parts[2],
+		"malformed":     "not-a-token",
+		"expired":       expired,
+		"legacy role":   legacyToken(testSecret, fmt.Sprintf("room-1|admin|%d|%d", now.Unix(), now.Add(time.Hour).Unix())),
+		"bad signature": parts[0] + "." + parts[1] + "." + encodeSegment([]byte("nope")),
+	}
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			secret := testSecret
+			if token == "" {
+				token, secret = valid, []byte("another-secret-0000")
+			}
+			_, err := ParseCapabilityToken(secret, token)
+			require.Error(t, err)
+		})
+	}
+
+	_, err = GenerateCapabilityToken(testSecret, CapabilityClaims{
+		RoomID: "room-1", Role: RoleView, IssuedAt: now, ExpiresAt: now.Add(time.Hour),
+		DisplayName: strings.Repeat("x", maxDisplayNameLength+1),
+	})
+	require.Error(t, err)
+}
+
+func TestCapabilityRoleOrdering(t *testing.T) {
+	require.True(t, RoleOwner.AtLeast(RoleEdit))
+	require.True(t, RoleEdit.AtLeast(RoleView))
+	require.False(t, RoleView.AtLeast(RoleEdit))
+	require.False(t, CapabilityRole("admin").AtLeast(RoleView))
+	require.True(t, RoleOwner.CanEdit())
+	require.False(t, RoleView.CanEdit())
+}
@@ -3,9 +3,13 @@ package util
 import (
 	crand "crypto/rand"
 	"encoding/binary"
+	"fmt"
+	"math/big"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/oklog/ulid/v2"
 	"go.uber.org/fx"
@@ -49,3 +53,56 @@ func (g *IDGenerator) New() string {
 
 	return ulid.MustNew(ulid.Timestamp(time.Now()), g.entropy).String()
 }
+
+// joinCodeAlphabet leaves out characters that are easy to confuse when read
+// aloud or copied by hand: 0/O, 1/I/L.
+const joinCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// JoinCodeLength is the number of significant characters in a join code.
+const JoinCodeLength = 6
+
+// NewJoinCode returns a random join code in its normalized form.
+func NewJoinCode() (string, error) {
+	max := big.NewInt(int64(len(joinCodeAlphabet)))
+	code := make([]byte, JoinCodeLength)
+	for i := range code {
+		n, err := crand.Int(crand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("generate join code: %w", err)
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeJoinCode uppercases code and strips separators so "kq7-42m" and
+// "KQ7 42M" match the stored "KQ742M". It returns false when the result is
+// not a well-formed code.
+func NormalizeJoinCode(code string) (string, bool) {
+	normalized := make([]byte, 0, JoinCodeLength)
+	for _, r := range strings.ToUpper(code) {
+		switch {
+		case r == '-' || r == ' ':
+			continue
+		case r < utf8.RuneSelf && strings.IndexByte(joinCodeAlphabet, byte(r)) >= 0:
+			normalized = append(normalized, byte(r))
+		default:
+			return "", false
+		}
+		if len(normalized) > JoinCodeLength {
+			return "", false
+		}
+	}
+	if len(normalized) != JoinCodeLength {
+		return "", false
+	}
+	return string(normalized), true
+}
+
+// FormatJoinCode renders a normalized code for display, e.g. "KQ7-42M".
+func FormatJoinCode(code string) string {
+	if len(code) != JoinCodeLength {
+		return code
+	}
+	return code[:JoinCodeLength/2] + "-" + code[JoinCodeLength/2:]
+}
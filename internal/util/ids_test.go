@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinCodes(t *testing.T) {
+	code, err := NewJoinCode()
+	require.NoError(t, err)
+	require.Len(t, code, JoinCodeLength)
+
+	normalized, ok := NormalizeJoinCode(FormatJoinCode(code))
+	require.True(t, ok)
+	require.Equal(t, code, normalized)
+
+	normalized, ok = NormalizeJoinCode(" kq7-42m")
+	require.True(t, ok)
+	require.Equal(t, "KQ742M", normalized)
+	require.Equal(t, "KQ7-42M", FormatJoinCode(normalized))
+
+	for _, bad := range []string{"", "KQ7-42", "KQ7-42MM", "KQ0-42M", "KQ7-42É"} {
+		_, ok := NormalizeJoinCode(bad)
+		require.False(t, ok, bad)
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+const (
+	busKindSettings    = "settings"
+	busKindRoomDeleted = "room_deleted"
+)
+
+// ApplySettings pushes new room settings to the room's sessions on every
+// instance. The caller persists them first.
+func (h *Hub) ApplySettings(ctx context.Context, roomID string, settings model.RoomSettings) {
+	h.applySettings(roomID, settings)
+	h.publishControl(ctx, roomID, busKindSettings, settings)
+}
+
+// CloseRoom ends every session of a deleted room on every instance.
+func (h *Hub) CloseRoom(ctx context.Context, roomID string) {
+	h.closeRoom(roomID)
+	h.publishControl(ctx, roomID, busKindRoomDeleted, struct{}{})
+}
+
+func (h *Hub) applySettings(roomID string, settings model.RoomSettings) {
+	h.roomsMu.RLock()
+	state, ok := h.rooms[roomID]
+	h.roomsMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.readOnly.Store(settings.ReadOnly)
+	state.deliver(EncodeSettings(roomID, settings), nil)
+}
+
+func (h *Hub) closeRoom(roomID string) {
+	h.roomsMu.RLock()
+	state, ok := h.rooms[roomID]
+	h.roomsMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.mu.RLock()
+	clients := make([]*client, 0, len(state.clients))
+	for c := range state.clients {
+		clients = append(clients, c)
+	}
+	state.mu.RUnlock()
+
+	for _, c := range clients {
+		c.closeWith(CloseRoomDeleted, "room deleted")
+	}
+	state.log.Info("room deleted; sessions closed", zap.Int("clients", len(clients)))
+}
+
+// publishControl tells the other hub instances about an administrative change.
+func (h *Hub) publishControl(ctx context.Context, roomID, kind string, body any) {
+	if h.bus == nil {
+		return
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		h.log.Error("encode control message", zap.String("kind", kind), zap.Error(err))
+		return
+	}
+	if err := h.bus.Publish(ctx, bus.Message{
+		Origin:  h.instance,
+		RoomID:  roomID,
+		Kind:    kind,
+		Payload: payload,
+	}); err != nil {
+		h.log.Warn("publish control message", zap.String("room", roomID), zap.String("kind", kind), zap.Error(err))
+	}
+}
+
+// receiveControl applies a control message published by another instance.
+func (h *Hub) receiveControl(msg bus.Message) {
+	switch msg.Kind {
+	case busKindRevoke:
+		var payload revokePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.TokenID == "" {
+			h.log.Warn("malformed revoke message", zap.String("room", msg.RoomID), zap.Error(err))
+			return
+		}
+		h.kick(msg.RoomID, payload.TokenID)
+	case busKindSettings:
+		var settings model.RoomSettings
+		if err := json.Unmarshal(msg.Payload, &settings); err != nil {
+			h.log.Warn("malformed settings message", zap.String("room", msg.RoomID), zap.Error(err))
+			return
+		}
+		h.applySettings(msg.RoomID, settings)
+	case busKindRoomDeleted:
+		h.closeRoom(msg.RoomID)
+	case busKindMember:
+		h.receiveMember(msg)
+	case busKindRosterSync:
+		h.receiveRosterSync(msg)
+	case bus.KindResync:
+		h.recoverBus()
+	default:
+		h.log.Debug("ignore bus message", zap.String("kind", msg.Kind))
+	}
+}
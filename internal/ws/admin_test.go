@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestHubReadOnlyRoomRejectsOps(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-readonly"
+	seedRoom(t, st, room, 0)
+	srv := newTestServer(t, config.Config{}, st)
+
+	conn := srv.join(t, room, util.RoleEdit, 0)
+	readUntil(t, conn, TypeCaughtUp)
+
+	ctx := context.Background()
+	settings := model.RoomSettings{ReadOnly: true}
+	require.NoError(t, st.UpdateRoomSettings(ctx, room, settings))
+	srv.hub.ApplySettings(ctx, room, settings)
+	readUntil(t, conn, TypeSettings)
+
+	require.NoError(t, conn.WriteJSON(OpMessage{
+		Type:    TypeOp,
+		RoomID:  room,
+		BatchID: "b1",
+		Ops:     []json.RawMessage{json.RawMessage(`{"k":"add","node":{"id":"n1","kind":"cone","x":0,"y":0}}`)},
+	}))
+	messages := readUntil(t, conn, TypeNack)
+	var code string
+	require.NoError(t, json.Unmarshal(messages[len(messages)-1]["code"], &code))
+	require.Equal(t, ErrorReadOnly, code)
+
+	late := srv.join(t, room, util.RoleView, 0)
+	require.Contains(t, messageTypes(readUntil(t, late, TypeSettings)), TypeSettings)
+}
+
+func TestHubCloseRoomEndsSessions(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-deleted"
+	seedRoom(t, st, room, 0)
+	srv := newTestServer(t, config.Config{}, st)
+
+	conn := srv.join(t, room, util.RoleView, 0)
+	readUntil(t, conn, TypeCaughtUp)
+
+	srv.hub.CloseRoom(context.Background(), room)
+	code, _ := readClose(t, conn)
+	require.Equal(t, CloseRoomDeleted, code)
+}
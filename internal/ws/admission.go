@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+var (
+	// ErrRoomFull is returned by Admit when the room has no free connection slots.
+	ErrRoomFull = errors.New("room connection limit reached")
+	// ErrTooManyConnections is returned by Admit when the remote address holds
+	// too many sockets already.
+	ErrTooManyConnections = errors.New("too many connections from this address")
+	// ErrRoleFull is returned by Admit when the room has no free connection
+	// slots for the capability's role.
+	ErrRoleFull = errors.New("connection limit reached for this role")
+	// ErrDraining is returned by Admit while the hub shuts down.
+	ErrDraining = errors.New("server shutting down")
+)
+
+type roleKey struct {
+	roomID string
+	role   util.CapabilityRole
+}
+
+// admissions counts live sockets on this instance by room, remote address and
+// role within a room.
+type admissions struct {
+	mu    sync.Mutex
+	rooms map[string]int
+	ips   map[string]int
+	roles map[roleKey]int
+}
+
+// Capability is a token verified before the upgrade together with its claims.
+type Capability struct {
+	Token  string
+	Claims util.CapabilityClaims
+}
+
+// Admission holds the connection slots claimed for one socket and the
+// capability it was authenticated with. A nil Admission is unlimited and
+// expects the token in the hello message.
+type Admission struct {
+	hub    *Hub
+	roomID string
+	ip     string
+	role   util.CapabilityRole
+	cap    Capability
+
+	once sync.Once
+}
+
+// Admit claims a room, address and role slot for an authenticated capability
+// before the HTTP upgrade, so rejected clients get a plain HTTP error instead
+// of a socket. Owners can edit and therefore count against the editor limit.
+func (h *Hub) Admit(capability Capability, ip string) (*Admission, error) {
+	roomID := capability.Claims.RoomID
+
+	if h.isDraining() {
+		return nil, ErrDraining
+	}
+
+	a := &h.admissions
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rooms == nil {
+		a.rooms = make(map[string]int)
+		a.ips = make(map[string]int)
+		a.roles = make(map[roleKey]int)
+	}
+
+	if limit := h.cfg.WSMaxConnsPerRoom; limit > 0 && a.rooms[roomID] >= limit {
+		return nil, ErrRoomFull
+	}
+	if limit := h.cfg.WSMaxConnsPerIP; limit > 0 && a.ips[ip] >= limit {
+		return nil, ErrTooManyConnections
+	}
+	role, limit := h.roleSlot(capability.Claims.Role)
+	key := roleKey{roomID: roomID, role: role}
+	if limit > 0 && a.roles[key] >= limit {
+		return nil, ErrRoleFull
+	}
+	a.rooms[roomID]++
+	a.ips[ip]++
+	a.roles[key]++
+	return &Admission{hub: h, roomID: roomID, ip: ip, role: role, cap: capability}, nil
+}
+
+// Release returns every slot held by the admission. It is safe to call more
+// than once.
+func (adm *Admission) Release() {
+	if adm == nil {
+		return
+	}
+	adm.once.Do(func() {
+		a := &adm.hub.admissions
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		decrement(a.rooms, adm.roomID)
+		decrement(a.ips, adm.ip)
+		decrement(a.roles, roleKey{roomID: adm.roomID, role: adm.role})
+	})
+}
+
+// roleSlot returns the slot a capability role occupies and its limit. Owners
+// share the editors' slots.
+func (h *Hub) roleSlot(role util.CapabilityRole) (util.CapabilityRole, int) {
+	switch role {
+	case util.RoleOwner, util.RoleEdit:
+		return util.RoleEdit, h.cfg.WSMaxEditConnsPerRoom
+	case util.RoleView:
+		return util.RoleView, h.cfg.WSMaxViewConnsPerRoom
+	default:
+		return role, 0
+	}
+}
+
+func decrement[K comparable](counts map[K]int, key K) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestHubAdmitEnforcesRoomAndAddressLimits(t *testing.T) {
+	hub := &Hub{cfg: config.Config{WSMaxConnsPerRoom: 2, WSMaxConnsPerIP: 2}, log: zap.NewNop()}
+
+	first, err := hub.Admit(testCapability("room-1"), "10.0.0.1")
+	require.NoError(t, err)
+	_, err = hub.Admit(testCapability("room-1"), "10.0.0.2")
+	require.NoError(t, err)
+
+	_, err = hub.Admit(testCapability("room-1"), "10.0.0.3")
+	require.ErrorIs(t, err, ErrRoomFull)
+
+	_, err = hub.Admit(testCapability("room-2"), "10.0.0.1")
+	require.NoError(t, err)
+	_, err = hub.Admit(testCapability("room-3"), "10.0.0.1")
+	require.ErrorIs(t, err, ErrTooManyConnections)
+
+	first.Release()
+	first.Release()
+	_, err = hub.Admit(testCapability("room-1"), "10.0.0.3")
+	require.NoError(t, err, "released slots are reusable")
+	_, err = hub.Admit(testCapability("room-4"), "10.0.0.1")
+	require.NoError(t, err)
+
+	require.NoError(t, hub.Drain(context.Background()))
+	_, err = hub.Admit(testCapability("room-5"), "10.0.0.9")
+	require.ErrorIs(t, err, ErrDraining)
+}
+
+func TestHubAdmitLimitsRoles(t *testing.T) {
+	hub := &Hub{cfg: config.Config{WSMaxEditConnsPerRoom: 1, WSMaxViewConnsPerRoom: 1}, log: zap.NewNop()}
+
+	editor, err := hub.Admit(roleCapability("room-1", util.RoleEdit), "10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = hub.Admit(roleCapability("room-1", util.RoleEdit), "10.0.0.2")
+	require.ErrorIs(t, err, ErrRoleFull)
+	_, err = hub.Admit(roleCapability("room-1", util.RoleOwner), "10.0.0.2")
+	require.ErrorIs(t, err, ErrRoleFull, "owners count as editors")
+	_, err = hub.Admit(roleCapability("room-1", util.RoleView), "10.0.0.2")
+	require.NoError(t, err, "viewers have their own limit")
+	_, err = hub.Admit(roleCapability("room-2", util.RoleEdit), "10.0.0.2")
+	require.NoError(t, err, "limits are per room")
+
+	editor.Release()
+	_, err = hub.Admit(roleCapability("room-1", util.RoleOwner), "10.0.0.3")
+	require.NoError(t, err)
+}
+
+func testCapability(roomID string) Capability {
+	return roleCapability(roomID, util.RoleView)
+}
+
+func roleCapability(roomID string, role util.CapabilityRole) Capability {
+	return Capability{Token: "token-" + roomID, Claims: util.CapabilityClaims{RoomID: roomID, Role: role}}
+}
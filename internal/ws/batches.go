@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchIDLength       = 128
+	batchHistoryPerSession = 256
+	batchSessionRetention  = 10 * time.Minute
+)
+
+// batchLedger remembers recently committed client batch IDs per session so a
+// retransmitted batch is acknowledged again instead of being applied twice.
+type batchLedger struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionBatches
+}
+
+type sessionBatches struct {
+	seqs     map[string]int64
+	order    []string
+	lastSeen time.Time
+}
+
+// begin reserves batchID for session. When the batch was seen before it
+// returns dup=true and the committed seq, or 0 while the original is in flight.
+func (l *batchLedger) begin(session, batchID string, now time.Time) (seq int64, dup bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sessions == nil {
+		l.sessions = make(map[string]*sessionBatches)
+	}
+	l.pruneLocked(now)
+
+	entry, ok := l.sessions[session]
+	if !ok {
+		entry = &sessionBatches{seqs: make(map[string]int64)}
+		l.sessions[session] = entry
+	}
+	entry.lastSeen = now
+
+	if seq, ok := entry.seqs[batchID]; ok {
+		return seq, true
+	}
+
+	entry.seqs[batchID] = 0
+	entry.order = append(entry.order, batchID)
+	if len(entry.order) > batchHistoryPerSession {
+		evicted := entry.order[0]
+		entry.order = entry.order[1:]
+		delete(entry.seqs, evicted)
+	}
+	return 0, false
+}
+
+// commit records the seq a reserved batch was stored under.
+func (l *batchLedger) commit(session, batchID string, seq int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry, ok := l.sessions[session]; ok {
+		if _, reserved := entry.seqs[batchID]; reserved {
+			entry.seqs[batchID] = seq
+		}
+	}
+}
+
+// abort releases a reservation so the client may retry the batch.
+func (l *batchLedger) abort(session, batchID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.sessions[session]
+	if !ok {
+		return
+	}
+	if seq, reserved := entry.seqs[batchID]; !reserved || seq != 0 {
+		return
+	}
+
+	delete(entry.seqs, batchID)
+	for i, id := range entry.order {
+		if id == batchID {
+			entry.order = append(entry.order[:i], entry.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (l *batchLedger) pruneLocked(now time.Time) {
+	for session, entry := range l.sessions {
+		if now.Sub(entry.lastSeen) > batchSessionRetention {
+			delete(l.sessions, session)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestBatchLedgerLifecycle(t *testing.T) {
+	var ledger batchLedger
+	now := time.Now()
+
+	_, dup := ledger.begin("s1", "b1", now)
+	require.False(t, dup)
+
+	seq, dup := ledger.begin("s1", "b1", now)
+	require.True(t, dup)
+	require.Zero(t, seq)
+
+	ledger.commit("s1", "b1", 7)
+	seq, dup = ledger.begin("s1", "b1", now)
+	require.True(t, dup)
+	require.EqualValues(t, 7, seq)
+
+	_, dup = ledger.begin("s2", "b1", now)
+	require.False(t, dup, "batch ids are scoped per session")
+
+	_, dup = ledger.begin("s1", "b2", now)
+	require.False(t, dup)
+	ledger.abort("s1", "b2")
+	_, dup = ledger.begin("s1", "b2", now)
+	require.False(t, dup, "aborted batches may be retried")
+
+	_, dup = ledger.begin("s1", "b1", now.Add(batchSessionRetention+time.Second))
+	require.False(t, dup, "idle sessions are forgotten")
+}
+
+func TestClientHandleOpAcksAndDeduplicates(t *testing.T) {
+	st := newTestStore(t)
+	hub := newTestHub(withStore(st))
+	c := newTestClient(hub, "c1", withRole(util.RoleEdit), withSession("session-1"))
+	hub.getOrCreateRoom("room-1").clients[c] = struct{}{}
+
+	msg := &OpMessage{
+		Type:    TypeOp,
+		RoomID:  "room-1",
+		Seq:     1,
+		BatchID: "batch-1",
+		Ops:     []json.RawMessage{json.RawMessage(`{"k":"remove","id":"n1"}`)},
+	}
+
+	c.handleOp(context.Background(), msg)
+
+	var delta DeltaPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &delta))
+	require.Equal(t, TypeDelta, delta.Type)
+
+	var ack AckPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &ack))
+	require.Equal(t, TypeAck, ack.Type)
+	require.Equal(t, "batch-1", ack.BatchID)
+	require.EqualValues(t, 1, ack.Seq)
+
+	// The retransmission is acknowledged again without a second commit.
+	c.handleOp(context.Background(), msg)
+	ack = AckPayload{}
+	require.NoError(t, json.Unmarshal(<-c.send, &ack))
+	require.Equal(t, TypeAck, ack.Type)
+	require.EqualValues(t, 1, ack.Seq)
+
+	room, err := st.GetRoom(context.Background(), "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, room.CurrentSeq)
+
+	// Once the ledger is gone, as after an eviction or restart, the store
+	// still recognises the batch.
+	hub.getOrCreateRoom("room-1").batches = batchLedger{}
+	c.handleOp(context.Background(), msg)
+	ack = AckPayload{}
+	require.NoError(t, json.Unmarshal(<-c.send, &ack))
+	require.Equal(t, TypeAck, ack.Type)
+	require.EqualValues(t, 1, ack.Seq)
+
+	room, err = st.GetRoom(context.Background(), "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, room.CurrentSeq)
+
+	// Another session reusing the batch id commits its own batch.
+	other := newTestClient(hub, "c2", withRole(util.RoleEdit), withSession("session-2"))
+	other.handleOp(context.Background(), &OpMessage{
+		Type:    TypeOp,
+		RoomID:  "room-1",
+		Seq:     2,
+		BatchID: "batch-1",
+		Ops:     []json.RawMessage{json.RawMessage(`{"k":"remove","id":"n2"}`)},
+	})
+	<-c.send
+	ack = AckPayload{}
+	require.NoError(t, json.Unmarshal(<-other.send, &ack))
+	require.Equal(t, TypeAck, ack.Type)
+	require.EqualValues(t, 2, ack.Seq)
+
+	// A failing batch yields a nack tied to its id.
+	c.handleOp(context.Background(), &OpMessage{
+		Type:    TypeOp,
+		RoomID:  "room-1",
+		Seq:     5,
+		BatchID: "batch-2",
+		Ops:     []json.RawMessage{json.RawMessage(`{"k":"remove","id":"n1"}`)},
+	})
+	var nack NackPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &nack))
+	require.Equal(t, TypeNack, nack.Type)
+	require.Equal(t, "batch-2", nack.BatchID)
+	require.Equal(t, ErrorConflict, nack.Code)
+}
+
+func TestClientHandleOpStampsAuthor(t *testing.T) {
+	st := newTestStore(t)
+	hub := newTestHub(withStore(st))
+	c := newTestClient(hub, "c1", withRole(util.RoleEdit), withSession("session-1"))
+	c.token.set("token", util.CapabilityClaims{TokenID: "tok-1", DisplayName: "Coach Kim"})
+	hub.getOrCreateRoom("room-1").clients[c] = struct{}{}
+
+	before := time.Now()
+	c.handleOp(context.Background(), &OpMessage{
+		Type:   TypeOp,
+		RoomID: "room-1",
+		Seq:    1,
+		Ops:    []json.RawMessage{json.RawMessage(`{"k":"remove","id":"n1"}`)},
+	})
+
+	var delta DeltaPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &delta))
+	require.Equal(t, &model.Author{TokenID: "tok-1", Name: "Coach Kim"}, delta.Author)
+	require.GreaterOrEqual(t, delta.CommittedAt, before.UnixMilli())
+
+	ops, err := st.OperationsSince(context.Background(), "room-1", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, model.Author{TokenID: "tok-1", Name: "Coach Kim"}, ops[0].Author)
+	require.Equal(t, delta.CommittedAt, ops[0].CreatedAt.UnixMilli())
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func seedRoom(t *testing.T, st store.Store, roomID string, ops int) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := st.CreateRoom(ctx, model.Room{
+		ID:       roomID,
+		Snapshot: &model.Snapshot{RoomID: roomID, State: json.RawMessage(`{"nodes":[]}`)},
+	})
+	require.NoError(t, err)
+
+	for seq := int64(1); seq <= int64(ops); seq++ {
+		_, err := st.AppendOperation(ctx, model.Operation{
+			RoomID: roomID,
+			Seq:    seq,
+			Ops: []json.RawMessage{
+				json.RawMessage(fmt.Sprintf(`{"k":"add","node":{"id":"n%d","kind":"cone","x":0,"y":0}}`, seq)),
+			},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestCatchupReplaysInPagesAndMarksCaughtUp(t *testing.T) {
+	st := store.NewMemoryStore()
+	seedRoom(t, st, "room-1", 7)
+	srv := newTestServer(t, config.Config{CatchupPageSize: 3, CatchupMaxGap: 100}, st)
+
+	conn := srv.join(t, "room-1", util.RoleView, 2)
+	messages := readUntil(t, conn, TypeCaughtUp)
+
+	types := messageTypes(messages)
+	require.Equal(t, []string{"snapshot", "delta", "delta", "delta", "delta", "delta", "caught_up"}, filterTypes(types, "roster"))
+
+	var caughtUp CaughtUpPayload
+	raw, _ := json.Marshal(messages[len(messages)-1])
+	require.NoError(t, json.Unmarshal(raw, &caughtUp))
+	require.EqualValues(t, 7, caughtUp.Seq)
+}
+
+func TestCatchupFallsBackToSnapshotAboveGap(t *testing.T) {
+	st := store.NewMemoryStore()
+	seedRoom(t, st, "room-1", 12)
+	srv := newTestServer(t, config.Config{CatchupPageSize: 5, CatchupMaxGap: 10}, st)
+
+	conn := srv.join(t, "room-1", util.RoleView, 0)
+	messages := readUntil(t, conn, TypeCaughtUp)
+	require.Equal(t, []string{"snapshot", "caught_up"}, filterTypes(messageTypes(messages), "roster"))
+
+	var snapshot SnapshotPayload
+	raw, _ := json.Marshal(messages[len(messages)-2])
+	require.NoError(t, json.Unmarshal(raw, &snapshot))
+	require.EqualValues(t, 12, snapshot.Seq)
+	require.Contains(t, string(snapshot.State), `"n12"`)
+}
+
+func filterTypes(types []string, drop string) []string {
+	out := types[:0:0]
+	for _, typ := range types {
+		if typ != drop {
+			out = append(out, typ)
+		}
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+)
+
+const compactTimeout = 10 * time.Second
+
+// compactor folds committed operations into snapshots so that joining clients
+// replay only the tail of the op log instead of the whole session.
+type compactor struct {
+	store    store.Store
+	log      *zap.Logger
+	everyN   int
+	interval time.Duration
+	now      func() time.Time
+}
+
+// compactionState tracks per-room progress towards the next snapshot.
+type compactionState struct {
+	mu       sync.Mutex
+	pending  int
+	lastSave time.Time
+	running  bool
+}
+
+func newCompactor(st store.Store, log *zap.Logger, everyN int, interval time.Duration) *compactor {
+	return &compactor{
+		store:    st,
+		log:      log.Named("compactor"),
+		everyN:   everyN,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// record notes a committed batch and reports whether a snapshot is due. When
+// it returns true the caller owns the run and must call finish afterwards.
+func (c *compactor) record(state *compactionState) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.pending++
+	if state.running {
+		return false
+	}
+
+	due := c.everyN > 0 && state.pending >= c.everyN
+	if !due && c.interval > 0 && c.now().Sub(state.lastSave) >= c.interval {
+		due = true
+	}
+	if due {
+		state.running = true
+		state.pending = 0
+	}
+	return due
+}
+
+func (c *compactor) finish(state *compactionState, err error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.running = false
+	if err == nil {
+		state.lastSave = c.now()
+	}
+}
+
+// schedule runs a compaction in the background if the thresholds are met.
+func (c *compactor) schedule(roomID string, state *compactionState) {
+	if c == nil || !c.record(state) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), compactTimeout)
+		defer cancel()
+
+		err := c.compact(ctx, roomID)
+		if err != nil {
+			c.log.Warn("compact room", zap.String("room", roomID), zap.Error(err))
+		}
+		c.finish(state, err)
+	}()
+}
+
+// flush writes a final snapshot for a room with uncompacted operations,
+// unless a compaction is already running.
+func (c *compactor) flush(ctx context.Context, roomID string, state *compactionState) {
+	state.mu.Lock()
+	if state.running || state.pending == 0 {
+		state.mu.Unlock()
+		return
+	}
+	state.running = true
+	state.pending = 0
+	state.mu.Unlock()
+
+	err := c.compact(ctx, roomID)
+	if err != nil {
+		c.log.Warn("flush room", zap.String("room", roomID), zap.Error(err))
+	}
+	c.finish(state, err)
+}
+
+// idleHook snapshots rooms as soon as their last client leaves.
+func (c *compactor) idleHook(h *Hub) RoomHook {
+	return RoomHook{
+		OnIdle: func(ctx context.Context, roomID string) {
+			h.roomsMu.RLock()
+			state, ok := h.rooms[roomID]
+			h.roomsMu.RUnlock()
+			if ok {
+				c.flush(ctx, roomID, &state.compaction)
+			}
+		},
+	}
+}
+
+// compact replays operations committed after the latest snapshot and persists
+// the resulting board as a new snapshot at the head sequence.
+func (c *compactor) compact(ctx context.Context, roomID string) error {
+	snapshot, base, err := materialize(ctx, c.store, c.log, roomID)
+	if err != nil {
+		return err
+	}
+	if snapshot.Seq == base {
+		return nil
+	}
+
+	snapshot.CreatedAt = c.now().UTC()
+	if err := c.store.SaveSnapshot(ctx, snapshot); err != nil {
+		return err
+	}
+
+	c.log.Debug("room compacted",
+		zap.String("room", roomID),
+		zap.Int64("from", base),
+		zap.Int64("to", snapshot.Seq),
+	)
+	return nil
+}
+
+// Materialize returns the room's board at head, folding the op log onto the
+// latest stored snapshot.
+func (h *Hub) Materialize(ctx context.Context, roomID string) (model.Snapshot, error) {
+	snapshot, _, err := materialize(ctx, h.store, h.log, roomID)
+	return snapshot, err
+}
+
+// materialize folds the op log onto the latest stored snapshot and returns the
+// board at head together with the sequence of the snapshot it started from.
+func materialize(ctx context.Context, st store.Store, log *zap.Logger, roomID string) (model.Snapshot, int64, error) {
+	base, err := st.LatestSnapshot(ctx, roomID)
+	if err != nil && !errors.Is(err, model.ErrSnapshotNotFound) {
+		return model.Snapshot{}, 0, err
+	}
+
+	ops, err := st.OperationsSince(ctx, roomID, base.Seq, 0)
+	if err != nil {
+		return model.Snapshot{}, 0, err
+	}
+
+	state, err := board.DecodeState(base.State)
+	if err != nil {
+		return model.Snapshot{}, 0, err
+	}
+
+	skipped := 0
+	for _, op := range ops {
+		skipped += state.ApplyRaw(op.Ops)
+	}
+	if skipped > 0 {
+		log.Warn("skipped undecodable ops while materializing",
+			zap.String("room", roomID),
+			zap.Int("skipped", skipped),
+		)
+	}
+
+	body, err := state.Encode()
+	if err != nil {
+		return model.Snapshot{}, 0, err
+	}
+
+	head := base.Seq
+	if len(ops) > 0 {
+		head = ops[len(ops)-1].Seq
+	}
+
+	return model.Snapshot{
+		RoomID:    roomID,
+		Seq:       head,
+		State:     body,
+		CreatedAt: time.Now().UTC(),
+	}, base.Seq, nil
+}
@@ -0,0 +1,73 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+)
+
+func TestCompactorCompactWritesSnapshotAtHead(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	_, err := st.CreateRoom(ctx, model.Room{
+		ID:       "room-1",
+		Snapshot: &model.Snapshot{RoomID: "room-1", State: json.RawMessage(`{"nodes":[],"meta":{}}`)},
+	})
+	require.NoError(t, err)
+
+	for seq := int64(1); seq <= 3; seq++ {
+		_, err := st.AppendOperation(ctx, model.Operation{
+			RoomID: "room-1",
+			Seq:    seq,
+			Ops: []json.RawMessage{
+				json.RawMessage(fmt.Sprintf(`{"k":"add","node":{"id":"n%d","kind":"cone","x":%d,"y":0}}`, seq, seq)),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	c := newCompactor(st, zap.NewNop(), 2, time.Minute)
+	require.NoError(t, c.compact(ctx, "room-1"))
+
+	snapshot, err := st.LatestSnapshot(ctx, "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, snapshot.Seq)
+
+	var body struct {
+		Nodes []map[string]any `json:"nodes"`
+		Meta  map[string]any   `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(snapshot.State, &body))
+	require.Len(t, body.Nodes, 3)
+	require.NotNil(t, body.Meta)
+
+	room, err := st.GetRoom(ctx, "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, room.CurrentSeq)
+}
+
+func TestCompactorRecordThresholds(t *testing.T) {
+	now := time.Now()
+	c := newCompactor(store.NewMemoryStore(), zap.NewNop(), 3, time.Minute)
+	c.now = func() time.Time { return now }
+
+	state := &compactionState{lastSave: now}
+	require.False(t, c.record(state))
+	require.False(t, c.record(state))
+	require.True(t, c.record(state))
+
+	// A run in flight suppresses further scheduling.
+	require.False(t, c.record(state))
+	c.finish(state, nil)
+
+	now = now.Add(2 * time.Minute)
+	require.True(t, c.record(state))
+}
@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDrainTimeout    = 10 * time.Second
+	defaultDrainRetryAfter = time.Second
+)
+
+// Module provides the hub and ties its shutdown to the application lifecycle.
+var Module = fx.Module(
+	"ws",
+	fx.Provide(
+		fx.Annotate(NewHub, fx.ParamTags(``, ``, ``, ``, ``, ``, `group:"room_hooks"`)),
+	),
+	fx.Invoke(registerHub),
+)
+
+func registerHub(lc fx.Lifecycle, h *Hub) {
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			timeout := h.cfg.DrainTimeout()
+			if timeout <= 0 {
+				timeout = defaultDrainTimeout
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return h.Drain(ctx)
+		},
+	})
+}
+
+// RetryReason formats the close reason sent to clients during a drain. Clients
+// should reconnect after the given delay.
+func RetryReason(after time.Duration) string {
+	return fmt.Sprintf("server restarting; retry_after_ms=%d", after.Milliseconds())
+}
+
+// admit registers a freshly upgraded socket. It fails once the hub is draining.
+func (h *Hub) admit(conn *websocket.Conn) bool {
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	if h.draining {
+		return false
+	}
+	if h.conns == nil {
+		h.conns = make(map[*websocket.Conn]*client)
+	}
+	h.conns[conn] = nil
+	return true
+}
+
+// attach records the client owning conn after a successful hello.
+func (h *Hub) attach(conn *websocket.Conn, c *client) bool {
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.conns[conn] = c
+	return true
+}
+
+func (h *Hub) isDraining() bool {
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	return h.draining
+}
+
+func (h *Hub) release(conn *websocket.Conn) {
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	delete(h.conns, conn)
+	if h.draining && len(h.conns) == 0 && h.drained != nil {
+		close(h.drained)
+		h.drained = nil
+	}
+}
+
+// Drain stops accepting hellos and asks every connected client to reconnect
+// elsewhere. Clients get their pending messages followed by a CloseGoingAway
+// frame whose reason carries a retry hint. Drain returns once all sockets are
+// gone or ctx expires, in which case the remaining sockets are closed hard.
+// Rooms are compacted before returning, under a deadline of their own so that
+// an expired ctx does not cost the final snapshots.
+func (h *Hub) Drain(ctx context.Context) error {
+	h.connsMu.Lock()
+	if h.draining {
+		h.connsMu.Unlock()
+		return nil
+	}
+	h.draining = true
+	drained := make(chan struct{})
+	if len(h.conns) == 0 {
+		close(drained)
+	} else {
+		h.drained = drained
+	}
+	pending := make(map[*websocket.Conn]*client, len(h.conns))
+	for conn, c := range h.conns {
+		pending[conn] = c
+	}
+	h.connsMu.Unlock()
+
+	h.log.Info("draining websocket connections", zap.Int("connections", len(pending)))
+	for conn, c := range pending {
+		if c == nil {
+			h.goAway(conn)
+			continue
+		}
+		c.drain()
+	}
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		h.connsMu.Lock()
+		h.log.Warn("drain deadline exceeded; closing remaining connections", zap.Int("connections", len(h.conns)))
+		for conn := range h.conns {
+			_ = conn.Close()
+		}
+		h.connsMu.Unlock()
+	}
+
+	h.stopIdleTimers()
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compactTimeout)
+	defer cancel()
+	h.flushRooms(flushCtx)
+	return nil
+}
+
+// goAway closes a socket that never completed its hello.
+func (h *Hub) goAway(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, RetryReason(h.retryAfter()))
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait)); err != nil {
+		h.log.Debug("write close control", zap.Error(err))
+	}
+	_ = conn.Close()
+}
+
+// retryAfter spreads reconnects over twice the configured delay so that a
+// drained instance does not hand its whole load to a peer in one burst.
+func (h *Hub) retryAfter() time.Duration {
+	base := h.cfg.DrainRetryAfter()
+	if base <= 0 {
+		base = defaultDrainRetryAfter
+	}
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
+
+func (h *Hub) flushRooms(ctx context.Context) {
+	h.roomsMu.RLock()
+	rooms := make([]*roomState, 0, len(h.rooms))
+	for _, state := range h.rooms {
+		rooms = append(rooms, state)
+	}
+	h.roomsMu.RUnlock()
+
+	for _, state := range rooms {
+		h.compactor.flush(ctx, state.id, &state.compaction)
+	}
+}
+
+// drain asks the write loop to flush and close the connection.
+func (c *client) drain() {
+	select {
+	case c.drainCh <- struct{}{}:
+	default:
+	}
+}
+
+// flushPending writes whatever is still queued without blocking for more.
+func (c *client) flushPending() {
+	for {
+		select {
+		case payload, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if err := c.writeMessage(payload); err != nil {
+				c.log.Debug("flush pending", zap.Error(err))
+				return
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestHubDrainClosesClientsWithRetryHint(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-drain"
+	seedRoom(t, st, room, 3)
+	srv := newTestServer(t, config.Config{DrainRetryAfterMS: 200}, st)
+
+	conn := srv.join(t, room, util.RoleView, 0)
+	readUntil(t, conn, TypeCaughtUp)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, srv.hub.Drain(ctx))
+
+	code, reason := readClose(t, conn)
+	require.Equal(t, websocket.CloseGoingAway, code)
+	require.Contains(t, reason, "retry_after_ms=")
+
+	late := srv.join(t, room, util.RoleView, 0)
+	code, _ = readClose(t, late)
+	require.Equal(t, websocket.CloseGoingAway, code)
+}
+
+// readClose discards messages until the peer closes and returns the close
+// code and reason it sent.
+func readClose(t *testing.T, conn *websocket.Conn) (int, string) {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	code, reason := -1, ""
+	conn.SetCloseHandler(func(c int, text string) error {
+		code, reason = c, text
+		return nil
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			require.NotEqual(t, -1, code, "connection ended without close frame: %v", err)
+			return code, reason
+		}
+	}
+}
+
+// cancellableStore fails snapshot writes under a finished context, as a
+// database driver would.
+type cancellableStore struct {
+	store.Store
+}
+
+func (s cancellableStore) SaveSnapshot(ctx context.Context, snapshot model.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Store.SaveSnapshot(ctx, snapshot)
+}
+
+func TestHubDrainFlushesRoomsPastDeadline(t *testing.T) {
+	st := cancellableStore{Store: store.NewMemoryStore()}
+	const room = "room-drain"
+	seedRoom(t, st, room, 3)
+
+	hub := newTestHub(withStore(st))
+	hub.compactor = newCompactor(st, zap.NewNop(), 100, time.Hour)
+	hub.getOrCreateRoom(room).compaction.pending = 3
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	require.NoError(t, hub.Drain(ctx))
+
+	snapshot, err := st.LatestSnapshot(context.Background(), room)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, snapshot.Seq)
+}
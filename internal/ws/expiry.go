@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const defaultTokenWarning = time.Minute
+
+// tokenState tracks the capability a live session acts under so the session
+// ends when it lapses unless the client refreshes it in-band.
+type tokenState struct {
+	mu        sync.Mutex
+	token     string
+	id        string
+	name      string
+	expiresAt time.Time
+	warned    bool
+}
+
+func (t *tokenState) set(token string, claims util.CapabilityClaims) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	t.id = claims.TokenID
+	t.name = claims.DisplayName
+	t.expiresAt = claims.ExpiresAt
+	t.warned = false
+}
+
+// tokenID returns the id of the token the session currently acts under.
+func (t *tokenState) tokenID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.id
+}
+
+// author returns the identity the session's batches are committed under.
+func (t *tokenState) author() model.Author {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return model.Author{TokenID: t.id, Name: t.name}
+}
+
+// next reports how long until the next expiry event. ok is false for sessions
+// without an expiry.
+func (t *tokenState) next(now time.Time, warning time.Duration) (wait time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expiresAt.IsZero() {
+		return 0, false
+	}
+	at := t.expiresAt
+	if !t.warned {
+		at = at.Add(-warning)
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// check advances the expiry state. It reports whether the warning is due and
+// whether the token has lapsed.
+func (t *tokenState) check(now time.Time, warning time.Duration) (warn bool, expired bool, expiresAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expiresAt.IsZero() {
+		return false, false, t.expiresAt
+	}
+	if !now.Before(t.expiresAt) {
+		return false, true, t.expiresAt
+	}
+	if !t.warned && !now.Before(t.expiresAt.Add(-warning)) {
+		t.warned = true
+		return true, false, t.expiresAt
+	}
+	return false, false, t.expiresAt
+}
+
+func (h *Hub) tokenWarning() time.Duration {
+	if warning := h.cfg.TokenWarning(); warning > 0 {
+		return warning
+	}
+	return defaultTokenWarning
+}
+
+// expiryTimer returns a channel that fires at the client's next expiry event,
+// or nil when the session does not expire.
+func (c *client) expiryTimer(timer *time.Timer) <-chan time.Time {
+	wait, ok := c.token.next(time.Now(), c.hub.tokenWarning())
+	if !ok {
+		timer.Stop()
+		return nil
+	}
+	timer.Reset(wait)
+	return timer.C
+}
+
+// checkExpiry warns the client ahead of expiry and reports whether the
+// session must end.
+func (c *client) checkExpiry(now time.Time) bool {
+	warn, expired, expiresAt := c.token.check(now, c.hub.tokenWarning())
+	if expired {
+		return true
+	}
+	if warn {
+		_ = c.queue(EncodeTokenStatus(TypeExpiring, c.roomID, expiresAt))
+	}
+	return false
+}
+
+// handleRefresh swaps the session's capability for a fresh token granting the
+// same room and role.
+func (c *client) handleRefresh(ctx context.Context, msg *RefreshMessage) {
+	claims, err := c.hub.verifyToken(ctx, msg.Token)
+	if err != nil {
+		c.log.Debug("reject refresh", zap.Error(err))
+		_ = c.queue(EncodeError(ErrorUnauthorized, "invalid capability token"))
+		return
+	}
+	if claims.RoomID != c.roomID || claims.Role != c.role || !newPermissionSet(claims.Restrictions).equal(c.perms) {
+		_ = c.queue(EncodeError(ErrorUnauthorized, "refresh token must grant the same room, role and permissions"))
+		return
+	}
+
+	c.token.set(msg.Token, claims)
+	select {
+	case c.refreshCh <- struct{}{}:
+	default:
+	}
+	_ = c.queue(EncodeTokenStatus(TypeRefreshed, c.roomID, claims.ExpiresAt))
+	c.log.Debug("capability refreshed", zap.Time("expires_at", claims.ExpiresAt))
+}
+
+// verifyToken checks a capability token presented over the socket.
+func (h *Hub) verifyToken(ctx context.Context, token string) (util.CapabilityClaims, error) {
+	return h.verifier.Verify(ctx, token)
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestTokenStateWarnsThenExpires(t *testing.T) {
+	var st tokenState
+	now := time.Now()
+	st.set("t1", util.CapabilityClaims{ExpiresAt: now.Add(10 * time.Minute)})
+
+	wait, ok := st.next(now, time.Minute)
+	require.True(t, ok)
+	require.Equal(t, 9*time.Minute, wait)
+
+	warn, expired, _ := st.check(now.Add(8*time.Minute), time.Minute)
+	require.False(t, warn)
+	require.False(t, expired)
+
+	warn, expired, _ = st.check(now.Add(9*time.Minute), time.Minute)
+	require.True(t, warn)
+	require.False(t, expired)
+	wait, _ = st.next(now.Add(9*time.Minute), time.Minute)
+	require.Equal(t, time.Minute, wait, "after the warning the next event is expiry")
+
+	warn, _, _ = st.check(now.Add(9*time.Minute+time.Second), time.Minute)
+	require.False(t, warn, "the warning is sent once")
+
+	_, expired, _ = st.check(now.Add(10*time.Minute), time.Minute)
+	require.True(t, expired)
+
+	st.set("t2", util.CapabilityClaims{ExpiresAt: now.Add(time.Hour)})
+	_, expired, _ = st.check(now.Add(10*time.Minute), time.Minute)
+	require.False(t, expired, "a refresh extends the session")
+
+	var open tokenState
+	_, ok = open.next(now, time.Minute)
+	require.False(t, ok)
+}
+
+func shortToken(t *testing.T, roomID string, role util.CapabilityRole, ttl time.Duration) string {
+	t.Helper()
+	now := time.Now()
+	token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      role,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func TestSessionRefreshAndExpiry(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-expiry"
+	seedRoom(t, st, room, 0)
+	srv := newTestServer(t, config.Config{}, st)
+
+	dial := func(token string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(srv.url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.WriteJSON(HelloMessage{Type: TypeHello, RoomID: room, Role: string(util.RoleView), Token: token}))
+		return conn
+	}
+
+	// The default warning window exceeds the token lifetime, so both
+	// sessions are warned right away.
+	refreshed := dial(shortToken(t, room, util.RoleView, 2*time.Second))
+	readUntil(t, refreshed, TypeExpiring)
+	require.NoError(t, refreshed.WriteJSON(RefreshMessage{Type: TypeRefresh, Token: shortToken(t, room, util.RoleEdit, time.Hour)}))
+	msgs := readUntil(t, refreshed, TypeError)
+	var code string
+	require.NoError(t, json.Unmarshal(msgs[len(msgs)-1]["code"], &code))
+	require.Equal(t, ErrorUnauthorized, code, "refresh may not change the role")
+
+	require.NoError(t, refreshed.WriteJSON(RefreshMessage{Type: TypeRefresh, Token: shortToken(t, room, util.RoleView, time.Hour)}))
+	readUntil(t, refreshed, TypeRefreshed)
+
+	lapsed := dial(shortToken(t, room, util.RoleView, 2*time.Second))
+	readUntil(t, lapsed, TypeExpiring)
+	closeCode, _ := readClose(t, lapsed)
+	require.Equal(t, CloseTokenExpired, closeCode)
+
+	require.NoError(t, refreshed.WriteJSON(PingMessage{Type: TypePing, TS: 1}))
+	readUntil(t, refreshed, TypePong)
+}
@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+// testHubOption configures a hub built by newTestHub.
+type testHubOption func(*Hub)
+
+func withConfig(cfg config.Config) testHubOption {
+	return func(h *Hub) { h.cfg = cfg }
+}
+
+func withStore(st store.Store) testHubOption {
+	return func(h *Hub) { h.store = st }
+}
+
+func withIdleGrace(grace time.Duration) testHubOption {
+	return func(h *Hub) { h.idleGrace = grace }
+}
+
+func withHooks(hooks ...RoomHook) testHubOption {
+	return func(h *Hub) { h.hooks = hooks }
+}
+
+// withBus joins the hub to shared as an instance of its own.
+func withBus(shared bus.Bus) testHubOption {
+	return func(h *Hub) {
+		h.bus = shared
+		h.instance = uuid.NewString()
+		shared.Subscribe(h.receive)
+	}
+}
+
+// newTestHub returns a hub without metrics or a compactor that logs nothing.
+func newTestHub(opts ...testHubOption) *Hub {
+	h := &Hub{
+		log:    zap.NewNop(),
+		tracer: trace.NewNoopTracerProvider().Tracer("test"),
+		rooms:  make(map[string]*roomState),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// testClientOption configures a client built by newTestClient.
+type testClientOption func(*client)
+
+func withRole(role util.CapabilityRole) testClientOption {
+	return func(c *client) { c.role = role }
+}
+
+func withSession(session string) testClientOption {
+	return func(c *client) { c.session = session }
+}
+
+func withLimiter(limiter *rate.Limiter) testClientOption {
+	return func(c *client) { c.limiter = limiter }
+}
+
+// newTestClient returns a viewer of room-1 that is not attached to a socket.
+// Its session defaults to id.
+func newTestClient(hub *Hub, id string, opts ...testClientOption) *client {
+	c := &client{
+		hub:     hub,
+		id:      id,
+		session: id,
+		roomID:  "room-1",
+		role:    util.RoleView,
+		send:    make(chan []byte, 16),
+		log:     zap.NewNop(),
+		stopCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// newTestStore returns a memory store holding an empty room-1.
+func newTestStore(t *testing.T) store.Store {
+	t.Helper()
+	st := store.NewMemoryStore()
+	_, err := st.CreateRoom(context.Background(), model.Room{ID: "room-1"})
+	require.NoError(t, err)
+	return st
+}
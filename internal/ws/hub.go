@@ -2,17 +2,23 @@ package ws
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
+	"github.com/traweezy/tacticboard/internal/auth"
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/bus"
 	"github.com/traweezy/tacticboard/internal/config"
 	"github.com/traweezy/tacticboard/internal/model"
 	"github.com/traweezy/tacticboard/internal/observability"
@@ -20,55 +26,129 @@ import (
 	"github.com/traweezy/tacticboard/internal/util"
 )
 
+const (
+	sendBufferSize         = 256
+	defaultCatchupPageSize = 200
+	defaultCatchupMaxGap   = 1000
+)
+
 var (
-	writeWait      = 10 * time.Second
-	pingInterval   = 20 * time.Second
-	pongWait       = 60 * time.Second
-	errClosedRoom  = errors.New("room closed")
-	errSendTimeout = errors.New("send timeout")
+	writeWait        = 10 * time.Second
+	helloWait        = 10 * time.Second
+	pingInterval     = 20 * time.Second
+	pongWait         = 60 * time.Second
+	errClosedRoom    = errors.New("room closed")
+	errSlowConsumer  = errors.New("slow consumer")
+	errResyncLimited = errors.New("too many resyncs")
 )
 
 // Hub orchestrates room fan-out and persistence.
 type Hub struct {
-	cfg    config.Config
-	store  store.Store
-	log    *zap.Logger
-	tracer trace.Tracer
-
-	metrics hubMetrics
+	cfg      config.Config
+	store    store.Store
+	bus      bus.Bus
+	verifier *auth.Verifier
+	instance string
+	log      *zap.Logger
+	tracer   trace.Tracer
+
+	metrics   hubMetrics
+	compactor *compactor
+	hooks     []RoomHook
+	idleGrace time.Duration
 
 	roomsMu sync.RWMutex
 	rooms   map[string]*roomState
+
+	admissions admissions
+
+	connsMu  sync.Mutex
+	conns    map[*websocket.Conn]*client
+	draining bool
+	drained  chan struct{}
 }
 
 type hubMetrics struct {
+	rooms       metric.Int64UpDownCounter
 	connections metric.Int64UpDownCounter
 	operations  metric.Int64Counter
+	rebases     metric.Int64Counter
+	dropped     metric.Int64Counter
+	resyncs     metric.Int64Counter
+	rateLimited metric.Int64Counter
 }
 
 type roomState struct {
-	id      string
-	log     *zap.Logger
-	clients map[*client]struct{}
-	mu      sync.RWMutex
+	id         string
+	hub        *Hub
+	origin     string
+	bus        bus.Bus
+	log        *zap.Logger
+	clients    map[*client]struct{}
+	mu         sync.RWMutex
+	compaction compactionState
+	batches    batchLedger
+	limiter    *rate.Limiter
+	readOnly   atomic.Bool
+	nodes      nodeIndex
+	// remote holds the members connected to other instances, by client id.
+	remote map[string]remoteMember
+	// lastSeq is the highest sequence delivered to the room's clients, or 0
+	// before the first delta.
+	lastSeq int64
+
+	// generation changes on every join so a pending eviction can tell that
+	// the room was used again during its grace period.
+	generation uint64
+	evicted    bool
+	// idleTimer evicts the room when its grace period ends; nil while the
+	// room has clients.
+	idleTimer *time.Timer
 }
 
 type client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	roomID string
-	role   util.CapabilityRole
-	since  int64
-	send   chan []byte
-	log    *zap.Logger
-	closed atomic.Bool
-	stopCh chan struct{}
+	hub     *Hub
+	conn    *websocket.Conn
+	id      string
+	session string
+	roomID  string
+	role    util.CapabilityRole
+	perms   *permissionSet
+	since   int64
+	send    chan []byte
+	log     *zap.Logger
+	closed  atomic.Bool
+	closing atomic.Bool
+	stopCh  chan struct{}
+
+	sendMu   sync.Mutex
+	desynced bool
+	held     [][]byte
+	resyncCh chan struct{}
+	resyncs  []time.Time
+	drainCh  chan struct{}
+
+	token     tokenState
+	refreshCh chan struct{}
+
+	limiter    *rate.Limiter
+	violations []time.Time
+
+	presence presenceState
 }
 
 // NewHub constructs an observable websocket hub.
-func NewHub(cfg config.Config, store store.Store, log *zap.Logger, telemetry *observability.Telemetry) *Hub {
+func NewHub(cfg config.Config, store store.Store, roomBus bus.Bus, verifier *auth.Verifier, log *zap.Logger, telemetry *observability.Telemetry, hooks []RoomHook) *Hub {
 	meter := telemetry.MeterProvider.Meter("github.com/traweezy/tacticboard/ws")
 
+	rooms, err := meter.Int64UpDownCounter(
+		"ws.rooms",
+		metric.WithDescription("Rooms held in hub memory"),
+	)
+	if err != nil {
+		log.Warn("ws metrics: failed to create room counter", zap.Error(err))
+	}
+
 	connections, err := meter.Int64UpDownCounter(
 		"ws.connections",
 		metric.WithDescription("Active WebSocket connections"),
@@ -85,32 +165,86 @@ func NewHub(cfg config.Config, store store.Store, log *zap.Logger, telemetry *ob
 		log.Warn("ws metrics: failed to create operation counter", zap.Error(err))
 	}
 
-	return &Hub{
-		cfg:    cfg,
-		store:  store,
-		log:    log.Named("ws_hub"),
-		tracer: telemetry.TracerProvider.Tracer("github.com/traweezy/tacticboard/ws"),
+	rebases, err := meter.Int64Counter(
+		"ws.rebased_batches",
+		metric.WithDescription("Stale op batches rebased onto the room head"),
+	)
+	if err != nil {
+		log.Warn("ws metrics: failed to create rebase counter", zap.Error(err))
+	}
+
+	dropped, err := meter.Int64Counter(
+		"ws.dropped_messages",
+		metric.WithDescription("Messages discarded from slow consumer send buffers"),
+	)
+	if err != nil {
+		log.Warn("ws metrics: failed to create dropped counter", zap.Error(err))
+	}
+
+	resyncs, err := meter.Int64Counter(
+		"ws.resyncs",
+		metric.WithDescription("Slow consumers resynchronized with a fresh snapshot"),
+	)
+	if err != nil {
+		log.Warn("ws metrics: failed to create resync counter", zap.Error(err))
+	}
+
+	rateLimited, err := meter.Int64Counter(
+		"ws.rate_limited_batches",
+		metric.WithDescription("Op batches refused by connection or room rate limits"),
+	)
+	if err != nil {
+		log.Warn("ws metrics: failed to create rate limit counter", zap.Error(err))
+	}
+
+	h := &Hub{
+		cfg:      cfg,
+		store:    store,
+		bus:      roomBus,
+		verifier: verifier,
+		instance: uuid.NewString(),
+		log:      log.Named("ws_hub"),
+		tracer:   telemetry.TracerProvider.Tracer("github.com/traweezy/tacticboard/ws"),
 		metrics: hubMetrics{
+			rooms:       rooms,
 			connections: connections,
 			operations:  ops,
+			rebases:     rebases,
+			dropped:     dropped,
+			resyncs:     resyncs,
+			rateLimited: rateLimited,
 		},
-		rooms: make(map[string]*roomState),
+		compactor: newCompactor(store, log.Named("ws_hub"), cfg.PersistEveryNOps, cfg.SnapshotInterval()),
+		rooms:     make(map[string]*roomState),
 	}
+	h.idleGrace = cfg.RoomIdleGrace()
+	h.hooks = append([]RoomHook{h.compactor.idleHook(h)}, hooks...)
+	roomBus.Subscribe(h.receive)
+	return h
 }
 
-// HandleConnection performs the hello handshake and launches client loops.
-func (h *Hub) HandleConnection(ctx context.Context, conn *websocket.Conn) {
+// HandleConnection performs the hello handshake and launches client loops. The
+// admission obtained from Admit is released when the connection ends; a nil
+// admission is not subject to connection limits.
+func (h *Hub) HandleConnection(ctx context.Context, conn *websocket.Conn, adm *Admission) {
 	ctx, span := h.tracer.Start(ctx, "ws.HandleConnection", trace.WithSpanKind(trace.SpanKindServer))
 	defer span.End()
 
+	defer adm.Release()
 	defer func() {
 		if err := conn.Close(); err != nil {
 			h.log.Warn("close websocket", zap.Error(err))
 		}
 	}()
 
+	if !h.admit(conn) {
+		h.goAway(conn)
+		return
+	}
+	defer h.release(conn)
+
 	conn.SetReadLimit(h.cfg.WSReadLimit)
-	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetReadDeadline(time.Now().Add(helloWait))
 	conn.SetPongHandler(func(string) error {
 		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
@@ -128,12 +262,12 @@ func (h *Hub) HandleConnection(ctx context.Context, conn *websocket.Conn) {
 		return
 	}
 
-	if err := h.validateHello(envelope.Hello); err != nil {
+	if err := h.validateHello(envelope.Hello, adm != nil); err != nil {
 		h.writeError(conn, ErrorInvalid, err.Error())
 		return
 	}
 
-	claims, err := util.ParseCapabilityToken([]byte(h.cfg.JWTSecret), envelope.Hello.Token)
+	claims, err := h.authenticate(ctx, adm, envelope.Hello)
 	if err != nil {
 		h.writeError(conn, ErrorUnauthorized, "invalid capability token")
 		return
@@ -170,19 +304,48 @@ func (h *Hub) HandleConnection(ctx context.Context, conn *websocket.Conn) {
 		return
 	}
 
+	clientID := uuid.NewString()
+	session := envelope.Hello.Session
+	if session == "" {
+		session = clientID
+	}
+	// Scope the client-chosen session to the verified token so batch ids
+	// never match another client's.
+	session = claims.TokenID + "/" + session
 	client := &client{
-		hub:    h,
-		conn:   conn,
-		roomID: room.ID,
-		role:   role,
-		since:  envelope.Hello.Since,
-		send:   make(chan []byte, 256),
-		log:    h.log.With(zap.String("room", room.ID)),
-		stopCh: make(chan struct{}),
-	}
-
-	state := h.getOrCreateRoom(room.ID)
-	state.addClient(client)
+		hub:       h,
+		conn:      conn,
+		id:        clientID,
+		session:   session,
+		roomID:    room.ID,
+		role:      role,
+		perms:     newPermissionSet(claims.Restrictions),
+		since:     envelope.Hello.Since,
+		send:      make(chan []byte, sendBufferSize),
+		resyncCh:  make(chan struct{}, 1),
+		drainCh:   make(chan struct{}, 1),
+		refreshCh: make(chan struct{}, 1),
+		limiter:   newOpLimiter(h.cfg.WSOpRateRPS, h.cfg.WSOpRateBurst),
+		log:       h.log.With(zap.String("room", room.ID), zap.String("client", clientID)),
+		stopCh:    make(chan struct{}),
+	}
+
+	token := envelope.Hello.Token
+	if adm != nil {
+		token = adm.cap.Token
+	}
+	client.token.set(token, claims)
+
+	if !h.attach(conn, client) {
+		h.goAway(conn)
+		return
+	}
+
+	state := h.joinRoom(client, room.Settings)
+	state.sendPresence(client)
+	if state.readOnly.Load() {
+		_ = client.queue(EncodeSettings(room.ID, model.RoomSettings{ReadOnly: true}))
+	}
 	h.metrics.observeConnection(ctx, room.ID, +1)
 	defer state.removeClient(client)
 	defer h.metrics.observeConnection(ctx, room.ID, -1)
@@ -196,23 +359,56 @@ func (h *Hub) HandleConnection(ctx context.Context, conn *websocket.Conn) {
 	client.readLoop(ctx)
 }
 
+// sendInitialState brings a joining client up to date. It runs before the
+// write loop starts, so it writes to the socket directly and pages through the
+// op log instead of flooding the send buffer. When the client is further
+// behind than the configured gap it receives a snapshot materialized at head
+// instead of the replay. A caught_up message marks the switch to live deltas.
 func (h *Hub) sendInitialState(ctx context.Context, c *client, room model.Room) error {
 	ctx, span := h.tracer.Start(ctx, "ws.sendInitialState")
 	defer span.End()
 	span.SetAttributes(attribute.String("room.id", room.ID))
 
-	if room.Snapshot != nil {
-		if payload, err := EncodeSnapshot(room.ID, *room.Snapshot); err == nil {
-			if err := c.queue(payload); err != nil {
-				return err
-			}
-		} else {
-			h.log.Error("encode snapshot", zap.Error(err))
+	pageSize := h.cfg.CatchupPageSize
+	if pageSize <= 0 {
+		pageSize = defaultCatchupPageSize
+	}
+	maxGap := int64(h.cfg.CatchupMaxGap)
+	if maxGap <= 0 {
+		maxGap = defaultCatchupMaxGap
+	}
+
+	snapshot := room.Snapshot
+	replayFrom := c.since
+	if snapshot != nil && snapshot.Seq > replayFrom {
+		replayFrom = snapshot.Seq
+	}
+
+	mode := "replay"
+	if room.CurrentSeq-replayFrom > maxGap {
+		materialized, _, err := materialize(ctx, h.store, c.log, room.ID)
+		if err != nil {
+			return err
+		}
+		snapshot = &materialized
+		replayFrom = materialized.Seq
+		mode = "snapshot"
+	}
+	span.SetAttributes(attribute.String("catchup.mode", mode))
+
+	if snapshot != nil {
+		payload, err := EncodeSnapshot(room.ID, *snapshot)
+		if err != nil {
+			return err
+		}
+		if err := c.writeMessage(payload); err != nil {
+			return err
 		}
 	}
 
-	if room.CurrentSeq > c.since {
-		ops, err := h.store.OperationsSince(ctx, room.ID, c.since, 0)
+	last := replayFrom
+	for {
+		ops, err := h.store.OperationsSince(ctx, room.ID, last, pageSize)
 		if err != nil {
 			return err
 		}
@@ -221,103 +417,235 @@ func (h *Hub) sendInitialState(ctx context.Context, c *client, room model.Room)
 			if err != nil {
 				return err
 			}
-			if err := c.queue(payload); err != nil {
+			if err := c.writeMessage(payload); err != nil {
 				return err
 			}
+			last = op.Seq
+		}
+		if len(ops) < pageSize {
+			break
 		}
 	}
 
-	return nil
+	return c.writeMessage(EncodeCaughtUp(room.ID, last))
 }
 
-func (h *Hub) validateHello(msg *HelloMessage) error {
+// validateHello checks the hello shape. The token may be omitted when the
+// connection was authenticated before the upgrade.
+func (h *Hub) validateHello(msg *HelloMessage, preauthenticated bool) error {
 	if msg.RoomID == "" {
 		return errors.New("roomId required")
 	}
-	if msg.Token == "" {
+	if msg.Token == "" && !preauthenticated {
 		return errors.New("token required")
 	}
-	if msg.Role != string(util.RoleEdit) && msg.Role != string(util.RoleView) {
+	if !util.CapabilityRole(msg.Role).Valid() {
 		return errors.New("invalid capability role")
 	}
+	if len(msg.Session) > maxBatchIDLength {
+		return errors.New("session too long")
+	}
 	return nil
 }
 
+// authenticate returns the claims the connection acts under: the capability
+// verified before the upgrade, or the hello token for connections without an
+// admission. A hello token on a pre-authenticated connection must be the same
+// token.
+func (h *Hub) authenticate(ctx context.Context, adm *Admission, hello *HelloMessage) (util.CapabilityClaims, error) {
+	if adm == nil {
+		return h.verifyToken(ctx, hello.Token)
+	}
+	if hello.Token != "" && hello.Token != adm.cap.Token {
+		return util.CapabilityClaims{}, errors.New("hello token differs from connection token")
+	}
+	return adm.cap.Claims, nil
+}
+
 func (h *Hub) writeError(conn *websocket.Conn, code, msg string) {
+	h.writeClose(conn, code, websocket.ClosePolicyViolation, msg)
+}
+
+// writeClose sends an error message followed by a close frame with closeCode.
+func (h *Hub) writeClose(conn *websocket.Conn, code string, closeCode int, msg string) {
 	_ = conn.WriteMessage(websocket.TextMessage, EncodeError(code, msg))
-	if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, msg), time.Now().Add(writeWait)); err != nil {
+	if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, msg), time.Now().Add(writeWait)); err != nil {
 		h.log.Debug("write error control", zap.Error(err))
 	}
 }
 
 func (h *Hub) getOrCreateRoom(roomID string) *roomState {
+	state, _ := h.lookupRoom(roomID)
+	return state
+}
+
+func (h *Hub) lookupRoom(roomID string) (*roomState, bool) {
 	h.roomsMu.Lock()
 	defer h.roomsMu.Unlock()
 
 	state, ok := h.rooms[roomID]
 	if !ok {
 		state = &roomState{
-			id:      roomID,
-			log:     h.log.With(zap.String("room", roomID)),
-			clients: make(map[*client]struct{}),
+			id:         roomID,
+			hub:        h,
+			origin:     h.instance,
+			bus:        h.bus,
+			log:        h.log.With(zap.String("room", roomID)),
+			clients:    make(map[*client]struct{}),
+			remote:     make(map[string]remoteMember),
+			compaction: compactionState{lastSave: time.Now()},
+			limiter:    newOpLimiter(h.cfg.WSRoomOpRateRPS, h.cfg.WSRoomOpRateBurst),
 		}
 		h.rooms[roomID] = state
+		h.metrics.observeRooms(roomID, +1)
 	}
-	return state
+	return state, !ok
 }
 
-func (r *roomState) addClient(c *client) {
+// addClient registers c with the room. It returns false when the room state
+// has been evicted and the caller must look the room up again.
+func (r *roomState) addClient(c *client) bool {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	if r.evicted {
+		r.mu.Unlock()
+		return false
+	}
+	r.stopIdleTimerLocked()
 	r.clients[c] = struct{}{}
+	r.generation++
 	c.closed.Store(false)
 	r.log.Info("client joined", zap.Int("total_clients", len(r.clients)))
+	r.mu.Unlock()
+
+	r.announce(RosterJoin, c)
+	return true
 }
 
 func (r *roomState) removeClient(c *client) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	delete(r.clients, c)
 	if !c.closed.Swap(true) {
 		close(c.stopCh)
+		c.sendMu.Lock()
 		close(c.send)
+		c.sendMu.Unlock()
+	}
+	remaining := len(r.clients)
+	generation := r.generation
+	r.log.Info("client left", zap.Int("total_clients", remaining))
+	r.mu.Unlock()
+
+	r.announce(RosterLeave, c)
+	if remaining == 0 && r.hub != nil {
+		r.hub.roomIdle(r, generation)
+	}
+}
+
+// receive delivers messages published by other hub instances to local clients.
+func (h *Hub) receive(msg bus.Message) {
+	if msg.Origin == h.instance {
+		return
+	}
+
+	if msg.Kind != "" {
+		h.receiveControl(msg)
+		return
+	}
+
+	h.roomsMu.RLock()
+	state, ok := h.rooms[msg.RoomID]
+	h.roomsMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	var delta DeltaPayload
+	if err := json.Unmarshal(msg.Payload, &delta); err == nil && delta.Type == TypeDelta &&
+		state.observeDelta(delta.From, delta.To) {
+		state.log.Warn("bus delta gap; resyncing clients", zap.Int64("from", delta.From))
+		state.resyncClients()
+	}
+	state.deliver(msg.Payload, nil)
+}
+
+// observeDelta records the sequence range of a delta delivered to the room
+// and reports whether sequences before it never arrived.
+func (r *roomState) observeDelta(from, to int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	gap := r.lastSeq != 0 && from > r.lastSeq
+	if to > r.lastSeq {
+		r.lastSeq = to
 	}
-	r.log.Info("client left", zap.Int("total_clients", len(r.clients)))
+	return gap
 }
 
 func (r *roomState) broadcast(sender *client, payload []byte) {
+	r.deliver(payload, nil)
+	r.publish(payload)
+}
+
+// deliver queues payload for every local client except skip.
+func (r *roomState) deliver(payload []byte, skip *client) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	for client := range r.clients {
+		if client == skip {
+			continue
+		}
 		if err := client.queue(payload); err != nil {
 			client.log.Warn("drop message", zap.Error(err))
 		}
 	}
 }
 
+// publish forwards payload to the other hub instances serving this room.
+func (r *roomState) publish(payload []byte) {
+	if r.bus == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+
+	if err := r.bus.Publish(ctx, bus.Message{
+		Origin:  r.origin,
+		RoomID:  r.id,
+		Payload: payload,
+	}); err != nil {
+		r.log.Warn("publish to bus", zap.Error(err))
+	}
+}
+
+// queue hands payload to the write loop. When the send buffer is full the
+// client is marked desynced: queued messages are discarded, later ones are held
+// back, and the write loop replaces the lost deltas with a fresh snapshot.
 func (c *client) queue(payload []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
 	if c.closed.Load() {
 		return errClosedRoom
 	}
 
+	if c.desynced {
+		return c.holdLocked(payload)
+	}
+
 	select {
 	case c.send <- payload:
 		return nil
 	default:
-		select {
-		case <-c.send:
-			c.log.Debug("backpressure: dropped oldest message")
-		default:
-		}
-
-		select {
-		case c.send <- payload:
-			return nil
-		default:
-			return errSendTimeout
-		}
 	}
+
+	dropped := c.flushLocked()
+	c.hub.metrics.observeDropped(c.roomID, int64(dropped))
+	c.log.Warn("slow consumer: send buffer full, scheduling resync", zap.Int("dropped", dropped))
+
+	c.desyncLocked()
+	return c.holdLocked(payload)
 }
 
 func (c *client) readLoop(ctx context.Context) {
@@ -356,9 +684,15 @@ func (c *client) readLoop(ctx context.Context) {
 
 		switch {
 		case envelope.Op != nil:
-			c.handleOp(ctx, envelope.Op)
+			if c.allowBatch(envelope.Op, time.Now()) {
+				c.handleOp(ctx, envelope.Op)
+			}
 		case envelope.Ping != nil:
 			c.handlePing(envelope.Ping)
+		case envelope.Presence != nil:
+			c.handlePresence(envelope.Presence)
+		case envelope.Refresh != nil:
+			c.handleRefresh(ctx, envelope.Refresh)
 		default:
 			c.log.Debug("unexpected message type")
 		}
@@ -368,6 +702,9 @@ func (c *client) readLoop(ctx context.Context) {
 func (c *client) writeLoop() {
 	ticker := time.NewTicker(pingInterval)
 	defer ticker.Stop()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	expiry := c.expiryTimer(timer)
 
 	for {
 		select {
@@ -379,7 +716,27 @@ func (c *client) writeLoop() {
 				c.log.Warn("write payload", zap.Error(err))
 				return
 			}
-		case <-ticker.C:
+		case <-c.resyncCh:
+			if err := c.resync(); err != nil {
+				c.log.Warn("resync failed; closing slow consumer", zap.Error(err))
+				c.closeWith(CloseSlowConsumer, "slow consumer")
+				return
+			}
+		case <-c.refreshCh:
+			expiry = c.expiryTimer(timer)
+		case now := <-expiry:
+			if c.checkExpiry(now) {
+				c.log.Info("capability expired; closing session")
+				c.closeWith(CloseTokenExpired, "capability expired")
+				return
+			}
+			expiry = c.expiryTimer(timer)
+		case <-c.drainCh:
+			c.flushPending()
+			c.closeWith(websocket.CloseGoingAway, RetryReason(c.hub.retryAfter()))
+			return
+		case now := <-ticker.C:
+			c.expirePresence(now)
 			if err := c.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(writeWait)); err != nil {
 				c.log.Debug("ping control failed", zap.Error(err))
 				return
@@ -416,36 +773,116 @@ func (c *client) handleOp(ctx context.Context, msg *OpMessage) {
 		attribute.Int64("op.seq", msg.Seq),
 	)
 
-	if c.role != util.RoleEdit {
+	if !c.role.CanEdit() {
 		c.log.Warn("discard op from viewer")
-		_ = c.queue(EncodeError(ErrorUnauthorized, "edit capability required"))
+		c.rejectBatch(msg.BatchID, ErrorUnauthorized, "edit capability required")
 		return
 	}
 
 	if msg.RoomID != c.roomID {
 		c.log.Warn("operation room mismatch", zap.String("roomId", msg.RoomID))
-		_ = c.queue(EncodeError(ErrorInvalid, "room mismatch"))
+		c.rejectBatch(msg.BatchID, ErrorInvalid, "room mismatch")
 		return
 	}
 
-	if len(msg.Ops) == 0 {
+	if len(msg.BatchID) > maxBatchIDLength {
+		c.rejectBatch("", ErrorInvalid, "batchId too long")
 		return
 	}
 
-	op := model.Operation{
-		RoomID: c.roomID,
-		Seq:    msg.Seq,
-		Ops:    msg.Ops,
+	if len(msg.Ops) == 0 {
+		return
 	}
 
-	op, err := c.hub.store.AppendOperation(ctx, op)
+	ops, err := board.DecodeBatch(msg.Ops)
 	if err != nil {
-		if errors.Is(err, model.ErrSequenceConflict) {
-			_ = c.queue(EncodeError(ErrorConflict, "sequence conflict"))
+		c.log.Debug("reject invalid batch", zap.Error(err))
+		c.rejectBatch(msg.BatchID, ErrorInvalid, err.Error())
+		return
+	}
+
+	state := c.hub.getOrCreateRoom(c.roomID)
+	if state.readOnly.Load() {
+		c.rejectBatch(msg.BatchID, ErrorReadOnly, "room is read-only")
+		return
+	}
+
+	if c.perms != nil {
+		var denied *permissionError
+		err := state.nodes.authorize(ctx, c.hub, c.roomID, c.perms, ops)
+		switch {
+		case errors.As(err, &denied):
+			c.log.Debug("reject batch outside permissions", zap.Error(err))
+			c.rejectBatch(msg.BatchID, ErrorForbidden, err.Error())
+			return
+		case err != nil:
+			c.log.Error("check permissions", zap.Error(err))
+			c.rejectBatch(msg.BatchID, ErrorServer, "operation failed")
+			return
+		}
+	}
+
+	if msg.BatchID != "" {
+		seq, dup := state.batches.begin(c.session, msg.BatchID, time.Now())
+		if dup {
+			if seq > 0 {
+				_ = c.queue(EncodeAck(c.roomID, msg.BatchID, seq))
+			} else {
+				c.rejectBatch(msg.BatchID, ErrorConflict, "batch in flight")
+			}
 			return
 		}
-		c.log.Error("append operation", zap.Error(err))
-		_ = c.queue(EncodeError(ErrorServer, "operation failed"))
+	}
+
+	var batchKey string
+	if msg.BatchID != "" {
+		batchKey = c.session + "/" + msg.BatchID
+	}
+	var authorize func([]board.Op) error
+	if c.perms != nil {
+		authorize = func(rebased []board.Op) error {
+			return state.nodes.authorize(ctx, c.hub, c.roomID, c.perms, rebased)
+		}
+	}
+	op, rebased, err := c.hub.commitBatch(ctx, c.roomID, msg.Seq, ops, c.token.author(), batchKey, authorize)
+	if errors.Is(err, model.ErrDuplicateBatch) {
+		// The store already holds this batch, committed before the ledger
+		// was lost to an eviction, restart or another instance.
+		state.batches.commit(c.session, msg.BatchID, op.Seq)
+		_ = c.queue(EncodeAck(c.roomID, msg.BatchID, op.Seq))
+		return
+	}
+	if err != nil {
+		if msg.BatchID != "" {
+			state.batches.abort(c.session, msg.BatchID)
+		}
+		var denied *permissionError
+		switch {
+		case errors.Is(err, errBatchTooStale):
+			c.rejectBatch(msg.BatchID, ErrorConflict, "batch too stale; resync required")
+		case errors.Is(err, model.ErrSequenceConflict):
+			c.rejectBatch(msg.BatchID, ErrorConflict, "sequence conflict")
+		case errors.As(err, &denied):
+			c.log.Debug("reject rebased batch outside permissions", zap.Error(err))
+			c.rejectBatch(msg.BatchID, ErrorForbidden, err.Error())
+		default:
+			c.log.Error("append operation", zap.Error(err))
+			c.rejectBatch(msg.BatchID, ErrorServer, "operation failed")
+		}
+		return
+	}
+
+	if msg.BatchID != "" {
+		state.batches.commit(c.session, msg.BatchID, op.Seq)
+	}
+	if rebased {
+		c.hub.metrics.observeRebase(ctx, c.roomID)
+	}
+
+	if len(op.Ops) == 0 {
+		if msg.BatchID != "" {
+			_ = c.queue(EncodeAck(c.roomID, msg.BatchID, op.Seq))
+		}
 		return
 	}
 
@@ -457,8 +894,22 @@ func (c *client) handleOp(ctx context.Context, msg *OpMessage) {
 
 	c.hub.metrics.observeOperations(ctx, c.roomID, int64(len(op.Ops)))
 
-	state := c.hub.getOrCreateRoom(c.roomID)
+	state.observeDelta(op.Seq-1, op.Seq)
 	state.broadcast(c, payload)
+	if msg.BatchID != "" {
+		_ = c.queue(EncodeAck(c.roomID, msg.BatchID, op.Seq))
+	}
+	c.hub.compactor.schedule(c.roomID, &state.compaction)
+}
+
+// rejectBatch answers a failed batch with a nack when the client supplied a
+// batch id, or with a plain error for legacy clients.
+func (c *client) rejectBatch(batchID, code, msg string) {
+	if batchID == "" {
+		_ = c.queue(EncodeError(code, msg))
+		return
+	}
+	_ = c.queue(EncodeNack(c.roomID, batchID, code, msg))
 }
 
 func (m hubMetrics) observeConnection(ctx context.Context, roomID string, delta int64) {
@@ -474,3 +925,41 @@ func (m hubMetrics) observeOperations(ctx context.Context, roomID string, count
 	}
 	m.operations.Add(ctx, count, metric.WithAttributes(attribute.String("room.id", roomID)))
 }
+
+func (m hubMetrics) observeRebase(ctx context.Context, roomID string) {
+	if m.rebases == nil {
+		return
+	}
+	m.rebases.Add(ctx, 1, metric.WithAttributes(attribute.String("room.id", roomID)))
+}
+
+func (m hubMetrics) observeDropped(roomID string, count int64) {
+	if m.dropped == nil || count == 0 {
+		return
+	}
+	m.dropped.Add(context.Background(), count, metric.WithAttributes(attribute.String("room.id", roomID)))
+}
+
+func (m hubMetrics) observeResync(roomID string) {
+	if m.resyncs == nil {
+		return
+	}
+	m.resyncs.Add(context.Background(), 1, metric.WithAttributes(attribute.String("room.id", roomID)))
+}
+
+func (m hubMetrics) observeRooms(roomID string, delta int64) {
+	if m.rooms == nil {
+		return
+	}
+	m.rooms.Add(context.Background(), delta, metric.WithAttributes(attribute.String("room.id", roomID)))
+}
+
+func (m hubMetrics) observeRateLimited(roomID, scope string) {
+	if m.rateLimited == nil {
+		return
+	}
+	m.rateLimited.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("room.id", roomID),
+		attribute.String("limit.scope", scope),
+	))
+}
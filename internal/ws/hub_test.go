@@ -1,40 +1,92 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
-	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/util"
 )
 
-func TestClientQueueDropsOldest(t *testing.T) {
-	hub := &Hub{
-		log:     zap.NewNop(),
-		tracer:  trace.NewNoopTracerProvider().Tracer("test"),
-		metrics: hubMetrics{},
-	}
+func TestClientQueueOverflowMarksDesynced(t *testing.T) {
+	hub := newTestHub()
 	c := &client{
-		hub:  hub,
-		send: make(chan []byte, 2),
-		log:  zap.NewNop(),
+		hub:      hub,
+		send:     make(chan []byte, 4),
+		resyncCh: make(chan struct{}, 1),
+		log:      zap.NewNop(),
+	}
+
+	delta := func(seq int) []byte {
+		payload, err := json.Marshal(DeltaPayload{Type: TypeDelta, Room: "room-1", From: int64(seq - 1), To: int64(seq)})
+		require.NoError(t, err)
+		return payload
+	}
+
+	for seq := 1; seq <= 4; seq++ {
+		require.NoError(t, c.queue(delta(seq)))
 	}
+	require.NoError(t, c.queue(delta(5)))
+
+	require.True(t, c.desynced)
+	require.Len(t, c.send, 0, "queued deltas are flushed on overflow")
+	require.Len(t, c.resyncCh, 1, "a resync is scheduled")
+
+	require.NoError(t, c.queue(delta(6)))
+	require.Equal(t, [][]byte{delta(5), delta(6)}, c.held)
+
+	err := c.queue(delta(7))
+	require.ErrorIs(t, err, errSlowConsumer, "held messages are bounded by the buffer")
+}
+
+func TestClientQueueOverflowKeepsControlMessages(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	_, err := st.AppendOperation(ctx, model.Operation{
+		RoomID: "room-1",
+		Seq:    1,
+		Ops:    []json.RawMessage{json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":1,"y":2}}`)},
+	})
+	require.NoError(t, err)
+
+	c := newTestClient(newTestHub(withStore(st)), "c1")
+	c.send = make(chan []byte, 6)
+	c.resyncCh = make(chan struct{}, 1)
 
-	require.NoError(t, c.queue([]byte("a")))
-	require.NoError(t, c.queue([]byte("b")))
-	require.NoError(t, c.queue([]byte("c")))
+	delta, err := EncodeDelta(model.Operation{RoomID: "room-1", Seq: 1})
+	require.NoError(t, err)
+	roster, err := EncodeRoster(RosterPayload{Type: TypeRoster, RoomID: "room-1", Event: RosterJoin, ClientID: "c2"})
+	require.NoError(t, err)
+	settings := EncodeSettings("room-1", model.RoomSettings{ReadOnly: true})
 
-	first := <-c.send
-	second := <-c.send
+	for _, payload := range [][]byte{delta, roster, delta, settings, delta, delta} {
+		require.NoError(t, c.queue(payload))
+	}
+	require.NoError(t, c.queue(delta))
+	require.True(t, c.desynced)
+	require.Len(t, c.send, 2, "only deltas are flushed on overflow")
+
+	require.NoError(t, c.resync())
 
-	require.Equal(t, []byte("b"), first)
-	require.Equal(t, []byte("c"), second)
+	var types []string
+	for len(c.send) > 0 {
+		var msg struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(<-c.send, &msg))
+		types = append(types, msg.Type)
+	}
+	require.Equal(t, []string{TypeRoster, TypeSettings, TypeResync, TypeSnapshot, TypeDelta}, types)
 }
 
 func TestClientQueueClosed(t *testing.T) {
 	c := &client{
-		hub:  &Hub{tracer: trace.NewNoopTracerProvider().Tracer("test")},
+		hub:  newTestHub(),
 		send: make(chan []byte, 1),
 		log:  zap.NewNop(),
 	}
@@ -45,11 +97,7 @@ func TestClientQueueClosed(t *testing.T) {
 }
 
 func TestClientHandlePingEnqueuesPong(t *testing.T) {
-	hub := &Hub{
-		tracer:  trace.NewNoopTracerProvider().Tracer("test"),
-		metrics: hubMetrics{},
-		log:     zap.NewNop(),
-	}
+	hub := newTestHub()
 	c := &client{
 		hub:  hub,
 		send: make(chan []byte, 1),
@@ -65,3 +113,43 @@ func TestClientHandlePingEnqueuesPong(t *testing.T) {
 	require.Equal(t, TypePong, pong.Type)
 	require.EqualValues(t, 123, pong.TS)
 }
+
+func TestClientHandleOpRejectsInvalidBatch(t *testing.T) {
+	st := newTestStore(t)
+	c := newTestClient(newTestHub(withStore(st)), "c1", withRole(util.RoleEdit))
+
+	c.handleOp(context.Background(), &OpMessage{
+		Type:   TypeOp,
+		RoomID: "room-1",
+		Seq:    1,
+		Ops: []json.RawMessage{
+			json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":1,"y":1}}`),
+			json.RawMessage(`{"k":"move","id":"p1"}`),
+		},
+	})
+
+	var decoded ErrorPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &decoded))
+	require.Equal(t, ErrorInvalid, decoded.Code)
+	require.Equal(t, "op 1: move requires x and y", decoded.Msg)
+
+	room, err := st.GetRoom(context.Background(), "room-1")
+	require.NoError(t, err)
+	require.Zero(t, room.CurrentSeq)
+}
+
+func TestRoomBroadcastReachesOtherInstances(t *testing.T) {
+	shared := bus.NewMemoryBus()
+	local, remote := newTestHub(withBus(shared)), newTestHub(withBus(shared))
+
+	sender := newTestClient(local, "a")
+	peer := newTestClient(remote, "b")
+	local.getOrCreateRoom("room-1").clients[sender] = struct{}{}
+	remote.getOrCreateRoom("room-1").clients[peer] = struct{}{}
+
+	local.getOrCreateRoom("room-1").broadcast(sender, []byte(`{"type":"delta"}`))
+
+	require.Equal(t, []byte(`{"type":"delta"}`), <-sender.send)
+	require.Equal(t, []byte(`{"type":"delta"}`), <-peer.send)
+	require.Len(t, sender.send, 0)
+}
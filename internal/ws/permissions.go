@@ -0,0 +1,288 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+// permissionSet is the scoped permission carried by a capability's
+// restrictions. Empty dimensions are unrestricted. Nodes without a layer are
+// outside every layer restriction. A set bound to node ids only allows moving
+// and patching those nodes.
+type permissionSet struct {
+	source    *util.Restrictions
+	nodes     map[string]struct{}
+	ops       map[board.OpKind]struct{}
+	nodeKinds map[board.NodeKind]struct{}
+	layers    map[string]struct{}
+}
+
+// newPermissionSet returns nil when r grants the full role.
+func newPermissionSet(r *util.Restrictions) *permissionSet {
+	if r.Empty() {
+		return nil
+	}
+	p := &permissionSet{source: r}
+	if len(r.NodeIDs) > 0 {
+		p.nodes = make(map[string]struct{}, len(r.NodeIDs))
+		for _, id := range r.NodeIDs {
+			p.nodes[id] = struct{}{}
+		}
+	}
+	if len(r.OpKinds) > 0 {
+		p.ops = make(map[board.OpKind]struct{}, len(r.OpKinds))
+		for _, kind := range r.OpKinds {
+			p.ops[board.OpKind(kind)] = struct{}{}
+		}
+	}
+	if len(r.NodeKinds) > 0 {
+		p.nodeKinds = make(map[board.NodeKind]struct{}, len(r.NodeKinds))
+		for _, kind := range r.NodeKinds {
+			p.nodeKinds[board.NodeKind(kind)] = struct{}{}
+		}
+	}
+	if len(r.Layers) > 0 {
+		p.layers = make(map[string]struct{}, len(r.Layers))
+		for _, layer := range r.Layers {
+			p.layers[layer] = struct{}{}
+		}
+	}
+	return p
+}
+
+// equal reports whether p and other come from the same restrictions.
+func (p *permissionSet) equal(other *permissionSet) bool {
+	var a, b *util.Restrictions
+	if p != nil {
+		a = p.source
+	}
+	if other != nil {
+		b = other.source
+	}
+	return a.Equal(b)
+}
+
+// scoped reports whether checks depend on the nodes an op targets.
+func (p *permissionSet) scoped() bool {
+	return p.nodeKinds != nil || p.layers != nil
+}
+
+// nodeMeta is what permission checks need to know about a node.
+type nodeMeta struct {
+	kind  board.NodeKind
+	layer string
+}
+
+func metaOf(node board.Node) nodeMeta {
+	meta := nodeMeta{kind: node.Kind}
+	if node.Layer != nil {
+		meta.layer = *node.Layer
+	}
+	return meta
+}
+
+// permissionError names the op of a batch the capability does not allow.
+type permissionError struct {
+	index  int
+	reason string
+}
+
+func (e *permissionError) Error() string {
+	return fmt.Sprintf("op %d: %s", e.index, e.reason)
+}
+
+// authorize checks every op of a batch in order. lookup resolves nodes at
+// the room head; nodes added, patched or removed earlier in the batch are
+// tracked on top of it. Adding a node whose id is taken is refused whatever
+// the set is scoped to, since the add would overwrite that node.
+func (p *permissionSet) authorize(ops []board.Op, lookup func(id string) (nodeMeta, bool)) error {
+	pending := make(map[string]*nodeMeta)
+	target := func(id string) (nodeMeta, bool) {
+		if meta, ok := pending[id]; ok {
+			if meta == nil {
+				return nodeMeta{}, false
+			}
+			return *meta, true
+		}
+		return lookup(id)
+	}
+
+	for i, op := range ops {
+		if p.ops != nil {
+			if _, ok := p.ops[op.Kind]; !ok {
+				return &permissionError{index: i, reason: fmt.Sprintf("%s not permitted", op.Kind)}
+			}
+		}
+		if reason := p.deniesBound(op); reason != "" {
+			return &permissionError{index: i, reason: reason}
+		}
+
+		id := op.ID
+		if op.Kind == board.OpAdd {
+			id = op.Node.ID
+		}
+		current, exists := target(id)
+		if op.Kind == board.OpAdd && exists {
+			return &permissionError{index: i, reason: fmt.Sprintf("node %s already exists", id)}
+		}
+		if p.scoped() {
+			if !exists && op.Kind != board.OpAdd {
+				return &permissionError{index: i, reason: fmt.Sprintf("node %s not found", id)}
+			}
+			if exists {
+				if reason := p.denies(current); reason != "" {
+					return &permissionError{index: i, reason: reason}
+				}
+			}
+		}
+
+		switch op.Kind {
+		case board.OpAdd:
+			meta := metaOf(*op.Node)
+			if reason := p.denies(meta); reason != "" {
+				return &permissionError{index: i, reason: reason}
+			}
+			pending[id] = &meta
+		case board.OpPatch:
+			if op.Changes == nil || !exists {
+				continue
+			}
+			if op.Changes.Kind != nil {
+				current.kind = *op.Changes.Kind
+			}
+			if op.Changes.Layer != nil {
+				current.layer = *op.Changes.Layer
+			}
+			if reason := p.denies(current); reason != "" {
+				return &permissionError{index: i, reason: reason}
+			}
+			pending[id] = &current
+		case board.OpRemove:
+			pending[id] = nil
+		}
+	}
+	return nil
+}
+
+// deniesBound returns why op is outside the nodes the set is bound to, or "".
+func (p *permissionSet) deniesBound(op board.Op) string {
+	if p.nodes == nil {
+		return ""
+	}
+	if op.Kind != board.OpMove && op.Kind != board.OpPatch {
+		return fmt.Sprintf("%s not permitted for node-bound capability", op.Kind)
+	}
+	if _, ok := p.nodes[op.ID]; !ok {
+		return fmt.Sprintf("node %s not permitted", op.ID)
+	}
+	return ""
+}
+
+// denies returns why a node is outside the permission set, or "".
+func (p *permissionSet) denies(meta nodeMeta) string {
+	if p.nodeKinds != nil {
+		if _, ok := p.nodeKinds[meta.kind]; !ok {
+			return fmt.Sprintf("%s nodes not permitted", meta.kind)
+		}
+	}
+	if p.layers != nil {
+		if _, ok := p.layers[meta.layer]; !ok {
+			if meta.layer == "" {
+				return "nodes without a layer not permitted"
+			}
+			return fmt.Sprintf("layer %s not permitted", meta.layer)
+		}
+	}
+	return ""
+}
+
+// nodeIndex caches the kind and layer of every node in a room for permission
+// checks. It is built from the store on first use and then caught up with the
+// op log before each check, so commits from other instances are seen too.
+type nodeIndex struct {
+	mu    sync.Mutex
+	ready bool
+	seq   int64
+	nodes map[string]nodeMeta
+}
+
+// authorize checks ops against perms at the room head.
+func (n *nodeIndex) authorize(ctx context.Context, h *Hub, roomID string, perms *permissionSet, ops []board.Op) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.refresh(ctx, h, roomID); err != nil {
+		return err
+	}
+	return perms.authorize(ops, func(id string) (nodeMeta, bool) {
+		meta, ok := n.nodes[id]
+		return meta, ok
+	})
+}
+
+func (n *nodeIndex) refresh(ctx context.Context, h *Hub, roomID string) error {
+	if !n.ready {
+		snapshot, _, err := materialize(ctx, h.store, h.log, roomID)
+		if err != nil {
+			return fmt.Errorf("materialize room: %w", err)
+		}
+		state, err := board.DecodeState(snapshot.State)
+		if err != nil {
+			return err
+		}
+		n.nodes = make(map[string]nodeMeta)
+		for _, node := range state.Nodes() {
+			n.nodes[node.ID] = metaOf(node)
+		}
+		n.seq = snapshot.Seq
+		n.ready = true
+		return nil
+	}
+
+	ops, err := h.store.OperationsSince(ctx, roomID, n.seq, 0)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		for _, raw := range op.Ops {
+			var entry board.Op
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				h.log.Debug("skip undecodable op in node index", zap.String("room", roomID), zap.Error(err))
+				continue
+			}
+			n.apply(entry)
+		}
+		n.seq = op.Seq
+	}
+	return nil
+}
+
+func (n *nodeIndex) apply(op board.Op) {
+	switch op.Kind {
+	case board.OpAdd:
+		if op.Node != nil && op.Node.ID != "" {
+			n.nodes[op.Node.ID] = metaOf(*op.Node)
+		}
+	case board.OpPatch:
+		meta, ok := n.nodes[op.ID]
+		if !ok || op.Changes == nil {
+			return
+		}
+		if op.Changes.Kind != nil {
+			meta.kind = *op.Changes.Kind
+		}
+		if op.Changes.Layer != nil {
+			meta.layer = *op.Changes.Layer
+		}
+		n.nodes[op.ID] = meta
+	case board.OpRemove:
+		delete(n.nodes, op.ID)
+	}
+}
@@ -0,0 +1,167 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func layer(name string) *string { return &name }
+
+func TestPermissionSetAuthorize(t *testing.T) {
+	existing := map[string]nodeMeta{
+		"p1": {kind: board.KindPlayer},
+		"a1": {kind: board.KindArrow, layer: "attack"},
+		"a2": {kind: board.KindArrow, layer: "defense"},
+	}
+	lookup := func(id string) (nodeMeta, bool) {
+		meta, ok := existing[id]
+		return meta, ok
+	}
+	x, y := 1.0, 2.0
+	arrow, player := board.KindArrow, board.KindPlayer
+
+	drawing := newPermissionSet(&util.Restrictions{NodeKinds: []string{"arrow", "freehand"}})
+	require.NoError(t, drawing.authorize([]board.Op{
+		{Kind: board.OpAdd, Node: &board.Node{ID: "f1", Kind: board.KindFreehand}},
+		{Kind: board.OpMove, ID: "f1", X: &x, Y: &y},
+		{Kind: board.OpMove, ID: "a1", X: &x, Y: &y},
+	}, lookup))
+	require.ErrorContains(t, drawing.authorize([]board.Op{{Kind: board.OpMove, ID: "p1", X: &x, Y: &y}}, lookup), "op 0: player nodes not permitted")
+	require.ErrorContains(t, drawing.authorize([]board.Op{
+		{Kind: board.OpPatch, ID: "a1", Changes: &board.NodePatch{Kind: &player}},
+	}, lookup), "player nodes not permitted")
+	require.ErrorContains(t, drawing.authorize([]board.Op{
+		{Kind: board.OpRemove, ID: "a1"},
+		{Kind: board.OpMove, ID: "a1", X: &x, Y: &y},
+	}, lookup), "op 1: node a1 not found")
+
+	attack := newPermissionSet(&util.Restrictions{Layers: []string{"attack"}})
+	require.NoError(t, attack.authorize([]board.Op{{Kind: board.OpRemove, ID: "a1"}}, lookup))
+	require.ErrorContains(t, attack.authorize([]board.Op{{Kind: board.OpRemove, ID: "a2"}}, lookup), "layer defense not permitted")
+	require.ErrorContains(t, attack.authorize([]board.Op{{Kind: board.OpRemove, ID: "p1"}}, lookup), "nodes without a layer")
+	require.ErrorContains(t, attack.authorize([]board.Op{
+		{Kind: board.OpPatch, ID: "a1", Changes: &board.NodePatch{Layer: layer("defense")}},
+	}, lookup), "layer defense not permitted")
+	require.NoError(t, attack.authorize([]board.Op{
+		{Kind: board.OpAdd, Node: &board.Node{ID: "a3", Kind: arrow, Layer: layer("attack")}},
+	}, lookup))
+
+	addOnly := newPermissionSet(&util.Restrictions{OpKinds: []string{"add"}})
+	require.NoError(t, addOnly.authorize([]board.Op{{Kind: board.OpAdd, Node: &board.Node{ID: "c1", Kind: board.KindCone}}}, lookup))
+	require.ErrorContains(t, addOnly.authorize([]board.Op{{Kind: board.OpRemove, ID: "p1"}}, lookup), "remove not permitted")
+	require.ErrorContains(t, addOnly.authorize([]board.Op{
+		{Kind: board.OpAdd, Node: &board.Node{ID: "p1", Kind: board.KindPlayer, X: 9, Y: 9}},
+	}, lookup), "op 0: node p1 already exists")
+	require.ErrorContains(t, addOnly.authorize([]board.Op{
+		{Kind: board.OpAdd, Node: &board.Node{ID: "c1", Kind: board.KindCone}},
+		{Kind: board.OpAdd, Node: &board.Node{ID: "c1", Kind: board.KindCone}},
+	}, lookup), "op 1: node c1 already exists")
+
+	bound := newPermissionSet(&util.Restrictions{NodeIDs: []string{"p1"}})
+	require.NoError(t, bound.authorize([]board.Op{
+		{Kind: board.OpMove, ID: "p1", X: &x, Y: &y},
+		{Kind: board.OpPatch, ID: "p1", Changes: &board.NodePatch{X: &x}},
+	}, lookup))
+	require.ErrorContains(t, bound.authorize([]board.Op{{Kind: board.OpMove, ID: "a1", X: &x, Y: &y}}, lookup), "node a1 not permitted")
+	require.ErrorContains(t, bound.authorize([]board.Op{{Kind: board.OpRemove, ID: "p1"}}, lookup), "remove not permitted")
+	require.ErrorContains(t, bound.authorize([]board.Op{
+		{Kind: board.OpAdd, Node: &board.Node{ID: "p9", Kind: board.KindPlayer}},
+	}, lookup), "add not permitted")
+
+	require.Nil(t, newPermissionSet(nil))
+	require.Nil(t, newPermissionSet(&util.Restrictions{}))
+}
+
+func TestHubCommitBatchReauthorizesRebasedBatch(t *testing.T) {
+	st := newTestStore(t)
+	hub := newTestHub(withStore(st))
+	ctx := context.Background()
+
+	_, _, err := hub.commitBatch(ctx, "room-1", 1, decodeTestBatch(t,
+		`{"k":"add","node":{"id":"a1","kind":"arrow","x":0,"y":0,"layer":"attack"}}`,
+	), model.Author{}, "", nil)
+	require.NoError(t, err)
+
+	// An attack-only editor checked its move against seq 1, but meanwhile
+	// the arrow moved to the defense layer.
+	attack := newPermissionSet(&util.Restrictions{Layers: []string{"attack"}})
+	var index nodeIndex
+	authorize := func(ops []board.Op) error {
+		return index.authorize(ctx, hub, "room-1", attack, ops)
+	}
+	move := decodeTestBatch(t, `{"k":"move","id":"a1","x":5,"y":5}`)
+	require.NoError(t, authorize(move))
+
+	_, _, err = hub.commitBatch(ctx, "room-1", 2, decodeTestBatch(t,
+		`{"k":"patch","id":"a1","changes":{"layer":"defense"}}`,
+	), model.Author{}, "", nil)
+	require.NoError(t, err)
+
+	_, _, err = hub.commitBatch(ctx, "room-1", 2, move, model.Author{}, "", authorize)
+	var denied *permissionError
+	require.ErrorAs(t, err, &denied)
+	require.ErrorContains(t, err, "layer defense not permitted")
+
+	room, err := st.GetRoom(ctx, "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, room.CurrentSeq)
+}
+
+func TestHubEnforcesPermissions(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-perms"
+	seedRoom(t, st, room, 2)
+	srv := newTestServer(t, config.Config{}, st)
+
+	now := time.Now()
+	token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+		RoomID:       room,
+		Role:         util.RoleEdit,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(time.Hour),
+		Restrictions: &util.Restrictions{NodeKinds: []string{"arrow", "freehand"}},
+	})
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial(srv.url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(HelloMessage{Type: TypeHello, RoomID: room, Role: string(util.RoleEdit), Since: 2, Token: token}))
+	readUntil(t, conn, TypeCaughtUp)
+
+	send := func(batchID string, seq int64, op string) map[string]json.RawMessage {
+		require.NoError(t, conn.WriteJSON(OpMessage{
+			Type: TypeOp, RoomID: room, Seq: seq, BatchID: batchID,
+			Ops: []json.RawMessage{json.RawMessage(op)},
+		}))
+		messages := readUntil(t, conn, TypeAck)
+		return messages[len(messages)-1]
+	}
+
+	require.NoError(t, conn.WriteJSON(OpMessage{
+		Type: TypeOp, RoomID: room, Seq: 3, BatchID: "move-cone",
+		Ops: []json.RawMessage{json.RawMessage(`{"k":"move","id":"n1","x":5,"y":5}`)},
+	}))
+	nack := readUntil(t, conn, TypeNack)
+	var code string
+	require.NoError(t, json.Unmarshal(nack[len(nack)-1]["code"], &code))
+	require.Equal(t, ErrorForbidden, code)
+
+	ack := send("draw", 3, `{"k":"add","node":{"id":"a1","kind":"arrow","x":0,"y":0,"points":[0,0,10,10]}}`)
+	var seq int64
+	require.NoError(t, json.Unmarshal(ack["seq"], &seq))
+	require.Equal(t, int64(3), seq)
+
+	send("move-arrow", 4, `{"k":"move","id":"a1","x":3,"y":4}`)
+}
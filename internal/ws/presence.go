@@ -0,0 +1,361 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+)
+
+const (
+	maxPresenceName  = 64
+	maxPresenceColor = 32
+)
+
+// Bus kinds that keep rosters in sync across instances. A member message
+// reports a roster event about one client; a roster_sync message carries every
+// client connected to the sending instance and replaces what receivers knew
+// about it. Instances answer a remote join with a roster_sync so a newly
+// activated room learns about members elsewhere.
+const (
+	busKindMember     = "member"
+	busKindRosterSync = "roster_sync"
+)
+
+type memberPayload struct {
+	Event  string      `json:"event"`
+	Member RosterEntry `json:"member"`
+}
+
+type rosterSyncPayload struct {
+	Members []RosterEntry `json:"members"`
+}
+
+// remoteMember is a client connected to another instance.
+type remoteMember struct {
+	origin string
+	entry  RosterEntry
+}
+
+// presenceState is the ephemeral cursor owned by a client. It lives only in
+// hub memory and is never written to the store.
+type presenceState struct {
+	mu        sync.Mutex
+	active    bool
+	name      string
+	color     string
+	x         float64
+	y         float64
+	updatedAt time.Time
+	// pending is the latest update that arrived within the interval; it is
+	// applied when the interval ends so a burst settles on its last position.
+	pending *PresenceMessage
+}
+
+// update applies msg and reports true when the previous update was applied
+// at least interval ago. Otherwise msg replaces the pending update and update
+// returns how long until the interval ends, or 0 when an earlier pending
+// update already has a flush due.
+func (p *presenceState) update(msg *PresenceMessage, now time.Time, interval time.Duration) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active && interval > 0 && now.Sub(p.updatedAt) < interval {
+		scheduled := p.pending != nil
+		pending := *msg
+		p.pending = &pending
+		if scheduled {
+			return false, 0
+		}
+		return false, interval - now.Sub(p.updatedAt)
+	}
+
+	p.applyLocked(msg, now)
+	return true, 0
+}
+
+// flush applies the pending update, if any, and reports whether it did.
+func (p *presenceState) flush(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return false
+	}
+	p.applyLocked(p.pending, now)
+	return true
+}
+
+func (p *presenceState) applyLocked(msg *PresenceMessage, now time.Time) {
+	p.active = true
+	p.x = msg.X
+	p.y = msg.Y
+	if msg.Name != "" {
+		p.name = truncate(msg.Name, maxPresenceName)
+	}
+	if msg.Color != "" {
+		p.color = truncate(msg.Color, maxPresenceColor)
+	}
+	p.updatedAt = now
+	p.pending = nil
+}
+
+// expire deactivates the cursor when it has not moved within ttl.
+func (p *presenceState) expire(now time.Time, ttl time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.active || ttl <= 0 || now.Sub(p.updatedAt) < ttl {
+		return false
+	}
+	p.active = false
+	return true
+}
+
+func (p *presenceState) payload(roomID, clientID string) (PresencePayload, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PresencePayload{
+		RoomID:    roomID,
+		ClientID:  clientID,
+		Name:      p.name,
+		Color:     p.color,
+		X:         p.x,
+		Y:         p.y,
+		UpdatedAt: p.updatedAt.UnixMilli(),
+	}, p.active
+}
+
+func (p *presenceState) displayName() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.name
+}
+
+func (c *client) handlePresence(msg *PresenceMessage) {
+	applied, wait := c.presence.update(msg, time.Now(), c.hub.cfg.PresenceInterval())
+	if wait > 0 {
+		time.AfterFunc(wait, c.flushPresence)
+	}
+	if applied {
+		c.sendPresence()
+	}
+}
+
+// flushPresence sends the update held back by the presence interval.
+func (c *client) flushPresence() {
+	if c.closed.Load() || !c.presence.flush(time.Now()) {
+		return
+	}
+	c.sendPresence()
+}
+
+// sendPresence relays the client's cursor to the rest of the room.
+func (c *client) sendPresence() {
+	payload, _ := c.presence.payload(c.roomID, c.id)
+	encoded, err := EncodePresence(payload)
+	if err != nil {
+		c.log.Warn("encode presence", zap.Error(err))
+		return
+	}
+
+	state := c.hub.getOrCreateRoom(c.roomID)
+	state.fanout(encoded, c)
+}
+
+// expirePresence hides a cursor that has gone quiet and tells the room.
+func (c *client) expirePresence(now time.Time) {
+	if !c.presence.expire(now, c.hub.cfg.PresenceTTL()) {
+		return
+	}
+
+	state := c.hub.getOrCreateRoom(c.roomID)
+	state.announce(RosterExpire, c)
+}
+
+// fanout relays payload to every client in the room, local or remote,
+// except skip.
+func (r *roomState) fanout(payload []byte, skip *client) {
+	r.deliver(payload, skip)
+	r.publish(payload)
+}
+
+// announce broadcasts a roster event about a local subject to the room and
+// tells the other instances about it.
+func (r *roomState) announce(event string, subject *client) {
+	r.mu.RLock()
+	r.deliverRosterLocked(event, subject.id, subject)
+	r.mu.RUnlock()
+
+	if r.hub != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		defer cancel()
+		r.hub.publishControl(ctx, r.id, busKindMember, memberPayload{Event: event, Member: rosterEntry(subject)})
+	}
+}
+
+// deliverRosterLocked sends the roster event about clientID, with the merged
+// roster, to every local client. self, when local, additionally learns its
+// own id. Callers must hold r.mu.
+func (r *roomState) deliverRosterLocked(event, clientID string, self *client) {
+	roster := RosterPayload{
+		RoomID:   r.id,
+		Event:    event,
+		ClientID: clientID,
+		Clients:  r.rosterLocked(),
+	}
+	payload, err := EncodeRoster(roster)
+	if err != nil {
+		r.log.Warn("encode roster", zap.Error(err))
+		return
+	}
+
+	for client := range r.clients {
+		msg := payload
+		if client == self {
+			roster.Self = self.id
+			if encoded, err := EncodeRoster(roster); err == nil {
+				msg = encoded
+			}
+		}
+		if err := client.queue(msg); err != nil {
+			client.log.Debug("drop roster", zap.Error(err))
+		}
+	}
+}
+
+// sendPresence replays the live cursors of other clients to c.
+func (r *roomState) sendPresence(c *client) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for other := range r.clients {
+		if other == c {
+			continue
+		}
+		payload, active := other.presence.payload(r.id, other.id)
+		if !active {
+			continue
+		}
+		encoded, err := EncodePresence(payload)
+		if err != nil {
+			continue
+		}
+		if err := c.queue(encoded); err != nil {
+			return
+		}
+	}
+}
+
+// rosterLocked merges local clients with the members reported by other
+// instances. Callers must hold r.mu.
+func (r *roomState) rosterLocked() []RosterEntry {
+	entries := make([]RosterEntry, 0, len(r.clients)+len(r.remote))
+	for client := range r.clients {
+		entries = append(entries, rosterEntry(client))
+	}
+	for _, member := range r.remote {
+		entries = append(entries, member.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ClientID < entries[j].ClientID
+	})
+	return entries
+}
+
+func rosterEntry(c *client) RosterEntry {
+	return RosterEntry{
+		ClientID: c.id,
+		Role:     string(c.role),
+		Name:     c.presence.displayName(),
+	}
+}
+
+// localRosterLocked lists the clients connected to this instance. Callers
+// must hold r.mu.
+func (r *roomState) localRosterLocked() []RosterEntry {
+	entries := make([]RosterEntry, 0, len(r.clients))
+	for client := range r.clients {
+		entries = append(entries, rosterEntry(client))
+	}
+	return entries
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
+
+// receiveMember applies a roster event published by another instance.
+func (h *Hub) receiveMember(msg bus.Message) {
+	var payload memberPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Member.ClientID == "" {
+		h.log.Warn("malformed member message", zap.String("room", msg.RoomID), zap.Error(err))
+		return
+	}
+
+	state, ok := h.activeRoom(msg.RoomID)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	switch payload.Event {
+	case RosterJoin:
+		state.remote[payload.Member.ClientID] = remoteMember{origin: msg.Origin, entry: payload.Member}
+	case RosterLeave:
+		delete(state.remote, payload.Member.ClientID)
+	}
+	state.deliverRosterLocked(payload.Event, payload.Member.ClientID, nil)
+	local := state.localRosterLocked()
+	state.mu.Unlock()
+
+	if payload.Event == RosterJoin && len(local) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		defer cancel()
+		h.publishControl(ctx, msg.RoomID, busKindRosterSync, rosterSyncPayload{Members: local})
+	}
+}
+
+// receiveRosterSync replaces the members known for the sending instance.
+func (h *Hub) receiveRosterSync(msg bus.Message) {
+	var payload rosterSyncPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		h.log.Warn("malformed roster sync message", zap.String("room", msg.RoomID), zap.Error(err))
+		return
+	}
+
+	state, ok := h.activeRoom(msg.RoomID)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for id, member := range state.remote {
+		if member.origin == msg.Origin {
+			delete(state.remote, id)
+		}
+	}
+	for _, entry := range payload.Members {
+		state.remote[entry.ClientID] = remoteMember{origin: msg.Origin, entry: entry}
+	}
+	state.deliverRosterLocked(RosterSync, "", nil)
+}
+
+// activeRoom returns the room state when this instance currently serves it.
+func (h *Hub) activeRoom(roomID string) (*roomState, bool) {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+	state, ok := h.rooms[roomID]
+	return state, ok
+}
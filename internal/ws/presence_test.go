@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/config"
+)
+
+func TestPresenceStateThrottlesAndExpires(t *testing.T) {
+	var p presenceState
+	now := time.Now()
+	interval := 50 * time.Millisecond
+
+	applied, wait := p.update(&PresenceMessage{X: 1, Y: 1, Name: "A"}, now, interval)
+	require.True(t, applied)
+	require.Zero(t, wait)
+
+	applied, wait = p.update(&PresenceMessage{X: 2, Y: 2}, now.Add(10*time.Millisecond), interval)
+	require.False(t, applied)
+	require.Equal(t, 40*time.Millisecond, wait)
+
+	applied, wait = p.update(&PresenceMessage{X: 3, Y: 3}, now.Add(20*time.Millisecond), interval)
+	require.False(t, applied)
+	require.Zero(t, wait, "the pending update already has a flush due")
+
+	payload, _ := p.payload("room-1", "c1")
+	require.EqualValues(t, 1, payload.X)
+	require.True(t, p.flush(now.Add(interval)))
+	require.False(t, p.flush(now.Add(interval)))
+
+	payload, active := p.payload("room-1", "c1")
+	require.True(t, active)
+	require.EqualValues(t, 3, payload.X)
+	require.Equal(t, "A", payload.Name)
+
+	require.False(t, p.expire(now.Add(time.Second), 30*time.Second))
+	require.True(t, p.expire(now.Add(time.Minute), 30*time.Second))
+	_, active = p.payload("room-1", "c1")
+	require.False(t, active)
+}
+
+func TestRoomPresenceFanoutAndRoster(t *testing.T) {
+	hub := newTestHub(withConfig(config.Config{PresenceTTLSec: 30}))
+	state := hub.getOrCreateRoom("room-1")
+
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+
+	state.addClient(alice)
+	var roster RosterPayload
+	require.NoError(t, json.Unmarshal(<-alice.send, &roster))
+	require.Equal(t, RosterJoin, roster.Event)
+	require.Equal(t, "alice", roster.Self)
+
+	state.addClient(bob)
+	roster = RosterPayload{}
+	require.NoError(t, json.Unmarshal(<-alice.send, &roster))
+	require.Equal(t, "bob", roster.ClientID)
+	require.Empty(t, roster.Self)
+	require.Len(t, roster.Clients, 2)
+	<-bob.send
+
+	bob.handlePresence(&PresenceMessage{X: 5, Y: 6, Name: "Bob"})
+	var presence PresencePayload
+	require.NoError(t, json.Unmarshal(<-alice.send, &presence))
+	require.Equal(t, "bob", presence.ClientID)
+	require.EqualValues(t, 6, presence.Y)
+	require.Len(t, bob.send, 0)
+
+	state.removeClient(bob)
+	roster = RosterPayload{}
+	require.NoError(t, json.Unmarshal(<-alice.send, &roster))
+	require.Equal(t, RosterLeave, roster.Event)
+	require.Len(t, roster.Clients, 1)
+}
+
+func TestClientPresenceBurstEndsOnLastPosition(t *testing.T) {
+	hub := newTestHub(withConfig(config.Config{PresenceTTLSec: 30, PresenceIntervalMS: 20}))
+	state := hub.getOrCreateRoom("room-1")
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+	state.addClient(alice)
+	state.addClient(bob)
+	for len(alice.send) > 0 {
+		<-alice.send
+	}
+
+	for i := 1; i <= 10; i++ {
+		bob.handlePresence(&PresenceMessage{X: float64(i), Y: float64(i)})
+	}
+
+	var last PresencePayload
+	require.Eventually(t, func() bool {
+		for len(alice.send) > 0 {
+			require.NoError(t, json.Unmarshal(<-alice.send, &last))
+		}
+		return last.X == 10
+	}, time.Second, 5*time.Millisecond)
+	require.EqualValues(t, 10, last.Y)
+}
+
+func TestRosterSpansInstances(t *testing.T) {
+	shared := bus.NewMemoryBus()
+	newHub := func() *Hub {
+		return newTestHub(withConfig(config.Config{PresenceTTLSec: 30}), withBus(shared))
+	}
+	first, second := newHub(), newHub()
+
+	readRoster := func(c *client) RosterPayload {
+		var roster RosterPayload
+		require.NoError(t, json.Unmarshal(<-c.send, &roster))
+		return roster
+	}
+	ids := func(roster RosterPayload) []string {
+		out := make([]string, 0, len(roster.Clients))
+		for _, entry := range roster.Clients {
+			out = append(out, entry.ClientID)
+		}
+		return out
+	}
+
+	alice := newTestClient(first, "alice")
+	require.True(t, first.getOrCreateRoom("room-1").addClient(alice))
+	require.Equal(t, []string{"alice"}, ids(readRoster(alice)))
+
+	// bob joins on the other instance: alice hears about him and bob learns
+	// about alice through the sync answer.
+	bob := newTestClient(second, "bob")
+	require.True(t, second.getOrCreateRoom("room-1").addClient(bob))
+
+	joined := readRoster(bob)
+	require.Equal(t, RosterJoin, joined.Event)
+	require.Equal(t, "bob", joined.Self)
+
+	remoteJoin := readRoster(alice)
+	require.Equal(t, RosterJoin, remoteJoin.Event)
+	require.Equal(t, "bob", remoteJoin.ClientID)
+	require.Equal(t, []string{"alice", "bob"}, ids(remoteJoin))
+
+	synced := readRoster(bob)
+	require.Equal(t, RosterSync, synced.Event)
+	require.Equal(t, []string{"alice", "bob"}, ids(synced))
+
+	second.getOrCreateRoom("room-1").removeClient(bob)
+	left := readRoster(alice)
+	require.Equal(t, RosterLeave, left.Event)
+	require.Equal(t, []string{"alice"}, ids(left))
+}
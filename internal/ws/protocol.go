@@ -10,13 +10,52 @@ import (
 )
 
 const (
-	TypeHello    = "hello"
-	TypeOp       = "op"
-	TypePing     = "ping"
-	TypeSnapshot = "snapshot"
-	TypeDelta    = "delta"
-	TypePong     = "pong"
-	TypeError    = "error"
+	TypeHello     = "hello"
+	TypeOp        = "op"
+	TypePing      = "ping"
+	TypePresence  = "presence"
+	TypeRefresh   = "refresh"
+	TypeSnapshot  = "snapshot"
+	TypeDelta     = "delta"
+	TypePong      = "pong"
+	TypeRoster    = "roster"
+	TypeAck       = "ack"
+	TypeNack      = "nack"
+	TypeResync    = "resync"
+	TypeCaughtUp  = "caught_up"
+	TypeExpiring  = "expiring"
+	TypeRefreshed = "refreshed"
+	TypeSettings  = "settings"
+	TypeError     = "error"
+)
+
+// Subprotocol is the websocket subprotocol clients offer next to a
+// "bearer.<token>" entry when they pass their capability that way.
+const Subprotocol = "tacticboard"
+
+// Close codes sent by the hub, in the private 4000-4999 range.
+const (
+	// CloseSlowConsumer signals a client that could not keep up with the room.
+	CloseSlowConsumer = 4008
+	// CloseTokenExpired signals that the session's capability lapsed without
+	// being refreshed.
+	CloseTokenExpired = 4001
+	// CloseTokenRevoked signals that the session's capability was revoked.
+	CloseTokenRevoked = 4003
+	// CloseRoomDeleted signals that the room owner deleted the room.
+	CloseRoomDeleted = 4004
+	// CloseRateLimited signals a client that kept sending past its op rate limit.
+	CloseRateLimited = 4029
+)
+
+// Roster events describing room membership changes.
+const (
+	RosterJoin   = "join"
+	RosterLeave  = "leave"
+	RosterExpire = "expire"
+	// RosterSync refreshes the roster after another instance reported its
+	// members; it names no client.
+	RosterSync = "sync"
 )
 
 // Error codes that can be emitted to clients.
@@ -25,6 +64,9 @@ const (
 	ErrorConflict     = "conflict"
 	ErrorInvalid      = "invalid"
 	ErrorServer       = "server_error"
+	ErrorRateLimited  = "rate_limited"
+	ErrorReadOnly     = "read_only"
+	ErrorForbidden    = "forbidden"
 )
 
 // HelloMessage is the first message a client must send after connecting.
@@ -34,14 +76,21 @@ type HelloMessage struct {
 	Role   string `json:"cap"`
 	Since  int64  `json:"since"`
 	Token  string `json:"token"`
+	// Session is a client chosen identifier that survives reconnects and
+	// scopes batch de-duplication together with the id of the verified token,
+	// so one client cannot collide with another's batches. It defaults to the
+	// connection id.
+	Session string `json:"session,omitempty"`
 }
 
-// OpMessage carries an ordered batch of operations.
+// OpMessage carries an ordered batch of operations. When BatchID is set the
+// server answers with an ack or nack carrying the same id.
 type OpMessage struct {
-	Type   string            `json:"type"`
-	RoomID string            `json:"roomId"`
-	Seq    int64             `json:"seq"`
-	Ops    []json.RawMessage `json:"ops"`
+	Type    string            `json:"type"`
+	RoomID  string            `json:"roomId"`
+	Seq     int64             `json:"seq"`
+	BatchID string            `json:"batchId,omitempty"`
+	Ops     []json.RawMessage `json:"ops"`
 }
 
 // PingMessage keeps the connection alive.
@@ -50,11 +99,29 @@ type PingMessage struct {
 	TS   int64  `json:"ts"`
 }
 
+// PresenceMessage shares the sender's cursor with the room. It is never persisted.
+type PresenceMessage struct {
+	Type  string  `json:"type"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Name  string  `json:"name,omitempty"`
+	Color string  `json:"color,omitempty"`
+}
+
+// RefreshMessage replaces the session's capability with a newer token for the
+// same room and role.
+type RefreshMessage struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
 // ClientEnvelope is the decoded websocket payload.
 type ClientEnvelope struct {
-	Hello *HelloMessage
-	Op    *OpMessage
-	Ping  *PingMessage
+	Hello    *HelloMessage
+	Op       *OpMessage
+	Ping     *PingMessage
+	Presence *PresenceMessage
+	Refresh  *RefreshMessage
 }
 
 func DecodeClientMessage(data []byte) (ClientEnvelope, error) {
@@ -84,6 +151,18 @@ func DecodeClientMessage(data []byte) (ClientEnvelope, error) {
 			return ClientEnvelope{}, fmt.Errorf("decode ping: %w", err)
 		}
 		return ClientEnvelope{Ping: &msg}, nil
+	case TypePresence:
+		var msg PresenceMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return ClientEnvelope{}, fmt.Errorf("decode presence: %w", err)
+		}
+		return ClientEnvelope{Presence: &msg}, nil
+	case TypeRefresh:
+		var msg RefreshMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return ClientEnvelope{}, fmt.Errorf("decode refresh: %w", err)
+		}
+		return ClientEnvelope{Refresh: &msg}, nil
 	default:
 		return ClientEnvelope{}, errors.New("unsupported message type")
 	}
@@ -97,13 +176,95 @@ type SnapshotPayload struct {
 	State  json.RawMessage `json:"state"`
 }
 
-// DeltaPayload contains incremental updates applied to a room.
+// DeltaPayload contains incremental updates applied to a room. Author is
+// omitted for operations committed before authors were recorded; CommittedAt
+// is the server commit time in unix milliseconds.
 type DeltaPayload struct {
-	Type string            `json:"type"`
-	Room string            `json:"roomId"`
-	From int64             `json:"from"`
-	To   int64             `json:"to"`
-	Ops  []json.RawMessage `json:"ops"`
+	Type        string            `json:"type"`
+	Room        string            `json:"roomId"`
+	From        int64             `json:"from"`
+	To          int64             `json:"to"`
+	Ops         []json.RawMessage `json:"ops"`
+	Author      *model.Author     `json:"author,omitempty"`
+	CommittedAt int64             `json:"committedAt,omitempty"`
+}
+
+// PresencePayload relays a client's cursor to the rest of the room.
+type PresencePayload struct {
+	Type      string  `json:"type"`
+	RoomID    string  `json:"roomId"`
+	ClientID  string  `json:"clientId"`
+	Name      string  `json:"name"`
+	Color     string  `json:"color"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	UpdatedAt int64   `json:"updatedAt"`
+}
+
+// RosterEntry describes a connected client.
+type RosterEntry struct {
+	ClientID string `json:"clientId"`
+	Role     string `json:"cap"`
+	Name     string `json:"name,omitempty"`
+}
+
+// RosterPayload announces a membership change together with the full roster.
+type RosterPayload struct {
+	Type     string        `json:"type"`
+	RoomID   string        `json:"roomId"`
+	Event    string        `json:"event"`
+	ClientID string        `json:"clientId,omitempty"`
+	Self     string        `json:"self,omitempty"`
+	Clients  []RosterEntry `json:"clients"`
+}
+
+// AckPayload confirms that a client batch was committed under Seq.
+type AckPayload struct {
+	Type    string `json:"type"`
+	RoomID  string `json:"roomId"`
+	BatchID string `json:"batchId"`
+	Seq     int64  `json:"seq"`
+}
+
+// NackPayload reports that a client batch was not committed.
+type NackPayload struct {
+	Type    string `json:"type"`
+	RoomID  string `json:"roomId"`
+	BatchID string `json:"batchId"`
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+// ResyncPayload tells a client that it missed messages. It is followed by a
+// snapshot at Seq that replaces the client's board.
+type ResyncPayload struct {
+	Type   string `json:"type"`
+	RoomID string `json:"roomId"`
+	Seq    int64  `json:"seq"`
+}
+
+// CaughtUpPayload marks the end of catch-up; deltas after it are live.
+type CaughtUpPayload struct {
+	Type   string `json:"type"`
+	RoomID string `json:"roomId"`
+	Seq    int64  `json:"seq"`
+}
+
+// TokenStatusPayload warns that the session's capability is about to expire
+// (expiring) or confirms a refresh (refreshed). ExpiresAt is in unix
+// milliseconds.
+type TokenStatusPayload struct {
+	Type      string `json:"type"`
+	RoomID    string `json:"roomId"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// SettingsPayload announces the room settings after the owner changed them,
+// and on join when the room is read-only.
+type SettingsPayload struct {
+	Type     string `json:"type"`
+	RoomID   string `json:"roomId"`
+	ReadOnly bool   `json:"readOnly"`
 }
 
 // ErrorPayload transmits a problem to the client.
@@ -132,9 +293,86 @@ func EncodeDelta(op model.Operation) ([]byte, error) {
 		To:   op.Seq,
 		Ops:  op.Ops,
 	}
+	if op.Author.TokenID != "" {
+		author := op.Author
+		payload.Author = &author
+	}
+	if !op.CreatedAt.IsZero() {
+		payload.CommittedAt = op.CreatedAt.UnixMilli()
+	}
+	return json.Marshal(payload)
+}
+
+func EncodePresence(payload PresencePayload) ([]byte, error) {
+	payload.Type = TypePresence
+	return json.Marshal(payload)
+}
+
+func EncodeRoster(payload RosterPayload) ([]byte, error) {
+	payload.Type = TypeRoster
+	if payload.Clients == nil {
+		payload.Clients = []RosterEntry{}
+	}
 	return json.Marshal(payload)
 }
 
+func EncodeAck(roomID, batchID string, seq int64) []byte {
+	payload, _ := json.Marshal(AckPayload{
+		Type:    TypeAck,
+		RoomID:  roomID,
+		BatchID: batchID,
+		Seq:     seq,
+	})
+	return payload
+}
+
+func EncodeNack(roomID, batchID, code, msg string) []byte {
+	payload, _ := json.Marshal(NackPayload{
+		Type:    TypeNack,
+		RoomID:  roomID,
+		BatchID: batchID,
+		Code:    code,
+		Msg:     msg,
+	})
+	return payload
+}
+
+func EncodeResync(roomID string, seq int64) []byte {
+	payload, _ := json.Marshal(ResyncPayload{
+		Type:   TypeResync,
+		RoomID: roomID,
+		Seq:    seq,
+	})
+	return payload
+}
+
+func EncodeCaughtUp(roomID string, seq int64) []byte {
+	payload, _ := json.Marshal(CaughtUpPayload{
+		Type:   TypeCaughtUp,
+		RoomID: roomID,
+		Seq:    seq,
+	})
+	return payload
+}
+
+func EncodeTokenStatus(msgType, roomID string, expiresAt time.Time) []byte {
+	payload, _ := json.Marshal(TokenStatusPayload{
+		Type:      msgType,
+		RoomID:    roomID,
+		ExpiresAt: expiresAt.UnixMilli(),
+	})
+	return payload
+}
+
+func EncodeSettings(roomID string, settings model.RoomSettings) []byte {
+	payload, _ := json.Marshal(SettingsPayload{
+		Type:     TypeSettings,
+		RoomID:   roomID,
+		ReadOnly: settings.ReadOnly,
+	})
+	return payload
+}
+
 func EncodePong(ts int64) ([]byte, error) {
 	if ts == 0 {
 		ts = time.Now().UnixMilli()
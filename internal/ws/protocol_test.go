@@ -3,6 +3,7 @@ package ws
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/traweezy/tacticboard/internal/model"
@@ -38,6 +39,17 @@ func TestEncodeDelta(t *testing.T) {
 	require.EqualValues(t, 4, decoded.From)
 	require.EqualValues(t, 5, decoded.To)
 	require.Len(t, decoded.Ops, 1)
+	require.Nil(t, decoded.Author, "operations without an author omit it")
+	require.Zero(t, decoded.CommittedAt)
+
+	op.Author = model.Author{TokenID: "tok-1", Name: "Coach Kim"}
+	op.CreatedAt = time.UnixMilli(1700000000123)
+	payload, err = EncodeDelta(op)
+	require.NoError(t, err)
+	decoded = DeltaPayload{}
+	require.NoError(t, json.Unmarshal(payload, &decoded))
+	require.Equal(t, &op.Author, decoded.Author)
+	require.EqualValues(t, 1700000000123, decoded.CommittedAt)
 }
 
 func TestEncodeError(t *testing.T) {
@@ -58,3 +70,17 @@ func TestEncodeSnapshot(t *testing.T) {
 	require.EqualValues(t, 2, decoded.Seq)
 	require.JSONEq(t, `{"nodes":[]}`, string(decoded.State))
 }
+
+func TestDecodeClientMessage_Presence(t *testing.T) {
+	env, err := DecodeClientMessage([]byte(`{"type":"presence","x":10,"y":20,"name":"Coach","color":"#0af"}`))
+	require.NoError(t, err)
+	require.NotNil(t, env.Presence)
+	require.EqualValues(t, 10, env.Presence.X)
+	require.Equal(t, "Coach", env.Presence.Name)
+}
+
+func TestEncodeRoster(t *testing.T) {
+	payload, err := EncodeRoster(RosterPayload{RoomID: "room-1", Event: RosterLeave, ClientID: "c1"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type":"roster","roomId":"room-1","event":"leave","clientId":"c1","clients":[]}`, string(payload))
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultRateViolations = 10
+	rateViolationWindow   = 10 * time.Second
+)
+
+// newOpLimiter builds a token bucket over op batches. A non-positive rate
+// disables limiting.
+func newOpLimiter(rps float64, burst int) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// allowBatch charges one batch against the connection and room buckets. When
+// either is exhausted the batch is refused with rate_limited, and a client that
+// keeps hammering the limit is disconnected.
+func (c *client) allowBatch(msg *OpMessage, now time.Time) bool {
+	scope := ""
+	switch {
+	case c.limiter != nil && !c.limiter.AllowN(now, 1):
+		scope = "connection"
+	default:
+		if state := c.hub.getOrCreateRoom(c.roomID); state.limiter != nil && !state.limiter.AllowN(now, 1) {
+			scope = "room"
+		}
+	}
+	if scope == "" {
+		return true
+	}
+
+	c.hub.metrics.observeRateLimited(c.roomID, scope)
+	c.rejectBatch(msg.BatchID, ErrorRateLimited, "rate limit exceeded ("+scope+")")
+
+	if c.recordViolation(now) {
+		c.log.Warn("closing client after repeated rate limit violations", zap.String("scope", scope))
+		c.closeWith(CloseRateLimited, "rate limit exceeded")
+	}
+	return false
+}
+
+// recordViolation notes a refused batch and reports whether the client has
+// exceeded its allowance of violations within the window.
+func (c *client) recordViolation(now time.Time) bool {
+	recent := c.violations[:0]
+	for _, at := range c.violations {
+		if now.Sub(at) < rateViolationWindow {
+			recent = append(recent, at)
+		}
+	}
+	c.violations = append(recent, now)
+
+	limit := c.hub.cfg.WSRateViolations
+	if limit <= 0 {
+		limit = defaultRateViolations
+	}
+	return len(c.violations) >= limit
+}
@@ -0,0 +1,46 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestClientAllowBatchEnforcesConnectionLimit(t *testing.T) {
+	hub := newTestHub(withConfig(config.Config{WSRateViolations: 3}))
+	c := newTestClient(hub, "c1", withRole(util.RoleEdit), withLimiter(rate.NewLimiter(rate.Every(time.Hour), 2)))
+	now := time.Now()
+
+	require.True(t, c.allowBatch(&OpMessage{BatchID: "b1"}, now))
+	require.True(t, c.allowBatch(&OpMessage{BatchID: "b2"}, now))
+	require.False(t, c.allowBatch(&OpMessage{BatchID: "b3"}, now))
+
+	var nack NackPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &nack))
+	require.Equal(t, "b3", nack.BatchID)
+	require.Equal(t, ErrorRateLimited, nack.Code)
+
+	require.False(t, c.recordViolation(now))
+	require.True(t, c.recordViolation(now), "third violation within the window disconnects")
+	require.False(t, (&client{hub: hub}).recordViolation(now.Add(rateViolationWindow)))
+}
+
+func TestClientAllowBatchEnforcesRoomLimit(t *testing.T) {
+	hub := newTestHub(withConfig(config.Config{WSRoomOpRateRPS: 0.001, WSRoomOpRateBurst: 1}))
+	alice := newTestClient(hub, "alice", withRole(util.RoleEdit))
+	bob := newTestClient(hub, "bob", withRole(util.RoleEdit))
+	now := time.Now()
+
+	require.True(t, alice.allowBatch(&OpMessage{}, now))
+	require.False(t, bob.allowBatch(&OpMessage{}, now), "room bucket is shared by all connections")
+
+	var msg ErrorPayload
+	require.NoError(t, json.Unmarshal(<-bob.send, &msg))
+	require.Equal(t, ErrorRateLimited, msg.Code)
+}
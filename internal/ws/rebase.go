@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+const (
+	maxCommitAttempts = 5
+	// maxRebaseGap bounds how far behind head a batch may be based before the
+	// client is told to resync instead.
+	maxRebaseGap = 1000
+)
+
+var errBatchTooStale = errors.New("batch too stale")
+
+// commitBatch stores ops that author wrote on top of seq-1. Batches based on
+// an older head are rebased over the operations committed since and stored
+// under a server-assigned sequence. The returned operation carries no ops when
+// rebasing left nothing to apply; its Seq is then the current head. When
+// batchKey was committed before, the earlier operation is returned with
+// model.ErrDuplicateBatch. A non-nil authorize re-checks a rebased batch,
+// since the commits it was rebased over may have changed the nodes it touches.
+func (h *Hub) commitBatch(ctx context.Context, roomID string, seq int64, ops []board.Op, author model.Author, batchKey string, authorize func([]board.Op) error) (model.Operation, bool, error) {
+	base := seq - 1
+	if base < 0 {
+		return model.Operation{}, false, model.ErrSequenceConflict
+	}
+
+	for attempt := 0; attempt < maxCommitAttempts; attempt++ {
+		concurrent, err := h.store.OperationsSince(ctx, roomID, base, maxRebaseGap+1)
+		if err != nil {
+			return model.Operation{}, false, err
+		}
+		if len(concurrent) > maxRebaseGap {
+			return model.Operation{}, false, errBatchTooStale
+		}
+
+		head := base
+		rebased := ops
+		if len(concurrent) > 0 {
+			head = concurrent[len(concurrent)-1].Seq
+			rebased = board.Rebase(ops, decodeCommitted(concurrent))
+		}
+
+		if len(rebased) == 0 {
+			return model.Operation{RoomID: roomID, Seq: head}, true, nil
+		}
+		if authorize != nil && len(concurrent) > 0 {
+			if err := authorize(rebased); err != nil {
+				return model.Operation{}, false, err
+			}
+		}
+
+		canonical, err := board.EncodeBatch(rebased)
+		if err != nil {
+			return model.Operation{}, false, err
+		}
+
+		op, err := h.store.AppendOperation(ctx, model.Operation{
+			RoomID:   roomID,
+			Seq:      head + 1,
+			Ops:      canonical,
+			Author:   author,
+			BatchKey: batchKey,
+		})
+		if errors.Is(err, model.ErrDuplicateBatch) {
+			return op, false, err
+		}
+		if errors.Is(err, model.ErrSequenceConflict) {
+			if len(concurrent) == 0 && h.aheadOfHead(ctx, roomID, base) {
+				return model.Operation{}, false, err
+			}
+			continue
+		}
+		if err != nil {
+			return model.Operation{}, false, err
+		}
+
+		rebasedBatch := len(concurrent) > 0
+		if rebasedBatch {
+			h.log.Debug("rebased stale batch",
+				zap.String("room", roomID),
+				zap.Int64("base", base),
+				zap.Int64("seq", op.Seq),
+				zap.Int("dropped", len(ops)-len(rebased)),
+			)
+		}
+		return op, rebasedBatch, nil
+	}
+
+	return model.Operation{}, false, model.ErrSequenceConflict
+}
+
+// aheadOfHead reports whether base lies beyond the room's committed head, in
+// which case retrying cannot succeed.
+func (h *Hub) aheadOfHead(ctx context.Context, roomID string, base int64) bool {
+	room, err := h.store.GetRoom(ctx, roomID)
+	return err != nil || room.CurrentSeq < base
+}
+
+// decodeCommitted loosely decodes stored operations for rebasing. Entries that
+// predate schema validation and cannot be decoded are ignored.
+func decodeCommitted(ops []model.Operation) []board.Op {
+	decoded := make([]board.Op, 0, len(ops))
+	for _, op := range ops {
+		for _, raw := range op.Ops {
+			var entry board.Op
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				continue
+			}
+			decoded = append(decoded, entry)
+		}
+	}
+	return decoded
+}
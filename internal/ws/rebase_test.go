@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/board"
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+func decodeTestBatch(t *testing.T, raw ...string) []board.Op {
+	t.Helper()
+	msgs := make([]json.RawMessage, len(raw))
+	for i, r := range raw {
+		msgs[i] = json.RawMessage(r)
+	}
+	ops, err := board.DecodeBatch(msgs)
+	require.NoError(t, err)
+	return ops
+}
+
+func TestHubCommitBatchRebasesStaleSeq(t *testing.T) {
+	st := newTestStore(t)
+	hub := newTestHub(withStore(st))
+	ctx := context.Background()
+
+	first, rebased, err := hub.commitBatch(ctx, "room-1", 1, decodeTestBatch(t,
+		`{"k":"add","node":{"id":"p1","kind":"player","x":0,"y":0}}`,
+		`{"k":"add","node":{"id":"p2","kind":"player","x":0,"y":0}}`,
+	), model.Author{}, "", nil)
+	require.NoError(t, err)
+	require.False(t, rebased)
+	require.EqualValues(t, 1, first.Seq)
+
+	second, rebased, err := hub.commitBatch(ctx, "room-1", 2, decodeTestBatch(t, `{"k":"remove","id":"p1"}`), model.Author{}, "", nil)
+	require.NoError(t, err)
+	require.False(t, rebased)
+	require.EqualValues(t, 2, second.Seq)
+
+	// A second coach still on seq 1 moves both players.
+	stale, rebased, err := hub.commitBatch(ctx, "room-1", 2, decodeTestBatch(t,
+		`{"k":"move","id":"p1","x":5,"y":5}`,
+		`{"k":"move","id":"p2","x":7,"y":7}`,
+	), model.Author{}, "", nil)
+	require.NoError(t, err)
+	require.True(t, rebased)
+	require.EqualValues(t, 3, stale.Seq)
+	require.Len(t, stale.Ops, 1)
+	require.JSONEq(t, `{"k":"move","id":"p2","x":7,"y":7}`, string(stale.Ops[0]))
+
+	room, err := st.GetRoom(ctx, "room-1")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, room.CurrentSeq)
+}
+
+func TestHubCommitBatchFullySuperseded(t *testing.T) {
+	hub := newTestHub(withStore(newTestStore(t)))
+	ctx := context.Background()
+
+	_, _, err := hub.commitBatch(ctx, "room-1", 1, decodeTestBatch(t, `{"k":"remove","id":"p1"}`), model.Author{}, "", nil)
+	require.NoError(t, err)
+
+	op, rebased, err := hub.commitBatch(ctx, "room-1", 1, decodeTestBatch(t, `{"k":"move","id":"p1","x":1,"y":1}`), model.Author{}, "", nil)
+	require.NoError(t, err)
+	require.True(t, rebased)
+	require.Empty(t, op.Ops)
+	require.EqualValues(t, 1, op.Seq)
+}
+
+func TestHubCommitBatchRejectsSeqAheadOfHead(t *testing.T) {
+	hub := newTestHub(withStore(newTestStore(t)))
+
+	_, _, err := hub.commitBatch(context.Background(), "room-1", 9, decodeTestBatch(t, `{"k":"remove","id":"p1"}`), model.Author{}, "", nil)
+	require.ErrorIs(t, err, model.ErrSequenceConflict)
+}
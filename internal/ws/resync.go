@@ -0,0 +1,225 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+const (
+	resyncTimeout = 10 * time.Second
+	maxResyncs    = 3
+	resyncWindow  = time.Minute
+	// resyncReserve keeps room in the send buffer for the resync marker and
+	// snapshot that precede held messages.
+	resyncReserve = 2
+)
+
+// deltaPrefix starts every payload EncodeDelta produces; DeltaPayload
+// declares Type first.
+var deltaPrefix = []byte(`{"type":"` + TypeDelta + `"`)
+
+// holdLocked buffers payload while a resync is pending. A client that falls
+// further behind than the buffer can absorb, next to the messages still
+// waiting in it, is disconnected.
+func (c *client) holdLocked(payload []byte) error {
+	if len(c.held)+len(c.send) >= cap(c.send)-resyncReserve {
+		c.hub.metrics.observeDropped(c.roomID, 1)
+		go c.closeWith(CloseSlowConsumer, "slow consumer")
+		return errSlowConsumer
+	}
+	c.held = append(c.held, payload)
+	return nil
+}
+
+// flushLocked discards the deltas waiting in the send buffer, which the
+// resync snapshot supersedes, and returns how many it dropped. Acks, nacks,
+// roster, settings and token messages stay queued in order.
+func (c *client) flushLocked() int {
+	var kept [][]byte
+	dropped := 0
+	for drained := false; !drained; {
+		select {
+		case payload := <-c.send:
+			if bytes.HasPrefix(payload, deltaPrefix) {
+				dropped++
+				continue
+			}
+			kept = append(kept, payload)
+		default:
+			drained = true
+		}
+	}
+	// The buffer just held these, so they fit again.
+	for _, payload := range kept {
+		c.send <- payload
+	}
+	return dropped
+}
+
+// desyncLocked marks the client desynced and wakes its write loop to resync.
+func (c *client) desyncLocked() {
+	c.desynced = true
+	select {
+	case c.resyncCh <- struct{}{}:
+	default:
+	}
+}
+
+// requestResync schedules a resync for a client whose buffer is intact but
+// which may have missed messages upstream.
+func (c *client) requestResync() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed.Load() || c.desynced {
+		return
+	}
+	c.desyncLocked()
+}
+
+// resyncClients schedules a resync for every local client of the room.
+func (r *roomState) resyncClients() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for c := range r.clients {
+		c.requestResync()
+	}
+}
+
+// recoverBus rebuilds the room state this instance derives from the bus after
+// the bus reported lost messages. Local clients are resynced to a fresh
+// snapshot, settings are reloaded, deleted rooms are closed and sessions
+// whose token was revoked meanwhile are disconnected.
+func (h *Hub) recoverBus() {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+
+	h.roomsMu.RLock()
+	rooms := make([]*roomState, 0, len(h.rooms))
+	for _, state := range h.rooms {
+		rooms = append(rooms, state)
+	}
+	h.roomsMu.RUnlock()
+
+	h.log.Warn("bus lost messages; recovering rooms", zap.Int("rooms", len(rooms)))
+	for _, state := range rooms {
+		room, err := h.store.GetRoom(ctx, state.id)
+		if errors.Is(err, model.ErrRoomNotFound) {
+			h.closeRoom(state.id)
+			continue
+		}
+		if err != nil {
+			state.log.Warn("reload room after bus gap", zap.Error(err))
+			continue
+		}
+		state.mu.RLock()
+		tokens := make(map[string]struct{}, len(state.clients))
+		for c := range state.clients {
+			tokens[c.token.tokenID()] = struct{}{}
+		}
+		state.mu.RUnlock()
+
+		for tokenID := range tokens {
+			if tokenID == "" {
+				continue
+			}
+			revoked, err := h.store.IsTokenRevoked(ctx, state.id, tokenID)
+			if err != nil {
+				state.log.Warn("check revocation after bus gap", zap.Error(err))
+				continue
+			}
+			if revoked {
+				h.kick(state.id, tokenID)
+			}
+		}
+		// Settings queued after the resync request follow the snapshot.
+		state.resyncClients()
+		if room.Settings.ReadOnly != state.readOnly.Load() {
+			h.applySettings(state.id, room.Settings)
+		}
+	}
+}
+
+// resync replaces the deltas a slow consumer lost with a resync marker and a
+// snapshot materialized at head, then releases the messages held meanwhile.
+// Held deltas at or below the snapshot sequence are ignored by clients.
+func (c *client) resync() error {
+	now := time.Now()
+	if !c.allowResync(now) {
+		return errResyncLimited
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+
+	snapshot, _, err := materialize(ctx, c.hub.store, c.log, c.roomID)
+	if err != nil {
+		return err
+	}
+
+	snapshotPayload, err := EncodeSnapshot(c.roomID, snapshot)
+	if err != nil {
+		return err
+	}
+	marker := EncodeResync(c.roomID, snapshot.Seq)
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed.Load() {
+		return errClosedRoom
+	}
+
+	c.hub.metrics.observeDropped(c.roomID, int64(c.flushLocked()))
+	for _, payload := range append([][]byte{marker, snapshotPayload}, c.held...) {
+		select {
+		case c.send <- payload:
+		default:
+			return errSlowConsumer
+		}
+	}
+
+	c.held = nil
+	c.desynced = false
+	c.hub.metrics.observeResync(c.roomID)
+	c.log.Info("slow consumer resynced", zap.Int64("seq", snapshot.Seq))
+	return nil
+}
+
+// allowResync enforces the per-connection resync budget.
+func (c *client) allowResync(now time.Time) bool {
+	recent := c.resyncs[:0]
+	for _, at := range c.resyncs {
+		if now.Sub(at) < resyncWindow {
+			recent = append(recent, at)
+		}
+	}
+	c.resyncs = recent
+
+	if len(c.resyncs) >= maxResyncs {
+		return false
+	}
+	c.resyncs = append(c.resyncs, now)
+	return true
+}
+
+// closeWith sends a close frame with code and reason and tears down the
+// socket, which unblocks the read loop and runs the normal cleanup.
+func (c *client) closeWith(code int, reason string) {
+	if c.conn == nil || c.closing.Swap(true) {
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait)); err != nil {
+		c.log.Debug("write close control", zap.Error(err))
+	}
+	_ = c.conn.Close()
+}
@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestClientResyncSendsMarkerSnapshotAndHeld(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	_, err := st.CreateRoom(ctx, model.Room{
+		ID:       "room-1",
+		Snapshot: &model.Snapshot{RoomID: "room-1", State: json.RawMessage(`{"nodes":[]}`)},
+	})
+	require.NoError(t, err)
+	_, err = st.AppendOperation(ctx, model.Operation{
+		RoomID: "room-1",
+		Seq:    1,
+		Ops:    []json.RawMessage{json.RawMessage(`{"k":"add","node":{"id":"p1","kind":"player","x":1,"y":2}}`)},
+	})
+	require.NoError(t, err)
+
+	c := &client{
+		hub:      newTestHub(withStore(st)),
+		roomID:   "room-1",
+		send:     make(chan []byte, 8),
+		resyncCh: make(chan struct{}, 1),
+		log:      zap.NewNop(),
+		desynced: true,
+		held:     [][]byte{[]byte(`{"type":"delta","from":1,"to":2}`)},
+	}
+
+	require.NoError(t, c.resync())
+	require.False(t, c.desynced)
+	require.Empty(t, c.held)
+
+	var marker ResyncPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &marker))
+	require.Equal(t, TypeResync, marker.Type)
+	require.EqualValues(t, 1, marker.Seq)
+
+	var snapshot SnapshotPayload
+	require.NoError(t, json.Unmarshal(<-c.send, &snapshot))
+	require.EqualValues(t, 1, snapshot.Seq)
+	require.Contains(t, string(snapshot.State), `"p1"`)
+
+	require.JSONEq(t, `{"type":"delta","from":1,"to":2}`, string(<-c.send))
+}
+
+func TestClientAllowResyncBudget(t *testing.T) {
+	c := &client{}
+	now := time.Now()
+	for i := 0; i < maxResyncs; i++ {
+		require.True(t, c.allowResync(now))
+	}
+	require.False(t, c.allowResync(now))
+	require.True(t, c.allowResync(now.Add(resyncWindow+time.Second)))
+}
+
+func TestHubResyncsClientsOnBusDeltaGap(t *testing.T) {
+	shared := bus.NewMemoryBus()
+	hub := newTestHub(withBus(shared))
+	c := newTestClient(hub, "c1")
+	c.resyncCh = make(chan struct{}, 1)
+	require.True(t, hub.getOrCreateRoom("room-1").addClient(c))
+
+	publish := func(from, to int64) {
+		payload, err := json.Marshal(DeltaPayload{Type: TypeDelta, Room: "room-1", From: from, To: to})
+		require.NoError(t, err)
+		require.NoError(t, shared.Publish(context.Background(), bus.Message{Origin: "other", RoomID: "room-1", Payload: payload}))
+	}
+
+	publish(4, 5)
+	publish(5, 6)
+	require.False(t, c.desynced)
+
+	publish(7, 8)
+	require.True(t, c.desynced)
+	require.Len(t, c.resyncCh, 1)
+}
+
+func TestHubRecoversFromLostBusMessages(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-bus-gap"
+	seedRoom(t, st, room, 1)
+	srv := newTestServer(t, config.Config{}, st)
+
+	now := time.Now()
+	dial := func(tokenID string) *websocket.Conn {
+		token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+			RoomID:    room,
+			Role:      util.RoleView,
+			IssuedAt:  now,
+			ExpiresAt: now.Add(time.Hour),
+			TokenID:   tokenID,
+		})
+		require.NoError(t, err)
+		conn, _, err := websocket.DefaultDialer.Dial(srv.url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.WriteJSON(HelloMessage{Type: TypeHello, RoomID: room, Role: string(util.RoleView), Token: token}))
+		readUntil(t, conn, TypeCaughtUp)
+		return conn
+	}
+
+	leaked := dial("leaked")
+	kept := dial("kept")
+
+	// The revocation and settings change happen while the bus listener is
+	// down, so only the store knows about them.
+	ctx := context.Background()
+	require.NoError(t, st.RevokeToken(ctx, model.RevokedToken{RoomID: room, TokenID: "leaked"}))
+	require.NoError(t, st.UpdateRoomSettings(ctx, room, model.RoomSettings{ReadOnly: true}))
+	require.NoError(t, srv.hub.bus.Publish(ctx, bus.Message{Kind: bus.KindResync}))
+
+	code, _ := readClose(t, leaked)
+	require.Equal(t, CloseTokenRevoked, code)
+
+	readUntil(t, kept, TypeResync)
+	readUntil(t, kept, TypeSnapshot)
+	settings := readUntil(t, kept, TypeSettings)
+	require.JSONEq(t, "true", string(settings[len(settings)-1]["readOnly"]))
+}
@@ -0,0 +1,45 @@
+package ws
+
+import (
+	"context"
+)
+
+const busKindRevoke = "revoke"
+
+type revokePayload struct {
+	TokenID string `json:"tokenId"`
+}
+
+// RevokeToken disconnects every session in the room that acts under tokenID,
+// on this instance and, through the bus, on every other one. The caller is
+// responsible for persisting the revocation first so the token cannot be used
+// to reconnect.
+func (h *Hub) RevokeToken(ctx context.Context, roomID, tokenID string) {
+	h.kick(roomID, tokenID)
+	h.publishControl(ctx, roomID, busKindRevoke, revokePayload{TokenID: tokenID})
+}
+
+// kick closes local sessions of roomID using tokenID and returns how many.
+func (h *Hub) kick(roomID, tokenID string) int {
+	h.roomsMu.RLock()
+	state, ok := h.rooms[roomID]
+	h.roomsMu.RUnlock()
+	if !ok || tokenID == "" {
+		return 0
+	}
+
+	state.mu.RLock()
+	var victims []*client
+	for c := range state.clients {
+		if c.token.tokenID() == tokenID {
+			victims = append(victims, c)
+		}
+	}
+	state.mu.RUnlock()
+
+	for _, c := range victims {
+		c.log.Info("capability revoked; closing session")
+		c.closeWith(CloseTokenRevoked, "capability revoked")
+	}
+	return len(victims)
+}
@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/model"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+func TestHubRevokeTokenKicksSessions(t *testing.T) {
+	st := store.NewMemoryStore()
+	const room = "room-revoke"
+	seedRoom(t, st, room, 0)
+	srv := newTestServer(t, config.Config{}, st)
+
+	now := time.Now()
+	dial := func(tokenID string) *websocket.Conn {
+		token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+			RoomID:    room,
+			Role:      util.RoleView,
+			IssuedAt:  now,
+			ExpiresAt: now.Add(time.Hour),
+			TokenID:   tokenID,
+		})
+		require.NoError(t, err)
+		conn, _, err := websocket.DefaultDialer.Dial(srv.url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.WriteJSON(HelloMessage{Type: TypeHello, RoomID: room, Role: string(util.RoleView), Token: token}))
+		readUntil(t, conn, TypeCaughtUp)
+		return conn
+	}
+
+	leaked := dial("leaked")
+	kept := dial("kept")
+
+	ctx := context.Background()
+	require.NoError(t, st.RevokeToken(ctx, model.RevokedToken{RoomID: room, TokenID: "leaked"}))
+	srv.hub.RevokeToken(ctx, room, "leaked")
+
+	code, _ := readClose(t, leaked)
+	require.Equal(t, CloseTokenRevoked, code)
+
+	require.NoError(t, kept.WriteJSON(PingMessage{Type: TypePing, TS: 1}))
+	readUntil(t, kept, TypePong)
+
+	retry, _, err := websocket.DefaultDialer.Dial(srv.url, nil)
+	require.NoError(t, err)
+	defer retry.Close()
+	token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+		RoomID: room, Role: util.RoleView, IssuedAt: now, ExpiresAt: now.Add(time.Hour), TokenID: "leaked",
+	})
+	require.NoError(t, err)
+	require.NoError(t, retry.WriteJSON(HelloMessage{Type: TypeHello, RoomID: room, Role: string(util.RoleView), Token: token}))
+	readUntil(t, retry, TypeError)
+}
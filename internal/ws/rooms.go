@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+const (
+	roomHookTimeout = 10 * time.Second
+	// defaultRoomIdleGrace matches the batch de-duplication window so that an
+	// editor reconnecting to an empty room still has its batch ids remembered.
+	defaultRoomIdleGrace = batchSessionRetention
+)
+
+// RoomHook observes the lifecycle of rooms held by the hub. Any callback may be
+// nil. Callbacks run outside hub locks and receive a context bounded by a
+// short timeout.
+type RoomHook struct {
+	// OnActivate runs when the hub starts tracking a room for its first client.
+	OnActivate func(ctx context.Context, roomID string)
+	// OnIdle runs when the last client leaves a room.
+	OnIdle func(ctx context.Context, roomID string)
+	// OnEvict runs after an idle room outlived the grace period and its state
+	// was dropped from the hub.
+	OnEvict func(ctx context.Context, roomID string)
+}
+
+// AsRoomHook annotates a constructor returning RoomHook so the hub receives it
+// through the room_hooks value group.
+func AsRoomHook(constructor any) any {
+	return fx.Annotate(constructor, fx.ResultTags(`group:"room_hooks"`))
+}
+
+// joinRoom adds c to its room, retrying if the room state it found was evicted
+// concurrently. settings seed a newly activated room; afterwards they change
+// only through ApplySettings.
+func (h *Hub) joinRoom(c *client, settings model.RoomSettings) *roomState {
+	for {
+		state, created := h.lookupRoom(c.roomID)
+		if created {
+			state.readOnly.Store(settings.ReadOnly)
+			h.runRoomHooks(c.roomID, func(hook RoomHook) func(context.Context, string) { return hook.OnActivate })
+		}
+		if state.addClient(c) {
+			return state
+		}
+	}
+}
+
+// roomIdle runs the idle hooks for a room that just lost its last client and
+// then starts its grace period. A join or a drain stops the grace timer.
+func (h *Hub) roomIdle(r *roomState, generation uint64) {
+	go func() {
+		h.runRoomHooks(r.id, func(hook RoomHook) func(context.Context, string) { return hook.OnIdle })
+
+		grace := h.idleGrace
+		if grace <= 0 {
+			grace = defaultRoomIdleGrace
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.evicted || r.generation != generation || h.isDraining() {
+			return
+		}
+		r.stopIdleTimerLocked()
+		r.idleTimer = time.AfterFunc(grace, func() {
+			if h.evict(r, generation) {
+				h.runRoomHooks(r.id, func(hook RoomHook) func(context.Context, string) { return hook.OnEvict })
+			}
+		})
+	}()
+}
+
+// stopIdleTimerLocked cancels a pending eviction. Callers must hold r.mu.
+func (r *roomState) stopIdleTimerLocked() {
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
+
+// stopIdleTimers cancels every pending eviction.
+func (h *Hub) stopIdleTimers() {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+	for _, state := range h.rooms {
+		state.mu.Lock()
+		state.stopIdleTimerLocked()
+		state.mu.Unlock()
+	}
+}
+
+// evict drops the room state if nobody joined since it went idle.
+func (h *Hub) evict(r *roomState, generation uint64) bool {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.evicted || r.generation != generation || len(r.clients) > 0 {
+		return false
+	}
+
+	r.evicted = true
+	r.idleTimer = nil
+	if h.rooms[r.id] == r {
+		delete(h.rooms, r.id)
+	}
+	h.metrics.observeRooms(r.id, -1)
+	r.log.Info("room evicted")
+	return true
+}
+
+func (h *Hub) runRoomHooks(roomID string, pick func(RoomHook) func(context.Context, string)) {
+	for _, hook := range h.hooks {
+		fn := pick(hook)
+		if fn == nil {
+			continue
+		}
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), roomHookTimeout)
+			defer cancel()
+			defer func() {
+				if rec := recover(); rec != nil {
+					h.log.Error("room hook panicked", zap.String("room", roomID), zap.Any("panic", rec))
+				}
+			}()
+			fn(ctx, roomID)
+		}()
+	}
+}
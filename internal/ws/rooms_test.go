@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/traweezy/tacticboard/internal/model"
+)
+
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *hookRecorder) hook() RoomHook {
+	record := func(event string) func(context.Context, string) {
+		return func(_ context.Context, roomID string) {
+			r.mu.Lock()
+			r.events = append(r.events, event+":"+roomID)
+			r.mu.Unlock()
+		}
+	}
+	return RoomHook{OnActivate: record("activate"), OnIdle: record("idle"), OnEvict: record("evict")}
+}
+
+func (r *hookRecorder) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestHubEvictsIdleRoomAfterGrace(t *testing.T) {
+	recorder := &hookRecorder{}
+	hub := newTestHub(withIdleGrace(20*time.Millisecond), withHooks(recorder.hook()))
+
+	c := newTestClient(hub, "alice")
+	state := hub.joinRoom(c, model.RoomSettings{})
+	state.removeClient(c)
+
+	require.Eventually(t, func() bool {
+		hub.roomsMu.RLock()
+		defer hub.roomsMu.RUnlock()
+		return len(hub.rooms) == 0
+	}, time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(recorder.snapshot()) == 3
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"activate:room-1", "idle:room-1", "evict:room-1"}, recorder.snapshot())
+
+	rejoined := newTestClient(hub, "bob")
+	require.NotSame(t, state, hub.joinRoom(rejoined, model.RoomSettings{}))
+	require.Equal(t, "activate:room-1", recorder.snapshot()[3])
+}
+
+func TestHubKeepsRoomRejoinedDuringGrace(t *testing.T) {
+	recorder := &hookRecorder{}
+	hub := newTestHub(withIdleGrace(30*time.Millisecond), withHooks(recorder.hook()))
+
+	first := newTestClient(hub, "alice")
+	state := hub.joinRoom(first, model.RoomSettings{})
+	state.removeClient(first)
+
+	second := newTestClient(hub, "bob")
+	require.Same(t, state, hub.joinRoom(second, model.RoomSettings{}))
+
+	time.Sleep(80 * time.Millisecond)
+	hub.roomsMu.RLock()
+	_, ok := hub.rooms["room-1"]
+	hub.roomsMu.RUnlock()
+	require.True(t, ok)
+	require.NotContains(t, recorder.snapshot(), "evict:room-1")
+}
+
+func TestHubDrainStopsIdleTimers(t *testing.T) {
+	recorder := &hookRecorder{}
+	hub := newTestHub(withIdleGrace(30*time.Millisecond), withHooks(recorder.hook()))
+
+	c := newTestClient(hub, "alice")
+	state := hub.joinRoom(c, model.RoomSettings{})
+	state.removeClient(c)
+
+	require.Eventually(t, func() bool {
+		state.mu.RLock()
+		defer state.mu.RUnlock()
+		return state.idleTimer != nil
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, hub.Drain(context.Background()))
+	state.mu.RLock()
+	require.Nil(t, state.idleTimer)
+	state.mu.RUnlock()
+
+	time.Sleep(80 * time.Millisecond)
+	require.NotContains(t, recorder.snapshot(), "evict:room-1")
+}
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/traweezy/tacticboard/internal/auth"
+	"github.com/traweezy/tacticboard/internal/bus"
+	"github.com/traweezy/tacticboard/internal/config"
+	"github.com/traweezy/tacticboard/internal/observability"
+	"github.com/traweezy/tacticboard/internal/store"
+	"github.com/traweezy/tacticboard/internal/util"
+)
+
+const testSecret = "test-secret-0123456789"
+
+// testServer runs a hub behind a real websocket endpoint.
+type testServer struct {
+	hub   *Hub
+	store store.Store
+	url   string
+}
+
+func newTestServer(t *testing.T, cfg config.Config, st store.Store) *testServer {
+	t.Helper()
+
+	cfg.JWTSecret = testSecret
+	if cfg.WSReadLimit == 0 {
+		cfg.WSReadLimit = 1 << 20
+	}
+	telemetry := &observability.Telemetry{
+		TracerProvider: trace.NewNoopTracerProvider(),
+		MeterProvider:  noop.NewMeterProvider(),
+	}
+	hub := NewHub(cfg, st, bus.NewMemoryBus(), auth.NewVerifier(auth.NewStaticKeyring(cfg.JWTSecret), nil, st), zap.NewNop(), telemetry, nil)
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		hub.HandleConnection(context.Background(), conn, nil)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &testServer{
+		hub:   hub,
+		store: st,
+		url:   "ws" + strings.TrimPrefix(srv.URL, "http"),
+	}
+}
+
+func testToken(t *testing.T, roomID string, role util.CapabilityRole) string {
+	t.Helper()
+	now := time.Now()
+	token, err := util.GenerateCapabilityToken([]byte(testSecret), util.CapabilityClaims{
+		RoomID:    roomID,
+		Role:      role,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	return token
+}
+
+// join dials the server and sends a hello for roomID.
+func (s *testServer) join(t *testing.T, roomID string, role util.CapabilityRole, since int64) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.WriteJSON(HelloMessage{
+		Type:   TypeHello,
+		RoomID: roomID,
+		Role:   string(role),
+		Since:  since,
+		Token:  testToken(t, roomID, role),
+	}))
+	return conn
+}
+
+// readUntil returns messages up to and including the first of type msgType.
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string) []map[string]json.RawMessage {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var messages []map[string]json.RawMessage
+	for {
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+
+		var msg map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(data, &msg))
+		messages = append(messages, msg)
+
+		var got string
+		require.NoError(t, json.Unmarshal(msg["type"], &got))
+		if got == msgType {
+			return messages
+		}
+	}
+}
+
+func messageTypes(messages []map[string]json.RawMessage) []string {
+	types := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		var got string
+		_ = json.Unmarshal(msg["type"], &got)
+		types = append(types, got)
+	}
+	return types
+}
@@ -0,0 +1,7 @@
+create table if not exists bus_payloads (
+  id bigserial primary key,
+  body jsonb not null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists bus_payloads_created_at_idx on bus_payloads (created_at);
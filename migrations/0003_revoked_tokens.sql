@@ -0,0 +1,6 @@
+create table if not exists revoked_tokens (
+  room_id text not null references rooms(id) on delete cascade,
+  token_id text not null,
+  revoked_at timestamptz not null default now(),
+  primary key (room_id, token_id)
+);
@@ -0,0 +1 @@
+alter table rooms add column if not exists settings jsonb not null default '{}'::jsonb;
@@ -0,0 +1,11 @@
+create table if not exists share_ledger (
+  room_id text not null references rooms(id) on delete cascade,
+  token_id text not null,
+  role text not null,
+  issued_by text not null default '',
+  issued_at timestamptz not null default now(),
+  expires_at timestamptz not null,
+  primary key (room_id, token_id)
+);
+
+create index if not exists share_ledger_room_issued_idx on share_ledger (room_id, issued_at);
@@ -0,0 +1,14 @@
+create table if not exists join_codes (
+  code text primary key,
+  room_id text not null references rooms(id) on delete cascade,
+  role text not null,
+  restrictions jsonb,
+  created_by text not null default '',
+  created_at timestamptz not null default now(),
+  expires_at timestamptz not null,
+  token_not_after timestamptz not null,
+  max_uses integer not null,
+  uses integer not null default 0
+);
+
+create index if not exists join_codes_room_idx on join_codes (room_id);
@@ -0,0 +1,16 @@
+create table if not exists invites (
+  room_id text not null references rooms(id) on delete cascade,
+  id text not null,
+  role text not null,
+  restrictions jsonb,
+  created_by text not null default '',
+  created_at timestamptz not null default now(),
+  expires_at timestamptz not null,
+  token_not_after timestamptz not null,
+  max_redemptions integer not null,
+  redemptions integer not null default 0,
+  revoked_at timestamptz,
+  primary key (room_id, id)
+);
+
+create index if not exists invites_room_created_idx on invites (room_id, created_at);
@@ -0,0 +1,2 @@
+alter table ops add column if not exists author_token_id text not null default '';
+alter table ops add column if not exists author_name text not null default '';
@@ -0,0 +1,3 @@
+alter table ops add column if not exists batch_key text;
+
+create unique index if not exists ops_room_batch_key_idx on ops (room_id, batch_key) where batch_key is not null;
@@ -6,11 +6,18 @@ import { ThemeProvider } from '@mui/material/styles'
 import Typography from '@mui/material/Typography'
 import { QueryClient, QueryClientProvider } from '@tanstack/react-query'
 import { StageView } from '@canvas/StageView'
-import { useRoom } from '@net/api'
-import { deltaMessageSchema, errorMessageSchema, nodeSchema, snapshotMessageSchema } from '@net/schema'
+import { redeemInvite, useRoom } from '@net/api'
+import {
+  deltaMessageSchema,
+  errorMessageSchema,
+  nodeSchema,
+  presenceMessageSchema,
+  rosterMessageSchema,
+  snapshotMessageSchema
+} from '@net/schema'
 import { connectWS } from '@net/ws'
 import { useRoomStore } from '@state/store'
-import type { BoardNode, Operation, Presence } from '@state/store'
+import type { BoardNode, Operation } from '@state/store'
 import { LayerPanel } from '@ui/LayerPanel'
 import { ObjectPanel } from '@ui/ObjectPanel'
 import { Palette } from '@ui/Palette'
@@ -22,19 +29,6 @@ import { TacticToolbar } from '@ui/Toolbar'
 import { getInitialRoomContext } from './room-context'
 import { theme } from './theme'
 
-type PresenceMessage = {
-  type: 'presence'
-  clients: Presence[]
-}
-
-const isPresenceMessage = (value: unknown): value is PresenceMessage => {
-  if (!value || typeof value !== 'object') {
-    return false
-  }
-  const message = value as Partial<PresenceMessage>
-  return message.type === 'presence' && Array.isArray(message.clients)
-}
-
 const queryClient = new QueryClient({
   defaultOptions: {
     queries: {
@@ -45,7 +39,7 @@ const queryClient = new QueryClient({
 })
 
 const AppInner = () => {
-  const [{ roomId, token, capability }] = useState(() => getInitialRoomContext())
+  const [{ roomId, token, capability, invite }] = useState(() => getInitialRoomContext())
   const setRoom = useRoomStore((state) => state.setRoom)
   const applySnapshot = useRoomStore((state) => state.applySnapshot)
   const applyOperations = useRoomStore((state) => state.applyOperations)
@@ -56,11 +50,24 @@ const AppInner = () => {
   const snap = useRoomStore((state) => state.snap)
   const toggleSnap = useRoomStore((state) => state.toggleSnap)
   const updatePresence = useRoomStore((state) => state.updatePresence)
+  const applyRoster = useRoomStore((state) => state.applyRoster)
   const { push } = useToasts()
   const [shareOpen, setShareOpen] = useState(false)
   const latestSeqRef = useRef(latestSeq)
+  const redeemedInviteRef = useRef(false)
   const roomQuery = useRoom(roomId)
 
+  useEffect(() => {
+    // Every redemption uses up the invite, so only ever try once.
+    if (!invite || token || redeemedInviteRef.current) {
+      return
+    }
+    redeemedInviteRef.current = true
+    redeemInvite(roomId, invite)
+      .then((result) => window.location.replace(`${result.link}&cap=${result.role}`))
+      .catch((error: Error) => push({ message: error.message, severity: 'error' }))
+  }, [roomId, invite, token, push])
+
   useEffect(() => {
     setRoom(roomId, capability)
   }, [roomId, capability, setRoom])
@@ -173,8 +180,17 @@ const AppInner = () => {
             return
           }
 
-          if (isPresenceMessage(raw)) {
-            updatePresence(raw.clients)
+          const presence = presenceMessageSchema.safeParse(raw)
+          if (presence.success) {
+            const { clientId, name, color, x, y, updatedAt } = presence.data
+            updatePresence({ clientId, name, color, x, y, updatedAt })
+            return
+          }
+
+          const roster = rosterMessageSchema.safeParse(raw)
+          if (roster.success) {
+            const { event, clientId, self, clients } = roster.data
+            applyRoster({ event, clientId, self, clients })
           }
         },
         onError: () => {
@@ -186,7 +202,7 @@ const AppInner = () => {
     return () => {
       connection.close()
     }
-  }, [roomId, token, capability, applySnapshot, applyOperations, updatePresence, push, setConnected])
+  }, [roomId, token, capability, applySnapshot, applyOperations, updatePresence, applyRoster, push, setConnected])
 
   const toolbar = useMemo(
     () => (
@@ -236,7 +252,7 @@ const AppInner = () => {
       <Shell title="TacticBoard" toolbar={toolbar} sidePanel={sidePanel}>
         <StageView />
       </Shell>
-      <ShareDialog roomId={roomId} open={shareOpen} onClose={() => setShareOpen(false)} />
+      <ShareDialog roomId={roomId} token={token || undefined} open={shareOpen} onClose={() => setShareOpen(false)} />
     </>
   )
 }
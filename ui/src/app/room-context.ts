@@ -5,5 +5,6 @@ export const getInitialRoomContext = () => {
   const roomId = search.get('room') ?? roomFromPath ?? 'demo'
   const token = search.get('token') ?? ''
   const capability = (search.get('cap') as 'view' | 'edit' | null) ?? 'view'
-  return { roomId, token, capability }
+  const invite = search.get('invite') ?? ''
+  return { roomId, token, capability, invite }
 }
@@ -19,6 +19,12 @@ const shareResponseSchema = z.object({
   link: z.string()
 })
 
+const redeemResponseSchema = z.object({
+  token: z.string(),
+  role: z.enum(['view', 'edit']),
+  link: z.string()
+})
+
 const jsonFetch = async <T>(input: RequestInfo, init?: RequestInit) => {
   const response = await fetch(input, {
     ...init,
@@ -45,12 +51,18 @@ export const useRoom = (roomId: string | null) =>
 
 export const useShareRoom = () =>
   useMutation({
-    mutationFn: async (payload: { roomId: string; role: 'view' | 'edit'; ttlMinutes: number }) => {
+    mutationFn: async (payload: { roomId: string; role: 'view' | 'edit'; ttlMinutes: number; token?: string }) => {
       const body = JSON.stringify({ role: payload.role, ttlMinutes: payload.ttlMinutes })
-      const data = await jsonFetch(`/api/rooms/${payload.roomId}/share`, { method: 'POST', body })
+      const headers: Record<string, string> = payload.token ? { Authorization: `Bearer ${payload.token}` } : {}
+      const data = await jsonFetch(`/api/rooms/${payload.roomId}/share`, { method: 'POST', body, headers })
       return shareResponseSchema.parse(data)
     }
   })
 
+export const redeemInvite = async (roomId: string, inviteId: string) => {
+  const data = await jsonFetch(`/api/rooms/${roomId}/invites/${encodeURIComponent(inviteId)}/redeem`, { method: 'POST' })
+  return redeemResponseSchema.parse(data)
+}
+
 export type RoomResponse = z.infer<typeof roomSchema>
 export type ShareResponse = z.infer<typeof shareResponseSchema>
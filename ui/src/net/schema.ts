@@ -8,6 +8,7 @@ export const nodeSchema = z.object({
   rotation: z.number().optional(),
   color: z.string().optional(),
   label: z.string().optional(),
+  layer: z.string().optional(),
   points: z.array(z.number()).optional()
 })
 
@@ -30,7 +31,29 @@ export const deltaMessageSchema = z.object({
   roomId: z.string(),
   from: z.number(),
   to: z.number(),
-  ops: z.array(operationSchema)
+  ops: z.array(operationSchema),
+  author: z.object({ tokenId: z.string(), name: z.string().optional() }).optional(),
+  committedAt: z.number().optional()
+})
+
+export const presenceMessageSchema = z.object({
+  type: z.literal('presence'),
+  roomId: z.string(),
+  clientId: z.string(),
+  name: z.string(),
+  color: z.string(),
+  x: z.number(),
+  y: z.number(),
+  updatedAt: z.number()
+})
+
+export const rosterMessageSchema = z.object({
+  type: z.literal('roster'),
+  roomId: z.string(),
+  event: z.enum(['join', 'leave', 'expire', 'sync']),
+  clientId: z.string().optional(),
+  self: z.string().optional(),
+  clients: z.array(z.object({ clientId: z.string(), cap: z.string(), name: z.string().optional() }))
 })
 
 export const errorMessageSchema = z.object({
@@ -43,3 +66,5 @@ export type NodePayload = z.infer<typeof nodeSchema>
 export type OperationPayload = z.infer<typeof operationSchema>
 export type SnapshotMessage = z.infer<typeof snapshotMessageSchema>
 export type DeltaMessage = z.infer<typeof deltaMessageSchema>
+export type PresenceMessage = z.infer<typeof presenceMessageSchema>
+export type RosterMessage = z.infer<typeof rosterMessageSchema>
@@ -37,7 +37,7 @@ export const connectWS = ({ roomId, token, capability, since, callbacks }: Conne
 
   const connect = () => {
     if (closed) return
-    socket = new WebSocket(createUrl(roomId))
+    socket = new WebSocket(createUrl(roomId), ['tacticboard', `bearer.${token}`])
     const localSocket = socket
 
     localSocket.addEventListener('open', () => {
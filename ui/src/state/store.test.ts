@@ -0,0 +1,53 @@
+import { beforeEach, describe, expect, it } from 'vitest'
+
+import { useRoomStore } from './store'
+
+const cursor = (clientId: string, x = 0) => ({ clientId, name: clientId, color: '#fff', x, y: 0, updatedAt: 1 })
+
+describe('room store presence', () => {
+  beforeEach(() => {
+    useRoomStore.getState().setRoom('room-1', 'edit')
+  })
+
+  it('merges single cursor updates', () => {
+    const { updatePresence } = useRoomStore.getState()
+    updatePresence(cursor('a'))
+    updatePresence(cursor('b'))
+    updatePresence(cursor('a', 5))
+
+    const { presence } = useRoomStore.getState()
+    expect(Object.keys(presence).sort()).toEqual(['a', 'b'])
+    expect(presence.a.x).toBe(5)
+  })
+
+  it('drops cursors of clients that leave or expire', () => {
+    const { updatePresence, applyRoster } = useRoomStore.getState()
+    updatePresence(cursor('a'))
+    updatePresence(cursor('b'))
+    updatePresence(cursor('c'))
+
+    applyRoster({
+      event: 'leave',
+      clientId: 'c',
+      clients: [
+        { clientId: 'a', cap: 'edit' },
+        { clientId: 'b', cap: 'view' }
+      ]
+    })
+    expect(Object.keys(useRoomStore.getState().presence).sort()).toEqual(['a', 'b'])
+
+    applyRoster({
+      event: 'expire',
+      clientId: 'b',
+      self: 'a',
+      clients: [
+        { clientId: 'a', cap: 'edit' },
+        { clientId: 'b', cap: 'view' }
+      ]
+    })
+    const state = useRoomStore.getState()
+    expect(Object.keys(state.presence)).toEqual(['a'])
+    expect(state.roster).toHaveLength(2)
+    expect(state.selfId).toBe('a')
+  })
+})
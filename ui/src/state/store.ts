@@ -29,6 +29,19 @@ export type Presence = {
   updatedAt: number
 }
 
+export type RosterEntry = {
+  clientId: string
+  cap: string
+  name?: string
+}
+
+export type RosterUpdate = {
+  event: 'join' | 'leave' | 'expire' | 'sync'
+  clientId?: string
+  self?: string
+  clients: RosterEntry[]
+}
+
 export type SnapshotPayload = {
   seq: number
   nodes: BoardNode[]
@@ -44,6 +57,8 @@ export type RoomState = {
   tool: string
   snap: boolean
   presence: Record<string, Presence>
+  roster: RosterEntry[]
+  selfId: string | null
 }
 
 export type RoomActions = {
@@ -53,7 +68,8 @@ export type RoomActions = {
   applyOperations: (ops: Operation[], toSeq: number) => void
   setTool: (tool: string) => void
   toggleSnap: () => void
-  updatePresence: (presence: Presence[]) => void
+  updatePresence: (presence: Presence) => void
+  applyRoster: (update: RosterUpdate) => void
   selectNodes: (ids: string[]) => void
 }
 
@@ -68,6 +84,8 @@ export const useRoomStore = create<RoomState & RoomActions>()(
     tool: 'select',
     snap: true,
     presence: {},
+    roster: [],
+    selfId: null,
 
     setRoom: (roomId, capability) =>
       set({
@@ -76,7 +94,9 @@ export const useRoomStore = create<RoomState & RoomActions>()(
         nodes: {},
         latestSeq: 0,
         selectedIds: [],
-        presence: {}
+        presence: {},
+        roster: [],
+        selfId: null
       }),
 
     setConnected: (connected) => set({ connected }),
@@ -134,13 +154,25 @@ export const useRoomStore = create<RoomState & RoomActions>()(
 
     toggleSnap: () => set((state) => ({ snap: !state.snap })),
 
-    updatePresence: (presenceList) =>
-      set(() => {
-        const next: Record<string, Presence> = {}
-        for (const presence of presenceList) {
-          next[presence.clientId] = presence
+    updatePresence: (presence) =>
+      set((state) => ({ presence: { ...state.presence, [presence.clientId]: presence } })),
+
+    applyRoster: (update) =>
+      set((state) => {
+        // Drop cursors of clients that left the room or went quiet.
+        const present = new Set(update.clients.map((client) => client.clientId))
+        const presence: Record<string, Presence> = {}
+        for (const [clientId, cursor] of Object.entries(state.presence)) {
+          const expired = update.event === 'expire' && clientId === update.clientId
+          if (present.has(clientId) && !expired) {
+            presence[clientId] = cursor
+          }
+        }
+        return {
+          roster: update.clients,
+          selfId: update.self ?? state.selfId,
+          presence
         }
-        return { presence: next }
       }),
 
     selectNodes: (ids) => set({ selectedIds: ids })
@@ -0,0 +1,50 @@
+import { QueryClient, QueryClientProvider } from '@tanstack/react-query'
+import { fireEvent, render, screen, waitFor } from '@testing-library/react'
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest'
+
+import { ShareDialog } from './ShareDialog'
+
+describe('ShareDialog', () => {
+  const fetchMock = vi.fn()
+  const writeText = vi.fn()
+  const onClose = vi.fn()
+
+  beforeEach(() => {
+    fetchMock.mockResolvedValue(
+      new Response(JSON.stringify({ token: 'shared', role: 'view', link: '/room/abc?token=shared' }), {
+        status: 201,
+        headers: { 'Content-Type': 'application/json' }
+      })
+    )
+    writeText.mockResolvedValue(undefined)
+    vi.stubGlobal('fetch', fetchMock)
+    Object.defineProperty(navigator, 'clipboard', { value: { writeText }, configurable: true })
+  })
+
+  afterEach(() => {
+    vi.unstubAllGlobals()
+    fetchMock.mockReset()
+    writeText.mockReset()
+    onClose.mockReset()
+  })
+
+  it('sends the share request with the room token', async () => {
+    render(
+      <QueryClientProvider client={new QueryClient()}>
+        <ShareDialog roomId="abc" token="owner-token" open onClose={onClose} />
+      </QueryClientProvider>
+    )
+
+    fireEvent.click(screen.getByRole('button', { name: /copy link/i }))
+
+    await waitFor(() => expect(onClose).toHaveBeenCalled())
+    expect(fetchMock).toHaveBeenCalledWith(
+      '/api/rooms/abc/share',
+      expect.objectContaining({
+        method: 'POST',
+        headers: expect.objectContaining({ Authorization: 'Bearer owner-token' })
+      })
+    )
+    expect(writeText).toHaveBeenCalledWith('/room/abc?token=shared')
+  })
+})
@@ -18,17 +18,18 @@ dayjs.extend(relativeTime)
 
 export type ShareDialogProps = {
   roomId: string
+  token?: string
   open: boolean
   onClose: () => void
 }
 
-export const ShareDialog = ({ roomId, open, onClose }: ShareDialogProps) => {
+export const ShareDialog = ({ roomId, token, open, onClose }: ShareDialogProps) => {
   const [role, setRole] = useState<'view' | 'edit'>('view')
   const [ttl, setTtl] = useState(60)
   const shareMutation = useShareRoom()
 
   const handleShare = async () => {
-    const result = await shareMutation.mutateAsync({ roomId, role, ttlMinutes: ttl })
+    const result = await shareMutation.mutateAsync({ roomId, role, ttlMinutes: ttl, token })
     await navigator.clipboard.writeText(result.link)
     onClose()
   }